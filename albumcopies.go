@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// skippedAlbumCopyAction is the action of album copies left in the source
+const skippedAlbumCopyAction = "Skipped album copy, its other copy is linked into the album"
+
+// AlbumCopies records the media files of album folders that are copies of a
+// file elsewhere in the Takeout. Takeout puts a photo in the "Photos from
+// YYYY" folder of its year and again in the folder of every album it is in,
+// so each photo is moved once and linked into all of its albums.
+type AlbumCopies struct {
+	albums map[string][]*Album // Albums of each file kept, by path
+	copies map[string]bool     // Album copies left in the source, by path
+}
+
+// matchAlbumCopies works out the albums of every file across the Takeout.
+// Files are copies of each other when their sidecars have the same title and
+// photo taken time, or, for files of album
+// folders without a usable sidecar, the same content. Of each set of copies,
+// the first outside album folders is kept, or else the first album copy, and
+// gets the albums of all the others. Copies outside albums are kept as they
+// are, as they aren't album copies.
+func matchAlbumCopies(config *Config, files []MediaFile) *AlbumCopies {
+	c := &AlbumCopies{albums: make(map[string][]*Album), copies: make(map[string]bool)}
+
+	groups := make(map[string][]MediaFile)
+	var unidentified []MediaFile
+	for _, file := range files {
+		if key := sidecarIdentity(config, file); key != "" {
+			groups[key] = append(groups[key], file)
+		} else if albumFor(config, file.Dir) != nil {
+			unidentified = append(unidentified, file)
+		}
+	}
+	for key, group := range contentGroups(config, files, unidentified) {
+		groups[key] = group
+	}
+
+	keys := make([]string, 0, len(groups))
+	for key, group := range groups {
+		if len(group) > 1 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		group := groups[key]
+		sort.Slice(group, func(a, b int) bool {
+			return group[a].SourcePath() < group[b].SourcePath()
+		})
+
+		kept := -1
+		for i, file := range group {
+			if albumFor(config, file.Dir) == nil {
+				kept = i
+				break
+			}
+		}
+		if kept < 0 {
+			kept = 0
+		}
+
+		albums := c.albums[group[kept].Path]
+		if albums == nil {
+			if album := albumFor(config, group[kept].Dir); album != nil {
+				albums = append(albums, album)
+			}
+		}
+		for i, file := range group {
+			album := albumFor(config, file.Dir)
+			if i == kept || album == nil {
+				continue
+			}
+			c.copies[file.Path] = true
+			albums = appendAlbum(albums, album)
+		}
+		if len(albums) > 0 {
+			c.albums[group[kept].Path] = albums
+		}
+	}
+
+	for _, albums := range c.albums {
+		sort.Slice(albums, func(a, b int) bool {
+			return albums[a].Folder < albums[b].Folder
+		})
+	}
+	return c
+}
+
+// sidecarIdentity returns what identifies file through its sidecar: the
+// title and photo taken time of the sidecar, or "" without a sidecar giving
+// both. The file's own name isn't part of it, as Takeout adds "(n)" counters
+// to copies that clash in a folder. Edited copies and the videos of motion
+// photos share the sidecar of their original, so the edited suffix and the
+// extension of the file tell them apart.
+func sidecarIdentity(config *Config, file MediaFile) string {
+	if config.Sidecars == nil {
+		return ""
+	}
+	sidecar := config.Sidecars.Lookup(file)
+	if sidecar == nil || sidecar.Title == "" || sidecar.DateErr != nil || sidecar.Date.IsZero() {
+		return ""
+	}
+	ext := filepath.Ext(file.BaseName)
+	_, edited, _, _ := splitEditedSuffix(normalizeName(strings.TrimSuffix(file.BaseName, ext)))
+	return fmt.Sprintf("sidecar:%s|%d|%s|%s", normalizeName(sidecar.Title), sidecar.Date.Unix(), edited, strings.ToLower(ext))
+}
+
+// contentGroups groups the unidentified album files with the files outside
+// albums of the same content. Only files of the same size are hashed, and
+// files still inside an archive can't be compared.
+func contentGroups(config *Config, files, unidentified []MediaFile) map[string][]MediaFile {
+	sizes := make(map[int64]bool)
+	var candidates []MediaFile
+	for _, file := range unidentified {
+		if file.Archive != nil {
+			continue
+		}
+		if info, err := os.Stat(file.Path); err == nil {
+			sizes[info.Size()] = true
+			candidates = append(candidates, file)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	for _, file := range files {
+		if file.Archive != nil || albumFor(config, file.Dir) != nil {
+			continue
+		}
+		if info, err := os.Stat(file.Path); err == nil && sizes[info.Size()] {
+			candidates = append(candidates, file)
+		}
+	}
+
+	groups := make(map[string][]MediaFile)
+	for _, file := range candidates {
+		if sum, err := fileHash(file.Path); err == nil {
+			groups["content:"+sum] = append(groups["content:"+sum], file)
+		}
+	}
+	return groups
+}
+
+// appendAlbum appends album to albums unless it is already there
+func appendAlbum(albums []*Album, album *Album) []*Album {
+	for _, a := range albums {
+		if a.Folder == album.Folder {
+			return albums
+		}
+	}
+	return append(albums, album)
+}
+
+// split returns the files to process and the results of the album copies
+// left in the source
+func (c *AlbumCopies) split(files []MediaFile) ([]MediaFile, []Result) {
+	var kept []MediaFile
+	var skipped []Result
+	for _, file := range files {
+		if c.copies[file.Path] {
+			skipped = append(skipped, Result{File: file, Success: true, Action: skippedAlbumCopyAction})
+		} else {
+			kept = append(kept, file)
+		}
+	}
+	return kept, skipped
+}
+
+// albumsFor returns the albums of file: those worked out across the Takeout,
+// or the album of its own folder
+func albumsFor(config *Config, file MediaFile) []*Album {
+	if config.AlbumCopies != nil {
+		if albums, ok := config.AlbumCopies.albums[file.Path]; ok {
+			return albums
+		}
+	}
+	if album := albumFor(config, file.Dir); album != nil {
+		return []*Album{album}
+	}
+	return nil
+}
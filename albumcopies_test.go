@@ -0,0 +1,190 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAlbumCopies(t *testing.T) {
+	saveSupportedExtensions(t)
+	setSupportedExtensions([]string{".jpg"}, extSourceFallback)
+
+	sourceDir := t.TempDir()
+	year := filepath.Join(sourceDir, "Photos from 2019")
+	for _, dir := range []string{year, filepath.Join(sourceDir, "Trip"), filepath.Join(sourceDir, "Party")} {
+		writeMedia(t, filepath.Join(dir, "IMG_1.jpg"))
+		writeSidecar(t, filepath.Join(dir, "IMG_1.jpg.json"), "IMG_1.jpg", 1555083012)
+	}
+	writeMedia(t, filepath.Join(sourceDir, "Trip", "IMG_2.jpg"))
+	writeSidecar(t, filepath.Join(sourceDir, "Trip", "IMG_2.jpg.json"), "IMG_2.jpg", 1555083012)
+
+	// Without sidecars, copies are matched by content
+	for path, content := range map[string]string{
+		filepath.Join(year, "scan.jpg"):                 "scan",
+		filepath.Join(sourceDir, "Trip", "scan(1).jpg"): "scan",
+		filepath.Join(sourceDir, "Trip", "other.jpg"):   "other",
+		filepath.Join(year, "other-size.jpg"):           "other size",
+	} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for album, title := range map[string]string{"Trip": "Trip", "Party": "Party"} {
+		if err := os.WriteFile(filepath.Join(sourceDir, album, "metadata.json"), []byte(`{"title": "`+title+`"}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := &Config{SourceDirs: []string{sourceDir}, Move: t.TempDir(), Workers: 1, BatchSize: 1, AlbumModes: "symlink,json"}
+	if err := validateConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	mediaFiles, idx, err := scanTakeout(sourceDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Sidecars = idx
+	config.AlbumFolders = newAlbumFolders(idx)
+	config.AlbumCopies = matchAlbumCopies(config, mediaFiles)
+	mediaFiles, skipped := config.AlbumCopies.split(mediaFiles)
+
+	skippedPaths := make(map[string]bool)
+	for _, result := range skipped {
+		skippedPaths[result.File.Path] = true
+	}
+	expectedSkipped := map[string]bool{
+		filepath.Join(sourceDir, "Party", "IMG_1.jpg"):  true,
+		filepath.Join(sourceDir, "Trip", "IMG_1.jpg"):   true,
+		filepath.Join(sourceDir, "Trip", "scan(1).jpg"): true,
+	}
+	if !reflect.DeepEqual(skippedPaths, expectedSkipped) {
+		t.Errorf("Expected album copies %v to be skipped, got %v", expectedSkipped, skippedPaths)
+	}
+
+	backend := NewMemoryBackend()
+	for _, file := range mediaFiles {
+		if idx.Lookup(file) == nil {
+			backend.SetTags(file.Path, map[string]string{"DateTimeOriginal": "2019:04:12 15:30:12"})
+		}
+	}
+	results := processFiles(config, []MetadataBackend{backend}, mediaFiles)
+	for _, result := range results {
+		if !result.Success {
+			t.Fatalf("%s: %v", result.File.Path, result.Error)
+		}
+	}
+	if err := writeAlbumManifests(config, append(results, skipped...)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The year copy is linked into both albums it has copies in
+	day := filepath.ToSlash(filepath.Dir(generateDestinationPath("", "x", time.Unix(1555083012, 0))))
+	for _, album := range []string{"Trip", "Party"} {
+		if _, err := os.Lstat(generateAlbumSymlinkPath(config.OutputDir, album, "IMG_1.jpg")); err != nil {
+			t.Errorf("Expected IMG_1.jpg in album %s: %v", album, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(sourceDir, "Trip", "IMG_1.jpg")); err != nil {
+		t.Errorf("Expected the album copy to be left in the source: %v", err)
+	}
+
+	var albums map[string][]string
+	readJSONReport(t, filepath.Join(config.OutputDir, albumsManifest), &albums)
+	if got := albums["ALBUMS/Party"]; !reflect.DeepEqual(got, []string{day + "/IMG_1.jpg"}) {
+		t.Errorf("Expected Party to list the year copy, got %v", got)
+	}
+	if got := albums["ALBUMS/Trip"]; len(got) != 4 {
+		t.Errorf("Expected Trip to list IMG_1, IMG_2, scan and other, got %v", got)
+	}
+}
+
+// setupCounterCopies writes two year photos named IMG_0001.jpg whose copies in
+// the Trip album Takeout told apart with a "(1)" counter
+func setupCounterCopies(t *testing.T) (*Config, []MediaFile) {
+	t.Helper()
+	saveSupportedExtensions(t)
+	setSupportedExtensions([]string{".jpg"}, extSourceFallback)
+
+	sourceDir := t.TempDir()
+	photos := []struct {
+		year, albumName, sidecarName string
+		timestamp                    int64
+	}{
+		{"Photos from 2015", "IMG_0001.jpg", "IMG_0001.jpg.json", 1429000000},
+		{"Photos from 2019", "IMG_0001(1).jpg", "IMG_0001.jpg(1).json", 1555083012},
+	}
+	for _, photo := range photos {
+		writeMedia(t, filepath.Join(sourceDir, photo.year, "IMG_0001.jpg"))
+		writeSidecar(t, filepath.Join(sourceDir, photo.year, "IMG_0001.jpg.json"), "IMG_0001.jpg", photo.timestamp)
+		writeMedia(t, filepath.Join(sourceDir, "Trip", photo.albumName))
+		writeSidecar(t, filepath.Join(sourceDir, "Trip", photo.sidecarName), "IMG_0001.jpg", photo.timestamp)
+	}
+	if err := os.WriteFile(filepath.Join(sourceDir, "Trip", "metadata.json"), []byte(`{"title": "Trip"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &Config{SourceDirs: []string{sourceDir}, Move: t.TempDir(), Workers: 1, BatchSize: 1, AlbumModes: albumSymlink}
+	if err := validateConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	mediaFiles, idx, err := scanTakeout(sourceDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Sidecars = idx
+	config.AlbumFolders = newAlbumFolders(idx)
+	config.AlbumCopies = matchAlbumCopies(config, mediaFiles)
+	return config, mediaFiles
+}
+
+func TestAlbumCopiesWithCounters(t *testing.T) {
+	config, mediaFiles := setupCounterCopies(t)
+
+	kept, skipped := config.AlbumCopies.split(mediaFiles)
+	if len(skipped) != 2 || len(kept) != 2 {
+		t.Fatalf("Expected both album copies to be skipped, got %d kept and %d skipped", len(kept), len(skipped))
+	}
+	for _, file := range kept {
+		if filepath.Base(file.Dir) == "Trip" {
+			t.Errorf("Expected %s to be matched to its year copy", file.Path)
+		}
+		if albums := albumsFor(config, file); len(albums) != 1 || albums[0].Folder != "Trip" {
+			t.Errorf("Expected %s to be in Trip, got %v", file.Path, albums)
+		}
+	}
+}
+
+func TestAlbumEntriesOfSameNamedPhotos(t *testing.T) {
+	config, mediaFiles := setupCounterCopies(t)
+	mediaFiles, _ = config.AlbumCopies.split(mediaFiles)
+	results := processFiles(config, []MetadataBackend{NewMemoryBackend()}, mediaFiles)
+
+	// Both year photos are named IMG_0001.jpg and each keeps its own entry
+	targets := make(map[string]bool)
+	for _, result := range results {
+		if !result.Success {
+			t.Fatalf("%s: %v", result.File.Path, result.Error)
+		}
+		if len(result.AlbumLinks) != 1 {
+			t.Fatalf("Expected one album entry for %s, got %v", result.File.Path, result.AlbumLinks)
+		}
+		target, err := filepath.EvalSymlinks(result.AlbumLinks[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dest, _ := filepath.EvalSymlinks(result.DestPath); target != dest {
+			t.Errorf("Expected %s to point at %s, got %s", result.AlbumLinks[0], dest, target)
+		}
+		targets[target] = true
+	}
+	entries, err := os.ReadDir(filepath.Join(config.OutputDir, "ALBUMS", "Trip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || len(targets) != 2 {
+		t.Errorf("Expected two entries for two photos, got %d entries for %d targets", len(entries), len(targets))
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Files written to each album folder next to its photos
+const (
+	albumInfoFile   = "album.json" // metadata.json of the album as found in the Takeout
+	albumReadmeFile = "README.md"  // The album rendered for reading
+)
+
+// albumDescriptionTag receives the album description with -albums description
+const albumDescriptionTag = "XMP-dc:Description"
+
+// AlbumMetadata represents the structure of album metadata.json files. Raw
+// keeps the whole file, including fields not parsed here.
+type AlbumMetadata struct {
+	Title       string            `json:"title"`
+	Description string            `json:"description,omitempty"`
+	Access      string            `json:"access,omitempty"`
+	Location    string            `json:"location,omitempty"`
+	Date        *AlbumDate        `json:"date,omitempty"`
+	GeoData     *AlbumGeoData     `json:"geoData,omitempty"`
+	Enrichments []AlbumEnrichment `json:"enrichments,omitempty"`
+
+	Raw json.RawMessage `json:"-"`
+}
+
+// AlbumDate is the date of an album, as a Unix timestamp string
+type AlbumDate struct {
+	Timestamp string `json:"timestamp"`
+	Formatted string `json:"formatted,omitempty"`
+}
+
+// AlbumGeoData is the location of an album, zero when it has none
+type AlbumGeoData struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Altitude  float64 `json:"altitude"`
+}
+
+// AlbumEnrichment is an item added between the photos of an album: a text
+// note, a location or a map from one place to another. One field is set.
+type AlbumEnrichment struct {
+	Narrative *AlbumNarrative `json:"narrativeEnrichment,omitempty"`
+	Location  *AlbumLocation  `json:"locationEnrichment,omitempty"`
+	Map       *AlbumMap       `json:"mapEnrichment,omitempty"`
+}
+
+// AlbumNarrative is a text note of an album
+type AlbumNarrative struct {
+	Text string `json:"text"`
+}
+
+// AlbumLocation is a location added to an album
+type AlbumLocation struct {
+	Location albumPlaces `json:"location"`
+}
+
+// AlbumMap is a map from an origin to a destination added to an album
+type AlbumMap struct {
+	Origin      albumPlaces `json:"origin"`
+	Destination albumPlaces `json:"destination"`
+}
+
+// AlbumPlace is a place of an enrichment, with coordinates in 1e-7 degrees
+type AlbumPlace struct {
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	LatitudeE7  int64  `json:"latitudeE7,omitempty"`
+	LongitudeE7 int64  `json:"longitudeE7,omitempty"`
+}
+
+// albumPlaces holds the places of an enrichment, which Takeout writes as a
+// single object or a list depending on its age
+type albumPlaces []AlbumPlace
+
+func (p *albumPlaces) UnmarshalJSON(data []byte) error {
+	if data = bytes.TrimSpace(data); len(data) > 0 && data[0] == '{' {
+		var place AlbumPlace
+		if err := json.Unmarshal(data, &place); err != nil {
+			return err
+		}
+		*p = albumPlaces{place}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]AlbumPlace)(p))
+}
+
+// parseAlbumMetadata decodes a metadata.json. Metadata with unexpected
+// fields still gives its title. It returns nil if data isn't a JSON object.
+func parseAlbumMetadata(data []byte) *AlbumMetadata {
+	var metadata AlbumMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		var title struct {
+			Title string `json:"title"`
+		}
+		if json.Unmarshal(data, &title) != nil {
+			return nil
+		}
+		metadata = AlbumMetadata{Title: title.Title}
+	}
+	metadata.Raw = data
+	return &metadata
+}
+
+// readAlbumMetadata reads the metadata.json of dir, or returns nil
+func readAlbumMetadata(dir string) *AlbumMetadata {
+	data, err := os.ReadFile(filepath.Join(dir, "metadata.json"))
+	if err != nil {
+		return nil
+	}
+	return parseAlbumMetadata(data)
+}
+
+// albumMetadata returns the album metadata of the media files of dir, from
+// the index or read from dir itself, or nil
+func albumMetadata(config *Config, dir string) *AlbumMetadata {
+	if config.Sidecars != nil {
+		if metadata := config.Sidecars.SidecarIn(dir, "metadata.json"); metadata != nil {
+			return metadata.Album
+		}
+	}
+	return readAlbumMetadata(dir)
+}
+
+// Time returns the date of the album, if it has a valid one
+func (m *AlbumMetadata) Time() (time.Time, bool) {
+	if m.Date == nil {
+		return time.Time{}, false
+	}
+	timestamp, err := strconv.ParseInt(m.Date.Timestamp, 10, 64)
+	if err != nil || timestamp <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(timestamp, 0).UTC(), true
+}
+
+// String formats a place as its name, description and coordinates
+func (p AlbumPlace) String() string {
+	var parts []string
+	if p.Name != "" {
+		parts = append(parts, p.Name)
+	}
+	if p.Description != "" {
+		parts = append(parts, p.Description)
+	}
+	s := strings.Join(parts, ", ")
+	if p.LatitudeE7 != 0 || p.LongitudeE7 != 0 {
+		s = strings.TrimSpace(fmt.Sprintf("%s (%.5f, %.5f)", s, float64(p.LatitudeE7)/1e7, float64(p.LongitudeE7)/1e7))
+	}
+	return s
+}
+
+// joinPlaces formats places, separated by " / "
+func joinPlaces(places albumPlaces) string {
+	names := make([]string, 0, len(places))
+	for _, place := range places {
+		if s := place.String(); s != "" {
+			names = append(names, markdownEscape(s))
+		}
+	}
+	return strings.Join(names, " / ")
+}
+
+// markdownEscape escapes the characters Markdown could read as formatting
+var markdownEscape = strings.NewReplacer(
+	`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "[", `\[`, "]", `\]`,
+	"<", `\<`, ">", `\>`, "#", `\#`, "|", `\|`,
+).Replace
+
+// markdownText escapes text and keeps its line breaks
+func markdownText(text string) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	for i, line := range lines {
+		lines[i] = markdownEscape(strings.TrimRight(line, " \r"))
+	}
+	return strings.Join(lines, "  \n")
+}
+
+// renderAlbumReadme renders album as Markdown: its title, description, date,
+// location and access, its notes, locations and maps in album order, and
+// links to entries, the names of its photos in the album folder
+func renderAlbumReadme(album *Album, entries []string) string {
+	metadata := album.Metadata
+	if metadata == nil {
+		metadata = &AlbumMetadata{Title: album.Title}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", markdownEscape(album.Title))
+	if metadata.Description != "" {
+		fmt.Fprintf(&b, "%s\n\n", markdownText(metadata.Description))
+	}
+
+	var facts []string
+	if date, ok := metadata.Time(); ok {
+		facts = append(facts, "**Date:** "+date.Format("January 2, 2006"))
+	}
+	location := markdownEscape(metadata.Location)
+	if geo := metadata.GeoData; geo != nil && (geo.Latitude != 0 || geo.Longitude != 0) {
+		location = strings.TrimSpace(fmt.Sprintf("%s (%.5f, %.5f)", location, geo.Latitude, geo.Longitude))
+	}
+	if location != "" {
+		facts = append(facts, "**Location:** "+location)
+	}
+	if metadata.Access != "" {
+		facts = append(facts, "**Access:** "+markdownEscape(metadata.Access))
+	}
+	for _, fact := range facts {
+		fmt.Fprintf(&b, "- %s\n", fact)
+	}
+	if len(facts) > 0 {
+		b.WriteString("\n")
+	}
+
+	var notes []string
+	for _, enrichment := range metadata.Enrichments {
+		switch {
+		case enrichment.Narrative != nil && strings.TrimSpace(enrichment.Narrative.Text) != "":
+			notes = append(notes, markdownText(enrichment.Narrative.Text))
+		case enrichment.Location != nil && len(enrichment.Location.Location) > 0:
+			notes = append(notes, "**Location:** "+joinPlaces(enrichment.Location.Location))
+		case enrichment.Map != nil:
+			notes = append(notes, fmt.Sprintf("**Map:** %s → %s", joinPlaces(enrichment.Map.Origin), joinPlaces(enrichment.Map.Destination)))
+		}
+	}
+	if len(notes) > 0 {
+		fmt.Fprintf(&b, "## Notes\n\n%s\n\n", strings.Join(notes, "\n\n"))
+	}
+
+	if len(entries) > 0 {
+		b.WriteString("## Photos\n\n")
+		for _, entry := range entries {
+			fmt.Fprintf(&b, "- [%s](<%s>)\n", markdownEscape(entry), entry)
+		}
+	}
+	return strings.TrimRight(b.String(), "\n") + "\n"
+}
+
+// albumInfo returns the album.json of album: its metadata.json indented, or
+// its title when it has none
+func albumInfo(album *Album) ([]byte, error) {
+	var out bytes.Buffer
+	if album.Metadata != nil && len(album.Metadata.Raw) > 0 {
+		if err := json.Indent(&out, album.Metadata.Raw, "", "  "); err != nil {
+			return nil, err
+		}
+	} else {
+		data, err := json.MarshalIndent(AlbumMetadata{Title: album.Title}, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		out.Write(data)
+	}
+	out.WriteByte('\n')
+	return out.Bytes(), nil
+}
+
+// writeAlbumInfo writes album.json and README.md into every album folder
+// created for results
+func writeAlbumInfo(config *Config, results []Result) error {
+	albums := make(map[string]*Album)
+	entries := make(map[string][]string)
+	for _, result := range results {
+		if !result.Success {
+			continue
+		}
+		// Album entries are created in the order of the albums of the file
+		for i, albumLink := range result.AlbumLinks {
+			if i < len(result.Albums) {
+				folder := filepath.Dir(albumLink)
+				albums[folder] = result.Albums[i]
+				entries[folder] = append(entries[folder], filepath.Base(albumLink))
+			}
+		}
+	}
+	if config.Albums.Link == "" || (len(albums) == 0 && !config.DryRun) {
+		return nil
+	}
+	if config.DryRun {
+		fmt.Printf("Album info: would write %s and %s to each album folder\n", albumInfoFile, albumReadmeFile)
+		return nil
+	}
+
+	var errs []error
+	for folder, album := range albums {
+		sort.Strings(entries[folder])
+		info, err := albumInfo(album)
+		if err == nil {
+			err = os.MkdirAll(folder, 0755)
+		}
+		if err == nil {
+			err = os.WriteFile(filepath.Join(folder, albumInfoFile), info, 0644)
+		}
+		if err == nil {
+			err = os.WriteFile(filepath.Join(folder, albumReadmeFile), []byte(renderAlbumReadme(album, entries[folder])), 0644)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to write album info to %s: %v", folder, err))
+		}
+	}
+	return errors.Join(errs...)
+}
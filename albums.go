@@ -0,0 +1,487 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Album link modes: how an ALBUMS folder holds the photos of its album
+const (
+	albumSymlink  = "symlink"  // Relative symlinks into ALL_PHOTOS
+	albumHardlink = "hardlink" // Hard links, no extra space on the same disk
+	albumCopy     = "copy"     // Real copies, which survive any destination
+)
+
+// Album outputs that can be combined with a link mode
+const (
+	albumM3U         = "m3u"         // An .m3u playlist per album next to its folder
+	albumJSON        = "json"        // albums.json listing the members of every album
+	albumXMP         = "xmp"         // Album titles added to the XMP keywords of members
+	albumDescription = "description" // Album descriptions added as the XMP description of members
+	albumNone        = "none"        // No album folders at all
+)
+
+// albumFoldersFile maps the title of every album to its folder under ALBUMS
+const albumFoldersFile = "album-folders.json"
+
+// maxAlbumFolderRunes keeps album folders short enough for the files in them
+// to fit within path length limits
+const maxAlbumFolderRunes = 100
+
+// untitledAlbum names the folder of an album whose title leaves nothing usable
+const untitledAlbum = "Untitled album"
+
+// Album is an album of the Takeout and the folder it gets under ALBUMS
+type Album struct {
+	Title  string // Title from metadata.json
+	Folder string // Folder name under ALBUMS, unique and safe on every platform
+	Source string // Folder of metadata.json in the Takeout
+
+	Metadata *AlbumMetadata // Whole metadata.json, nil if it couldn't be read
+}
+
+// Description returns the description of the album, if it has one
+func (a *Album) Description() string {
+	if a.Metadata == nil {
+		return ""
+	}
+	return strings.TrimSpace(a.Metadata.Description)
+}
+
+// AlbumFolders gives every album of a Takeout its own folder under ALBUMS,
+// so albums with the same title, or titles only differing in case or in
+// characters a platform doesn't allow, are kept apart
+type AlbumFolders struct {
+	albums []*Album
+	byDir  map[string]*Album // Keyed by dirKey of the album folder
+}
+
+// newAlbumFolders assigns folders to the albums of idx. Albums are numbered
+// in the order of their folder in the Takeout, so the same Takeout always
+// gives the same folders.
+func newAlbumFolders(idx *SidecarIndex) *AlbumFolders {
+	folders := &AlbumFolders{byDir: make(map[string]*Album)}
+	keys := make([]string, 0, len(idx.dirs))
+	for key, dir := range idx.dirs {
+		if metadata := dir.sidecars["metadata.json"]; metadata != nil && metadata.Album != nil && metadata.Title != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	used := make(map[string]bool)
+	for _, key := range keys {
+		metadata := idx.dirs[key].sidecars["metadata.json"]
+		album := &Album{
+			Title:    metadata.Title,
+			Folder:   uniqueAlbumFolder(albumFolderName(metadata.Title), used),
+			Source:   filepath.Dir(metadata.Path),
+			Metadata: metadata.Album,
+		}
+		folders.albums = append(folders.albums, album)
+		folders.byDir[key] = album
+	}
+	return folders
+}
+
+// albumFolderName returns a folder name for an album titled title, safe on
+// every platform and at most maxAlbumFolderRunes long
+func albumFolderName(title string) string {
+	name := sanitizePathSegment(normalizeName(title))
+	if runes := []rune(name); len(runes) > maxAlbumFolderRunes {
+		name = sanitizePathSegment(string(runes[:maxAlbumFolderRunes]))
+	}
+	if name == "" {
+		return untitledAlbum
+	}
+	return name
+}
+
+// uniqueAlbumFolder returns name, or name with a " (n)" counter if it is
+// already used, ignoring case for case-insensitive file systems
+func uniqueAlbumFolder(name string, used map[string]bool) string {
+	folder := name
+	for n := 2; used[strings.ToLower(folder)]; n++ {
+		folder = fmt.Sprintf("%s (%d)", name, n)
+	}
+	used[strings.ToLower(folder)] = true
+	return folder
+}
+
+// albumFor returns the album of the media files of dir, or nil if dir isn't
+// an album folder
+func albumFor(config *Config, dir string) *Album {
+	if config.AlbumFolders != nil && config.Sidecars != nil {
+		return config.AlbumFolders.byDir[config.Sidecars.dirKey(dir)]
+	}
+	metadata := albumMetadata(config, dir)
+	if metadata == nil || metadata.Title == "" {
+		return nil
+	}
+	return &Album{Title: metadata.Title, Folder: albumFolderName(metadata.Title), Source: dir, Metadata: metadata}
+}
+
+// AlbumOutputs is a parsed -albums value
+type AlbumOutputs struct {
+	Link string // albumSymlink, albumHardlink, albumCopy or "" for no album folders
+	M3U  bool
+	JSON bool
+	XMP  bool
+
+	Description bool
+}
+
+// parseAlbumOutputs parses a comma-separated list of album outputs, with at
+// most one link mode
+func parseAlbumOutputs(value string) (AlbumOutputs, error) {
+	var outputs AlbumOutputs
+	for _, mode := range strings.Split(value, ",") {
+		switch mode = strings.TrimSpace(mode); mode {
+		case albumSymlink, albumHardlink, albumCopy:
+			if outputs.Link != "" && outputs.Link != mode {
+				return outputs, fmt.Errorf("album modes %s and %s can't be combined", outputs.Link, mode)
+			}
+			outputs.Link = mode
+		case albumM3U:
+			outputs.M3U = true
+		case albumJSON:
+			outputs.JSON = true
+		case albumXMP:
+			outputs.XMP = true
+		case albumDescription:
+			outputs.Description = true
+		case albumNone, "":
+		default:
+			return outputs, fmt.Errorf("unknown album mode %q, expected %s, %s, %s, %s, %s, %s, %s or %s",
+				mode, albumSymlink, albumHardlink, albumCopy, albumM3U, albumJSON, albumXMP, albumDescription, albumNone)
+		}
+	}
+	return outputs, nil
+}
+
+// albumLinkDescription returns how actions name an album entry of mode
+func albumLinkDescription(mode string) string {
+	switch mode {
+	case albumHardlink:
+		return "hard link"
+	case albumCopy:
+		return "copy"
+	}
+	return "symlink"
+}
+
+// claimAlbumEntryPath returns entryPath, or entryPath with a "(n)" suffix
+// before the extension if another file of the album was given that name or an
+// entry for another file is there already. Different photos of the same name,
+// such as the year copies of album copies Takeout numbered, then each keep
+// their entry. An existing entry for targetPath is reused.
+func claimAlbumEntryPath(entryPath, targetPath string) string {
+	destinationClaims.Lock()
+	defer destinationClaims.Unlock()
+
+	ext := filepath.Ext(entryPath)
+	base := strings.TrimSuffix(entryPath, ext)
+	candidate := entryPath
+	for n := 1; ; n++ {
+		if !destinationClaims.paths[candidate] {
+			if _, err := os.Lstat(candidate); os.IsNotExist(err) || isAlbumEntryFor(candidate, targetPath) {
+				destinationClaims.paths[candidate] = true
+				return candidate
+			}
+		}
+		candidate = fmt.Sprintf("%s(%d)%s", base, n, ext)
+	}
+}
+
+// isAlbumEntryFor reports whether entryPath is a symlink to targetPath or a
+// hard link of it
+func isAlbumEntryFor(entryPath, targetPath string) bool {
+	info, err := os.Lstat(entryPath)
+	if err != nil {
+		return false
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		link, err := os.Readlink(entryPath)
+		if err != nil {
+			return false
+		}
+		if !filepath.IsAbs(link) {
+			link = filepath.Join(filepath.Dir(entryPath), link)
+		}
+		return filepath.Clean(link) == filepath.Clean(targetPath)
+	}
+	target, err := os.Stat(targetPath)
+	return err == nil && os.SameFile(info, target)
+}
+
+// removeAlbumEntryFor removes an existing entry at entryPath if it is one for
+// targetPath, and fails if it belongs to another file
+func removeAlbumEntryFor(entryPath, targetPath string) error {
+	if _, err := os.Lstat(entryPath); err != nil {
+		return nil
+	}
+	if !isAlbumEntryFor(entryPath, targetPath) {
+		return fmt.Errorf("album entry %s already exists for another file", entryPath)
+	}
+	if err := os.Remove(entryPath); err != nil {
+		return fmt.Errorf("failed to remove existing album entry: %v", err)
+	}
+	return nil
+}
+
+// createAlbumEntry puts targetPath into an album folder at entryPath, as a
+// symlink, hard link or copy
+func createAlbumEntry(mode, targetPath, entryPath string) error {
+	if mode == albumSymlink {
+		return createAlbumSymlink(targetPath, entryPath)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(entryPath), 0755); err != nil {
+		return fmt.Errorf("failed to create album directory: %v", err)
+	}
+	if err := removeAlbumEntryFor(entryPath, targetPath); err != nil {
+		return err
+	}
+	if mode == albumHardlink {
+		if err := os.Link(targetPath, entryPath); err != nil {
+			return fmt.Errorf("failed to create hard link: %v", err)
+		}
+		return nil
+	}
+	if err := copyFile(targetPath, entryPath); err != nil {
+		return fmt.Errorf("failed to copy file: %v", err)
+	}
+	return nil
+}
+
+// moveAlbumEntry renames an album entry after its target was renamed to
+// targetPath. Symlinks are recreated, as their relative target changed.
+func moveAlbumEntry(mode, oldPath, newPath, targetPath string) error {
+	if mode != albumSymlink {
+		return os.Rename(oldPath, newPath)
+	}
+	if err := createAlbumSymlink(targetPath, newPath); err != nil {
+		return err
+	}
+	return os.Remove(oldPath)
+}
+
+// copyFile copies src to dst, keeping its modification time
+func copyFile(src, dst string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(dst)
+		} else {
+			os.Chtimes(dst, info.ModTime(), info.ModTime())
+		}
+	}()
+	_, err = io.Copy(out, in)
+	return err
+}
+
+// fileXMPTags returns the XMP tags to write to a moved file in one command:
+// its name before -rename, and the titles and description of its albums
+// selected by -albums
+func fileXMPTags(config *Config, preservedName string, albums []*Album) map[string]string {
+	tags := make(map[string]string)
+	if preservedName != "" {
+		tags[preservedFileNameTag] = preservedName
+	}
+	if config.Albums.Description {
+		for _, album := range albums {
+			if description := album.Description(); description != "" {
+				// Only written to files without a description of their own
+				tags[albumDescriptionTag+"-"] = ""
+				tags[albumDescriptionTag] = description
+				break
+			}
+		}
+	}
+
+	if config.Albums.XMP && len(albums) > 0 {
+		titles := make([]string, len(albums))
+		for i, album := range albums {
+			titles[i] = album.Title
+		}
+		for tag, value := range albumXMPTags(titles) {
+			tags[tag] = value
+		}
+	}
+	return tags
+}
+
+// writeXMPTags writes the tags of fileXMPTags to the moved file at path. They
+// only add to what the file already holds, so formats ExifTool can't write are
+// skipped and a failed write is a warning rather than failing the file.
+func writeXMPTags(backend MetadataBackend, path string, tags map[string]string) []ExifToolWarning {
+	if ext := strings.ToLower(filepath.Ext(path)); exifToolReadOnlyExts[ext] {
+		return []ExifToolWarning{{Level: "Warning", Message: fmt.Sprintf("XMP tags not written, ExifTool can't write %s files", strings.ToUpper(ext[1:]))}}
+	}
+	warnings, err := backend.WriteTags(path, tags)
+	if err != nil {
+		warnings = append(warnings, ExifToolWarning{Level: "Warning", Message: fmt.Sprintf("XMP tags not written: %v", err)})
+	}
+	return warnings
+}
+
+// albumXMPTags returns the tags adding albums to the keywords of a file, as
+// flat keywords and under "Albums" in the hierarchical subject used by
+// Lightroom and digiKam, where "|" separates levels. Each keyword is removed
+// before it is added, ExifTool's -TAG-=X -TAG+=X idiom, so keywords a file
+// already has aren't added again.
+func albumXMPTags(albums []string) map[string]string {
+	hierarchical := make([]string, len(albums))
+	for i, album := range albums {
+		hierarchical[i] = "Albums|" + strings.ReplaceAll(album, "|", "-")
+	}
+	return map[string]string{
+		"XMP-dc:Subject-":             tagList(albums...),
+		"XMP-dc:Subject+":             tagList(albums...),
+		"XMP-lr:HierarchicalSubject-": tagList(hierarchical...),
+		"XMP-lr:HierarchicalSubject+": tagList(hierarchical...),
+	}
+}
+
+// albumMembers returns the moved files of each album folder, relative to the
+// output directory and sorted
+func albumMembers(config *Config, results []Result) map[string][]string {
+	members := make(map[string][]string)
+	for _, result := range results {
+		if !result.Success || len(result.Albums) == 0 || result.DestPath == "" {
+			continue
+		}
+		dest, err := filepath.Rel(config.OutputDir, result.DestPath)
+		if err != nil {
+			continue
+		}
+		for _, album := range result.Albums {
+			folder := "ALBUMS/" + album.Folder
+			members[folder] = append(members[folder], filepath.ToSlash(dest))
+		}
+	}
+	for _, files := range members {
+		sort.Strings(files)
+	}
+	return members
+}
+
+// writeAlbumManifests writes the album-folders.json mapping of titles to
+// folders, album.json and README.md in album folders, and the .m3u playlists
+// and albums.json selected by -albums, once every file is in place
+func writeAlbumManifests(config *Config, results []Result) error {
+	var errs []error
+	if err := writeAlbumFolders(config); err != nil {
+		errs = append(errs, err)
+	}
+	if err := writeAlbumInfo(config, results); err != nil {
+		errs = append(errs, err)
+	}
+	if !config.Albums.M3U && !config.Albums.JSON {
+		return errors.Join(errs...)
+	}
+	members := albumMembers(config, results)
+	if config.DryRun {
+		fmt.Printf("Album manifests: would write %d albums\n", len(members))
+		return errors.Join(errs...)
+	}
+
+	if config.Albums.JSON {
+		path := filepath.Join(config.OutputDir, albumsManifest)
+		data, err := json.MarshalIndent(members, "", "  ")
+		if err == nil {
+			err = os.WriteFile(path, append(data, '\n'), 0644)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to write %s: %v", path, err))
+		}
+	}
+
+	if config.Albums.M3U {
+		for folder, files := range members {
+			path := filepath.Join(config.OutputDir, filepath.FromSlash(folder)) + ".m3u"
+			if err := writeM3U(path, config.OutputDir, files); err != nil {
+				errs = append(errs, fmt.Errorf("failed to write %s: %v", path, err))
+			}
+		}
+	}
+
+	fmt.Printf("Album manifests written: %d albums\n", len(members))
+	return errors.Join(errs...)
+}
+
+// writeAlbumFolders writes album-folders.json, listing the title, folder and
+// Takeout folder of every album, so album folders that had to be renamed or
+// numbered can be traced back to their album
+func writeAlbumFolders(config *Config) error {
+	if config.Move == "" || config.AlbumFolders == nil || len(config.AlbumFolders.albums) == 0 {
+		return nil
+	}
+	if config.Albums.Link == "" && !config.Albums.M3U && !config.Albums.JSON {
+		return nil
+	}
+	if config.DryRun {
+		fmt.Printf("Album folders: would write %d albums to %s\n", len(config.AlbumFolders.albums), albumFoldersFile)
+		return nil
+	}
+
+	type albumFolder struct {
+		Title  string `json:"title"`
+		Folder string `json:"folder"`
+		Source string `json:"source"`
+	}
+	folders := make([]albumFolder, 0, len(config.AlbumFolders.albums))
+	for _, album := range config.AlbumFolders.albums {
+		folders = append(folders, albumFolder{album.Title, "ALBUMS/" + album.Folder, album.Source})
+	}
+	path := filepath.Join(config.OutputDir, albumFoldersFile)
+	data, err := json.MarshalIndent(folders, "", "  ")
+	if err == nil {
+		err = os.WriteFile(path, append(data, '\n'), 0644)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
+
+// writeM3U writes an extended M3U playlist at path listing files, which are
+// relative to outputDir, by their path relative to the playlist
+func writeM3U(path, outputDir string, files []string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	for _, file := range files {
+		rel, err := filepath.Rel(filepath.Dir(path), filepath.Join(outputDir, filepath.FromSlash(file)))
+		if err != nil {
+			return err
+		}
+		b.WriteString(filepath.ToSlash(rel) + "\n")
+	}
+	return os.WriteFile(path, []byte(b.String()), 0644)
+}
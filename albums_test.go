@@ -0,0 +1,360 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAlbumOutputs(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected AlbumOutputs
+		valid    bool
+	}{
+		{"symlink", AlbumOutputs{Link: albumSymlink}, true},
+		{"copy,m3u,json,xmp", AlbumOutputs{Link: albumCopy, M3U: true, JSON: true, XMP: true}, true},
+		{"hardlink, xmp", AlbumOutputs{Link: albumHardlink, XMP: true}, true},
+		{"xmp,description", AlbumOutputs{XMP: true, Description: true}, true},
+		{"description", AlbumOutputs{Description: true}, true},
+		{"m3u", AlbumOutputs{M3U: true}, true},
+		{"none", AlbumOutputs{}, true},
+		{"symlink,copy", AlbumOutputs{}, false},
+		{"shortcut", AlbumOutputs{}, false},
+	}
+	for _, test := range tests {
+		got, err := parseAlbumOutputs(test.value)
+		if (err == nil) != test.valid {
+			t.Errorf("parseAlbumOutputs(%q): unexpected error %v", test.value, err)
+			continue
+		}
+		if test.valid && got != test.expected {
+			t.Errorf("parseAlbumOutputs(%q) = %+v, expected %+v", test.value, got, test.expected)
+		}
+	}
+}
+
+// tripMetadata is the metadata.json of the Trip album, with an enrichment of
+// each kind
+const tripMetadata = `{
+  "title": "Trip",
+  "description": "Two days in *Paris*",
+  "access": "protected",
+  "date": {"timestamp": "1555083012", "formatted": "Apr 12, 2019, 3:30:12 PM UTC"},
+  "location": "Paris",
+  "geoData": {"latitude": 48.8566, "longitude": 2.3522, "altitude": 0.0},
+  "enrichments": [
+    {"narrativeEnrichment": {"text": "Arrived by train"}},
+    {"locationEnrichment": {"location": [{"name": "Louvre", "latitudeE7": 488606111, "longitudeE7": 23376225}]}},
+    {"mapEnrichment": {"origin": {"name": "Paris"}, "destination": {"name": "Versailles"}}}
+  ],
+  "sharedAlbumComments": [{"text": "Nice!"}]
+}`
+
+// processAlbum moves a Trip album with two photos using the given -albums value
+func processAlbum(t *testing.T, albums string, backend MetadataBackend) (*Config, []Result) {
+	t.Helper()
+	saveSupportedExtensions(t)
+	setSupportedExtensions([]string{".jpg"}, extSourceFallback)
+
+	sourceDir := t.TempDir()
+	album := filepath.Join(sourceDir, "Trip")
+	for _, name := range []string{"IMG_1.jpg", "IMG_2.jpg"} {
+		writeMedia(t, filepath.Join(album, name))
+		writeSidecar(t, filepath.Join(album, name+".json"), name, 1555083012)
+	}
+	if err := os.WriteFile(filepath.Join(album, "metadata.json"), []byte(tripMetadata), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &Config{SourceDirs: []string{sourceDir}, Move: t.TempDir(), Workers: 1, BatchSize: 1, AlbumModes: albums}
+	if err := validateConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	mediaFiles, idx, err := scanTakeout(sourceDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Sidecars = idx
+
+	results := processFiles(config, []MetadataBackend{backend}, mediaFiles)
+	for _, result := range results {
+		if !result.Success {
+			t.Fatalf("%s: %v", result.File.Path, result.Error)
+		}
+	}
+	if err := writeAlbumManifests(config, results); err != nil {
+		t.Fatal(err)
+	}
+	return config, results
+}
+
+func TestAlbumLinkModes(t *testing.T) {
+	for _, mode := range []string{albumSymlink, albumHardlink, albumCopy} {
+		t.Run(mode, func(t *testing.T) {
+			config, _ := processAlbum(t, mode, NewMemoryBackend())
+			destPath := generateDestinationPath(config.OutputDir, "IMG_1.jpg", time.Unix(1555083012, 0))
+			entryPath := generateAlbumSymlinkPath(config.OutputDir, "Trip", "IMG_1.jpg")
+
+			entry, err := os.Lstat(entryPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dest, err := os.Stat(destPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			isSymlink := entry.Mode()&os.ModeSymlink != 0
+			switch mode {
+			case albumSymlink:
+				if !isSymlink {
+					t.Error("Expected a symlink")
+				}
+			case albumHardlink:
+				if isSymlink || !os.SameFile(entry, dest) {
+					t.Error("Expected a hard link to the moved file")
+				}
+			case albumCopy:
+				if isSymlink || os.SameFile(entry, dest) {
+					t.Error("Expected a separate copy")
+				}
+				if data, err := os.ReadFile(entryPath); err != nil || string(data) != "media" {
+					t.Errorf("Expected the copy to have the content, got %q, %v", data, err)
+				}
+			}
+		})
+	}
+
+	t.Run("none", func(t *testing.T) {
+		config, _ := processAlbum(t, albumNone, NewMemoryBackend())
+		if _, err := os.Stat(filepath.Join(config.OutputDir, "ALBUMS")); !os.IsNotExist(err) {
+			t.Errorf("Expected no ALBUMS folder, got %v", err)
+		}
+	})
+}
+
+func TestAlbumManifests(t *testing.T) {
+	backend := NewMemoryBackend()
+	config, _ := processAlbum(t, "none,m3u,json,xmp", backend)
+	day := filepath.ToSlash(filepath.Dir(generateDestinationPath("", "x", time.Unix(1555083012, 0))))
+
+	var albums map[string][]string
+	readJSONReport(t, filepath.Join(config.OutputDir, albumsManifest), &albums)
+	expected := map[string][]string{"ALBUMS/Trip": {day + "/IMG_1.jpg", day + "/IMG_2.jpg"}}
+	if !reflect.DeepEqual(albums, expected) {
+		t.Errorf("Expected %v in %s, got %v", expected, albumsManifest, albums)
+	}
+
+	playlist, err := os.ReadFile(filepath.Join(config.OutputDir, "ALBUMS", "Trip.m3u"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(playlist)), "\n")
+	if len(lines) != 3 || lines[0] != "#EXTM3U" || lines[1] != "../"+day+"/IMG_1.jpg" {
+		t.Errorf("Unexpected playlist:\n%s", playlist)
+	}
+
+	tags := backend.Tags(filepath.Join(config.OutputDir, filepath.FromSlash(day), "IMG_1.jpg"))
+	if tags["XMP-dc:Subject"] != "Trip" || tags["XMP-lr:HierarchicalSubject"] != "Albums|Trip" {
+		t.Errorf("Expected the album in the XMP keywords, got %v", tags)
+	}
+}
+
+// keywordFailingBackend fails every write of album keywords
+type keywordFailingBackend struct {
+	*MemoryBackend
+}
+
+func (b keywordFailingBackend) WriteTags(path string, tags map[string]string) ([]ExifToolWarning, error) {
+	if _, ok := tags["XMP-dc:Subject+"]; ok {
+		return nil, errors.New("file is read-only")
+	}
+	return b.MemoryBackend.WriteTags(path, tags)
+}
+
+func TestAlbumKeywordsNotWritten(t *testing.T) {
+	// processAlbum fails the test if a file isn't moved
+	config, results := processAlbum(t, "symlink,xmp", keywordFailingBackend{NewMemoryBackend()})
+	for _, result := range results {
+		if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0].Message, "XMP tags not written") {
+			t.Errorf("Expected a warning that %s has no album keywords, got %v", result.File.BaseName, result.Warnings)
+		}
+		if len(result.AlbumLinks) != 1 {
+			t.Errorf("Expected an album entry for %s, got %v", result.File.BaseName, result.AlbumLinks)
+		}
+	}
+	if _, err := os.Lstat(generateAlbumSymlinkPath(config.OutputDir, "Trip", "IMG_1.jpg")); err != nil {
+		t.Errorf("Expected the album symlink to be created: %v", err)
+	}
+}
+
+func TestAlbumFolderName(t *testing.T) {
+	tests := []struct {
+		title    string
+		expected string
+	}{
+		{"Trip", "Trip"},
+		{"Paris/Rome: 2019?", "Paris_Rome_ 2019_"},
+		{"..", untitledAlbum},
+		{"So long...", "So long"},
+		{"AUX", "_AUX"},
+		{"  ", untitledAlbum},
+		{strings.Repeat("é", 150), strings.Repeat("é", maxAlbumFolderRunes)},
+		{strings.Repeat("a", 99) + " .b", strings.Repeat("a", 99)},
+	}
+	for _, test := range tests {
+		if got := albumFolderName(test.title); got != test.expected {
+			t.Errorf("albumFolderName(%q) = %q, expected %q", test.title, got, test.expected)
+		}
+	}
+}
+
+func TestSameNamedAlbums(t *testing.T) {
+	saveSupportedExtensions(t)
+	setSupportedExtensions([]string{".jpg"}, extSourceFallback)
+
+	sourceDir := t.TempDir()
+	titles := map[string]string{"Trip A": "Trip", "Trip B": "trip", "Trip C": "Trip?", "Party": "Party"}
+	for dir, title := range titles {
+		writeMedia(t, filepath.Join(sourceDir, dir, "IMG_1.jpg"))
+		writeSidecar(t, filepath.Join(sourceDir, dir, "IMG_1.jpg.json"), "IMG_1.jpg", 1555083012)
+		if err := os.WriteFile(filepath.Join(sourceDir, dir, "metadata.json"), []byte(`{"title": "`+title+`"}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := &Config{SourceDirs: []string{sourceDir}, Move: t.TempDir(), Workers: 1, BatchSize: 1, AlbumModes: albumSymlink}
+	if err := validateConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	mediaFiles, idx, err := scanTakeout(sourceDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Sidecars = idx
+	config.AlbumFolders = newAlbumFolders(idx)
+
+	results := processFiles(config, []MetadataBackend{NewMemoryBackend()}, mediaFiles)
+	for _, result := range results {
+		if !result.Success {
+			t.Fatalf("%s: %v", result.File.Path, result.Error)
+		}
+	}
+	if err := writeAlbumManifests(config, results); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every album keeps its own folder, numbered in the order of the Takeout
+	for _, folder := range []string{"Party", "Trip", "trip (2)", "Trip_"} {
+		if _, err := os.Lstat(generateAlbumSymlinkPath(config.OutputDir, folder, "IMG_1.jpg")); err != nil {
+			t.Errorf("Expected album folder %s: %v", folder, err)
+		}
+	}
+
+	var mapping []struct{ Title, Folder, Source string }
+	readJSONReport(t, filepath.Join(config.OutputDir, albumFoldersFile), &mapping)
+	if len(mapping) != len(titles) {
+		t.Fatalf("Expected %d albums in %s, got %v", len(titles), albumFoldersFile, mapping)
+	}
+	if got := mapping[2]; got.Title != "trip" || got.Folder != "ALBUMS/trip (2)" || got.Source != filepath.Join(sourceDir, "Trip B") {
+		t.Errorf("Unexpected mapping of the second Trip album: %+v", got)
+	}
+}
+
+func TestAlbumInfo(t *testing.T) {
+	backend := NewMemoryBackend()
+	config, _ := processAlbum(t, "symlink,description", backend)
+	folder := filepath.Join(config.OutputDir, "ALBUMS", "Trip")
+
+	// album.json keeps every field of metadata.json, parsed or not
+	var info map[string]any
+	readJSONReport(t, filepath.Join(folder, albumInfoFile), &info)
+	if info["description"] != "Two days in *Paris*" || info["sharedAlbumComments"] == nil {
+		t.Errorf("Expected the whole metadata in %s, got %v", albumInfoFile, info)
+	}
+
+	readme, err := os.ReadFile(filepath.Join(folder, albumReadmeFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"# Trip\n\nTwo days in \\*Paris\\*\n",
+		"- **Date:** April 12, 2019\n",
+		"- **Location:** Paris (48.85660, 2.35220)\n",
+		"- **Access:** protected\n",
+		"## Notes\n\nArrived by train\n\n**Location:** Louvre (48.86061, 2.33762)\n\n**Map:** Paris → Versailles\n",
+		"## Photos\n\n- [IMG\\_1.jpg](<IMG_1.jpg>)\n- [IMG\\_2.jpg](<IMG_2.jpg>)\n",
+	} {
+		if !strings.Contains(string(readme), expected) {
+			t.Errorf("Expected %q in %s:\n%s", expected, albumReadmeFile, readme)
+		}
+	}
+
+	destPath := generateDestinationPath(config.OutputDir, "IMG_1.jpg", time.Unix(1555083012, 0))
+	if got := backend.Tags(destPath)[albumDescriptionTag]; got != "Two days in *Paris*" {
+		t.Errorf("Expected the album description in %s, got %q", albumDescriptionTag, got)
+	}
+}
+
+func TestAlbumDescriptionKeepsOwn(t *testing.T) {
+	config := &Config{Albums: AlbumOutputs{XMP: true, Description: true}}
+	trip := &Album{Title: "Trip", Folder: "Trip", Metadata: &AlbumMetadata{Description: "Summer"}}
+
+	backend := NewMemoryBackend()
+	backend.SetTags("own.jpg", map[string]string{albumDescriptionTag: "Sunset from the bridge", "XMP-dc:Subject": tagList("Trip", "Sea")})
+	for _, path := range []string{"own.jpg", "none.jpg"} {
+		if _, err := backend.WriteTags(path, fileXMPTags(config, "", []*Album{trip})); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := backend.Tags("own.jpg"); got[albumDescriptionTag] != "Sunset from the bridge" || got["XMP-dc:Subject"] != tagList("Sea", "Trip") {
+		t.Errorf("Expected the file's own description and keywords kept, got %v", got)
+	}
+	if got := backend.Tags("none.jpg"); got[albumDescriptionTag] != "Summer" {
+		t.Errorf("Expected the album description for a file without one, got %v", got)
+	}
+}
+
+func TestParseAlbumMetadata(t *testing.T) {
+	metadata := parseAlbumMetadata([]byte(`{"title": "Trip", "enrichments": [{"mapEnrichment": {"origin": [{"name": "A"}, {"name": "B"}], "destination": {"name": "C"}}}]}`))
+	if metadata == nil || len(metadata.Enrichments) != 1 || len(metadata.Enrichments[0].Map.Origin) != 2 || metadata.Enrichments[0].Map.Destination[0].Name != "C" {
+		t.Errorf("Expected places as a list or a single object, got %+v", metadata)
+	}
+
+	// Unexpected fields don't lose the title
+	if metadata := parseAlbumMetadata([]byte(`{"title": "Trip", "date": "yesterday"}`)); metadata == nil || metadata.Title != "Trip" {
+		t.Errorf("Expected the title of unexpected metadata, got %+v", metadata)
+	}
+	if metadata := parseAlbumMetadata([]byte(`[]`)); metadata != nil {
+		t.Errorf("Expected nil for a JSON array, got %+v", metadata)
+	}
+}
+
+func TestFileXMPTags(t *testing.T) {
+	config := &Config{Albums: AlbumOutputs{XMP: true, Description: true}}
+	trip := &Album{Title: "Trip", Folder: "Trip", Metadata: &AlbumMetadata{Description: "Summer"}}
+	party := &Album{Title: "Party", Folder: "Party"}
+
+	// All albums go in one write, each keyword removed before it is added
+	tags := fileXMPTags(config, "IMG_1.jpg", []*Album{party, trip})
+	expected := map[string]string{
+		preservedFileNameTag:          "IMG_1.jpg",
+		albumDescriptionTag + "-":     "",
+		albumDescriptionTag:           "Summer",
+		"XMP-dc:Subject-":             tagList("Party", "Trip"),
+		"XMP-dc:Subject+":             tagList("Party", "Trip"),
+		"XMP-lr:HierarchicalSubject-": tagList("Albums|Party", "Albums|Trip"),
+		"XMP-lr:HierarchicalSubject+": tagList("Albums|Party", "Albums|Trip"),
+	}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Errorf("Expected %v, got %v", expected, tags)
+	}
+	if tags := fileXMPTags(&Config{}, "", []*Album{trip}); len(tags) != 0 {
+		t.Errorf("Expected no XMP tags without -rename or album tags, got %v", tags)
+	}
+}
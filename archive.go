@@ -0,0 +1,430 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// stagingDirName is the folder of the output directory that media files are
+// extracted to from archives. Being on the same file system as ALL_PHOTOS,
+// moving them from there to their final place is a rename, not a copy.
+const stagingDirName = ".takeaway-extract"
+
+// maxArchiveJSONSize bounds the size of a JSON file read from an archive
+const maxArchiveJSONSize = 16 << 20
+
+// ArchiveEntry is a media file inside a Takeout .zip or .tgz archive
+type ArchiveEntry struct {
+	Archive string // Path of the archive
+	Name    string // Slash-separated name inside the archive
+	ModTime time.Time
+
+	index   int       // Position among the entries of a .tgz, counting from 1
+	zipFile *zip.File // Entry of a .zip
+}
+
+// ArchiveSet holds the Takeout archives read directly. Archives are listed
+// while scanning; media files are extracted one batch at a time as jobs are
+// sent to the workers, so it is only used by one goroutine at a time.
+type ArchiveSet struct {
+	stagingDir string
+	temporary  bool // The staging directory is removed on Close (dry runs)
+	zips       []*zip.ReadCloser
+	tars       map[string]*tarCursor
+	roots      map[string]bool
+}
+
+// tarCursor is an open .tgz positioned after its index-th entry. Entries can
+// only be read in order, so going back means reopening the archive.
+type tarCursor struct {
+	file   *os.File
+	reader *tar.Reader
+	index  int
+}
+
+// isArchive reports whether path names a Takeout archive that can be read directly
+func isArchive(path string) bool {
+	name := strings.ToLower(path)
+	return strings.HasSuffix(name, ".zip") || strings.HasSuffix(name, ".tgz") || strings.HasSuffix(name, ".tar.gz")
+}
+
+// archiveSources returns the archives a -source names: the source itself if it
+// is an archive, or the archives directly inside it if it is a folder of them.
+// A folder without archives returns none and is walked as extracted files.
+func archiveSources(source string) ([]string, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		if isArchive(source) {
+			return []string{source}, nil
+		}
+		return nil, fmt.Errorf("%s is neither a directory nor a .zip or .tgz archive", source)
+	}
+
+	entries, err := os.ReadDir(source)
+	if err != nil {
+		return nil, err
+	}
+	var archives []string
+	for _, entry := range entries {
+		if !entry.IsDir() && isArchive(entry.Name()) {
+			archives = append(archives, filepath.Join(source, entry.Name()))
+		}
+	}
+	return archives, nil
+}
+
+// scanSources scans every -source, listing archives and walking directories,
+// and indexes the sidecars of all of them together. The returned ArchiveSet is
+// nil when no archive was found.
+func scanSources(config *Config) ([]MediaFile, *SidecarIndex, *ArchiveSet, error) {
+	scan := &takeoutScan{}
+	var archives *ArchiveSet
+
+	for _, source := range config.SourceDirs {
+		paths, err := archiveSources(source)
+		if err != nil {
+			return nil, nil, archives, err
+		}
+		if len(paths) == 0 {
+			if err := scan.walkDir(source); err != nil {
+				return nil, nil, archives, err
+			}
+			continue
+		}
+
+		if archives == nil {
+			if archives, err = newArchiveSet(config); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+		for _, archive := range paths {
+			if err := archives.list(scan, archive); err != nil {
+				return nil, nil, archives, fmt.Errorf("failed to read %s: %v", archive, err)
+			}
+		}
+	}
+
+	return scan.mediaFiles, scan.index(), archives, nil
+}
+
+// newArchiveSet prepares extraction into the output directory, or into a
+// temporary directory for dry runs
+func newArchiveSet(config *Config) (*ArchiveSet, error) {
+	archives := &ArchiveSet{
+		stagingDir: filepath.Join(config.OutputDir, stagingDirName),
+		tars:       make(map[string]*tarCursor),
+		roots:      make(map[string]bool),
+	}
+	if config.DryRun {
+		dir, err := os.MkdirTemp("", "takeaway-extract-")
+		if err != nil {
+			return nil, err
+		}
+		archives.stagingDir, archives.temporary = dir, true
+	}
+	return archives, nil
+}
+
+// list adds the media files and JSON files of an archive to the scan. JSON
+// files are parsed from memory; media files get the path they will be
+// extracted to, under a staging root mirroring the archive's layout.
+func (a *ArchiveSet) list(scan *takeoutScan, archive string) error {
+	base := filepath.Base(archive)
+	for _, ext := range []string{".tar.gz", ".tgz", ".zip"} {
+		if strings.HasSuffix(strings.ToLower(base), ext) {
+			base = base[:len(base)-len(ext)]
+			break
+		}
+	}
+	stagingRoot := filepath.Join(a.stagingDir, base)
+	for n := 1; a.roots[stagingRoot]; n++ {
+		stagingRoot = filepath.Join(a.stagingDir, fmt.Sprintf("%s(%d)", base, n))
+	}
+	a.roots[stagingRoot] = true
+
+	// Media files are keyed under the staging root and sidecars under the
+	// archive path; both give the same directory relative to their root
+	scan.roots = append(scan.roots, stagingRoot, archive)
+
+	add := func(entry *ArchiveEntry, read func() ([]byte, error)) error {
+		name, ok := safeArchiveName(entry.Name)
+		if !ok {
+			fmt.Printf("Warning: Skipping %s in %s, its path leaves the archive\n", entry.Name, archive)
+			return nil
+		}
+		entry.Name = name
+		baseName := path.Base(name)
+
+		if strings.HasSuffix(baseName, ".json") {
+			data, err := read()
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %v", name, err)
+			}
+			scan.sidecars = append(scan.sidecars, parseSidecar(entry.SourcePath(), data))
+			return nil
+		}
+
+		if supportedExts[strings.ToLower(path.Ext(baseName))] {
+			stagedPath := filepath.Join(stagingRoot, filepath.FromSlash(name))
+			scan.mediaFiles = append(scan.mediaFiles, MediaFile{
+				Path:     stagedPath,
+				BaseName: baseName,
+				Dir:      filepath.Dir(stagedPath),
+				Archive:  entry,
+			})
+		}
+		return nil
+	}
+
+	if strings.HasSuffix(strings.ToLower(archive), ".zip") {
+		return a.listZip(archive, add)
+	}
+	return listTar(archive, add)
+}
+
+// listZip lists a .zip, which stays open for extraction
+func (a *ArchiveSet) listZip(archive string, add func(*ArchiveEntry, func() ([]byte, error)) error) error {
+	reader, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	a.zips = append(a.zips, reader)
+
+	for _, file := range reader.File {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		entry := &ArchiveEntry{Archive: archive, Name: file.Name, ModTime: file.Modified, zipFile: file}
+		err := add(entry, func() ([]byte, error) {
+			rc, err := file.Open()
+			if err != nil {
+				return nil, err
+			}
+			defer rc.Close()
+			return readLimited(rc)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listTar lists a .tgz by reading it through once
+func listTar(archive string, add func(*ArchiveEntry, func() ([]byte, error)) error) error {
+	cursor, err := openTarCursor(archive)
+	if err != nil {
+		return err
+	}
+	defer cursor.file.Close()
+
+	for {
+		header, err := cursor.reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		cursor.index++
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		entry := &ArchiveEntry{Archive: archive, Name: header.Name, ModTime: header.ModTime, index: cursor.index}
+		if err := add(entry, func() ([]byte, error) { return readLimited(cursor.reader) }); err != nil {
+			return err
+		}
+	}
+}
+
+func openTarCursor(archive string) (*tarCursor, error) {
+	file, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &tarCursor{file: file, reader: tar.NewReader(gz)}, nil
+}
+
+// readLimited reads a JSON file from an archive, refusing oversized ones
+func readLimited(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxArchiveJSONSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxArchiveJSONSize {
+		return nil, fmt.Errorf("larger than %d bytes", maxArchiveJSONSize)
+	}
+	return data, nil
+}
+
+// safeArchiveName cleans an entry name, rejecting names that would be
+// extracted outside the staging root
+func safeArchiveName(name string) (string, bool) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if path.IsAbs(name) {
+		return "", false
+	}
+	clean := path.Clean(name)
+	if clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", false
+	}
+	return clean, true
+}
+
+// SourcePath returns where the entry is found, as the archive path followed
+// by the entry name
+func (e *ArchiveEntry) SourcePath() string {
+	return filepath.Join(e.Archive, filepath.FromSlash(e.Name))
+}
+
+// extract writes the archive member of file to file.Path
+func (a *ArchiveSet) extract(file MediaFile) (err error) {
+	entry := file.Archive
+	var reader io.Reader
+	if entry.zipFile != nil {
+		rc, err := entry.zipFile.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		reader = rc
+	} else if reader, err = a.tarReader(entry); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file.Path), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(file.Path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(file.Path)
+		} else if !entry.ModTime.IsZero() {
+			os.Chtimes(file.Path, entry.ModTime, entry.ModTime)
+		}
+	}()
+	_, err = io.Copy(out, reader)
+	return err
+}
+
+// tarReader returns a reader for the content of a .tgz entry
+func (a *ArchiveSet) tarReader(entry *ArchiveEntry) (io.Reader, error) {
+	cursor := a.tars[entry.Archive]
+	if cursor == nil || cursor.index >= entry.index {
+		if cursor != nil {
+			cursor.file.Close()
+		}
+		var err error
+		if cursor, err = openTarCursor(entry.Archive); err != nil {
+			delete(a.tars, entry.Archive)
+			return nil, err
+		}
+		a.tars[entry.Archive] = cursor
+	}
+
+	for cursor.index < entry.index {
+		if _, err := cursor.reader.Next(); err != nil {
+			if err == io.EOF {
+				err = errors.New("entry not found, the archive changed since it was listed")
+			}
+			return nil, err
+		}
+		cursor.index++
+	}
+	return cursor.reader, nil
+}
+
+// extractArchiveFiles extracts the archive members among files. Files that
+// can't be extracted get a failed result and are left out of the job.
+func extractArchiveFiles(config *Config, files []MediaFile, results chan<- Result) []MediaFile {
+	if config.Archives == nil {
+		return files
+	}
+
+	var extracted []MediaFile
+	for _, file := range files {
+		if file.Archive != nil {
+			if err := config.Archives.extract(file); err != nil {
+				results <- Result{File: file, Error: fmt.Errorf("failed to extract %s: %v", file.Archive.SourcePath(), err)}
+				continue
+			}
+		}
+		extracted = append(extracted, file)
+	}
+	return extracted
+}
+
+// Close closes the archives and removes the empty folders of the staging
+// directory. It returns the staging directory if files were left in it,
+// which happens for files that couldn't be processed.
+func (a *ArchiveSet) Close() string {
+	for _, reader := range a.zips {
+		reader.Close()
+	}
+	for _, cursor := range a.tars {
+		cursor.file.Close()
+	}
+
+	if a.temporary {
+		os.RemoveAll(a.stagingDir)
+		return ""
+	}
+
+	if removeEmptyDirs(a.stagingDir) {
+		return ""
+	}
+	return a.stagingDir
+}
+
+// removeEmptyDirs removes the empty folders under root, and root itself if it
+// ends up empty. It reports whether root is gone.
+func removeEmptyDirs(root string) bool {
+	var dirs []string
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	// Deepest first, so parents are empty by the time they are reached
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, dir := range dirs {
+		os.Remove(dir)
+	}
+
+	_, err := os.Lstat(root)
+	return os.IsNotExist(err)
+}
+
+// closeArchives closes the archives and points at files left in staging
+func closeArchives(archives *ArchiveSet) {
+	if leftover := archives.Close(); leftover != "" {
+		fmt.Printf("Files that could not be processed were left extracted in %s\n", leftover)
+	}
+}
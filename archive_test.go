@@ -0,0 +1,243 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// archiveFile is a file written into a test archive
+type archiveFile struct {
+	name    string
+	content string
+}
+
+// sidecarContent returns the JSON of a sidecar for title taken at timestamp
+func sidecarContent(title string, timestamp int64) string {
+	return `{"title": "` + title + `", "photoTakenTime": {"timestamp": "` + strconv.FormatInt(timestamp, 10) + `"}}`
+}
+
+// writeZip writes files into a new .zip at path
+func writeZip(t *testing.T, path string, files []archiveFile) {
+	t.Helper()
+	out, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	w := zip.NewWriter(out)
+	for _, file := range files {
+		f, err := w.Create(file.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(file.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeTgz writes files into a new .tgz at path
+func writeTgz(t *testing.T, path string, files []archiveFile) {
+	t.Helper()
+	out, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	gz := gzip.NewWriter(out)
+	w := tar.NewWriter(gz)
+	for _, file := range files {
+		header := &tar.Header{Name: file.name, Mode: 0644, Size: int64(len(file.content)), ModTime: time.Unix(1555083012, 0)}
+		if err := w.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(file.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadArchives(t *testing.T) {
+	saveSupportedExtensions(t)
+	setSupportedExtensions([]string{".jpg"}, extSourceFallback)
+
+	sourceDir := t.TempDir()
+	outputDir := t.TempDir()
+	album := "Takeout/Google Photos/Trip/"
+
+	// The first media file's sidecar and the album metadata ended up in the other part
+	writeZip(t, filepath.Join(sourceDir, "takeout-001.zip"), []archiveFile{
+		{album + "IMG_0001.jpg", "first"},
+		{album + "IMG_0002.jpg.supplemental-metadata.json", sidecarContent("IMG_0002.jpg", 1618243200)},
+		{"../escape.jpg", "outside"},
+	})
+	writeTgz(t, filepath.Join(sourceDir, "takeout-002.tgz"), []archiveFile{
+		{album + "metadata.json", `{"title": "Trip"}`},
+		{album + "IMG_0001.jpg.supplemental-metadata.json", sidecarContent("IMG_0001.jpg", 1555083012)},
+		{album + "IMG_0002.jpg", "second"},
+		{album + "IMG_0003.jpg", "third"},
+	})
+
+	config := &Config{SourceDirs: []string{sourceDir}, Move: outputDir, Workers: 1, BatchSize: 2}
+	if err := validateConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	mediaFiles, idx, archives, err := scanSources(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mediaFiles) != 3 || idx.Len() != 3 {
+		t.Fatalf("Expected 3 media files and 3 JSON files, got %d and %d", len(mediaFiles), idx.Len())
+	}
+	config.Sidecars, config.Archives = idx, archives
+
+	// Nothing is extracted while scanning
+	if _, err := os.Stat(filepath.Join(outputDir, stagingDirName)); !os.IsNotExist(err) {
+		t.Errorf("Expected no staging directory before processing, got %v", err)
+	}
+
+	// The third file is processed before the second, so the .tgz is reopened
+	mediaFiles[1], mediaFiles[2] = mediaFiles[2], mediaFiles[1]
+	backend := NewMemoryBackend()
+	backend.SetTags(mediaFiles[1].Path, map[string]string{"DateTimeOriginal": "2020:01:02 03:04:05"})
+	results := processFiles(config, []MetadataBackend{backend}, mediaFiles)
+	if leftover := archives.Close(); leftover != "" {
+		t.Errorf("Expected the staging directory to be removed, got %s", leftover)
+	}
+
+	for _, result := range results {
+		if !result.Success {
+			t.Errorf("%s: %v", result.File.SourcePath(), result.Error)
+		}
+	}
+	expected := map[string]string{
+		"IMG_0001.jpg": "first",
+		"IMG_0002.jpg": "second",
+		"IMG_0003.jpg": "third",
+	}
+	dates := map[string]time.Time{
+		"IMG_0001.jpg": time.Unix(1555083012, 0),
+		"IMG_0002.jpg": time.Unix(1618243200, 0),
+		"IMG_0003.jpg": time.Date(2020, 1, 2, 3, 4, 5, 0, time.Local),
+	}
+	for name, content := range expected {
+		destPath := generateDestinationPath(outputDir, name, dates[name])
+		if data, err := os.ReadFile(destPath); err != nil || string(data) != content {
+			t.Errorf("Expected %s extracted to %s, got %q, %v", name, destPath, data, err)
+		}
+		if _, err := os.Lstat(generateAlbumSymlinkPath(outputDir, "Trip", name)); err != nil {
+			t.Errorf("Expected an album symlink for %s: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "escape.jpg")); !os.IsNotExist(err) {
+		t.Errorf("Expected the entry leaving the archive to be skipped, got %v", err)
+	}
+}
+
+// stagingBackend records the most files staged at once when reading tags
+type stagingBackend struct {
+	*MemoryBackend
+	stagingDir string
+	peak       int
+}
+
+func (b *stagingBackend) ReadTags(paths []string) (map[string]*FileMetadata, error) {
+	staged := 0
+	filepath.WalkDir(b.stagingDir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			staged++
+		}
+		return nil
+	})
+	b.peak = max(b.peak, staged)
+	time.Sleep(time.Millisecond)
+	return b.MemoryBackend.ReadTags(paths)
+}
+
+func TestArchiveExtractedAsProcessed(t *testing.T) {
+	saveSupportedExtensions(t)
+	setSupportedExtensions([]string{".jpg"}, extSourceFallback)
+
+	sourceDir := t.TempDir()
+	outputDir := t.TempDir()
+	var files []archiveFile
+	for i := 0; i < 60; i++ {
+		files = append(files, archiveFile{fmt.Sprintf("Takeout/Google Photos/Photos from 2020/IMG_%04d.jpg", i), "photo"})
+	}
+	writeZip(t, filepath.Join(sourceDir, "takeout-001.zip"), files)
+
+	config := &Config{SourceDirs: []string{sourceDir}, Move: outputDir, Workers: 1, BatchSize: 1}
+	if err := validateConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	mediaFiles, idx, archives, err := scanSources(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Sidecars, config.Archives = idx, archives
+	defer archives.Close()
+
+	backend := &stagingBackend{MemoryBackend: NewMemoryBackend(), stagingDir: archives.stagingDir}
+	for _, file := range mediaFiles {
+		backend.SetTags(file.Path, map[string]string{"DateTimeOriginal": "2020:01:02 03:04:05"})
+	}
+	results := processFiles(config, []MetadataBackend{backend}, mediaFiles)
+
+	for _, result := range results {
+		if !result.Success {
+			t.Errorf("%s: %v", result.File.SourcePath(), result.Error)
+		}
+	}
+	// The batch being read, the queued one and the one waiting to be queued
+	if limit := (2*config.Workers + 1) * config.BatchSize; backend.peak > limit {
+		t.Errorf("Expected at most %d files staged at once, got %d of %d", limit, backend.peak, len(mediaFiles))
+	}
+}
+
+func TestArchivesRequireMove(t *testing.T) {
+	sourceDir := t.TempDir()
+	writeZip(t, filepath.Join(sourceDir, "takeout-001.zip"), nil)
+
+	config := &Config{SourceDirs: []string{filepath.Join(sourceDir, "takeout-001.zip")}}
+	if err := validateConfig(config); err == nil {
+		t.Error("Expected reading an archive in place to be refused")
+	}
+}
+
+func TestSafeArchiveName(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+		ok       bool
+	}{
+		{"Takeout/Google Photos/IMG_1.jpg", "Takeout/Google Photos/IMG_1.jpg", true},
+		{"Takeout/./Google Photos//IMG_1.jpg", "Takeout/Google Photos/IMG_1.jpg", true},
+		{"Takeout\\Google Photos\\IMG_1.jpg", "Takeout/Google Photos/IMG_1.jpg", true},
+		{"Takeout/../../IMG_1.jpg", "", false},
+		{"/etc/passwd", "", false},
+		{"..", "", false},
+	}
+	for _, test := range tests {
+		got, ok := safeArchiveName(test.name)
+		if got != test.expected || ok != test.ok {
+			t.Errorf("safeArchiveName(%q) = %q, %t, expected %q, %t", test.name, got, ok, test.expected, test.ok)
+		}
+	}
+}
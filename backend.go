@@ -0,0 +1,282 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MetadataBackend reads and writes media metadata. ExifTool, the built-in
+// reader and in-memory fakes all sit behind this interface so the processing
+// pipeline doesn't depend on a particular implementation.
+type MetadataBackend interface {
+	// ReadTags reads metadata from several files. Results are keyed by the
+	// paths passed in; a file that couldn't be read has its Err set. An error
+	// is only returned when the whole read failed.
+	ReadTags(paths []string) (map[string]*FileMetadata, error)
+
+	// WriteTags writes tags to a file, using ExifTool tag names and date
+	// format. A value may hold several items of a list tag, joined by
+	// tagList. Names ending in - or + remove and add items as ExifTool's
+	// -TAG-=X and -TAG+=X; an empty TAG- keeps a tag that already has a value,
+	// so TAG- and TAG together only write a missing tag. Warnings are returned
+	// even when the write succeeded.
+	WriteTags(path string, tags map[string]string) ([]ExifToolWarning, error)
+
+	// Close releases any resources held by the backend
+	Close() error
+}
+
+// tagListSeparator separates the items of a list tag value given to WriteTags
+const tagListSeparator = "\x1f"
+
+// tagList joins items into one value for a list tag such as XMP-dc:Subject+
+func tagList(items ...string) string {
+	return strings.Join(items, tagListSeparator)
+}
+
+// sortTagNames orders tag names to write by tag, the removals of a tag
+// before its other writes, as in -TAG-=X -TAG+=X
+func sortTagNames(names []string) {
+	sort.Slice(names, func(i, j int) bool {
+		tagI, tagJ := strings.TrimRight(names[i], "+-"), strings.TrimRight(names[j], "+-")
+		if tagI != tagJ {
+			return tagI < tagJ
+		}
+		return strings.HasSuffix(names[i], "-") && !strings.HasSuffix(names[j], "-")
+	})
+}
+
+// errReadOnlyBackend is returned by backends that can't write metadata
+var errReadOnlyBackend = errors.New("metadata backend is read-only")
+
+// ReadTags implements MetadataBackend. A single file is read with all tags;
+// several files are read in one batch restricted to the tags used for processing.
+func (etp *ExifToolProcess) ReadTags(paths []string) (map[string]*FileMetadata, error) {
+	if len(paths) != 1 {
+		return etp.ReadMetadataBatch(paths)
+	}
+
+	tags, warnings, err := etp.ReadMetadata(paths[0])
+	if isExifToolFailure(err) {
+		return nil, err
+	}
+	return map[string]*FileMetadata{paths[0]: {Tags: tags, Warnings: warnings, Err: err}}, nil
+}
+
+// NativeBackend is a read-only MetadataBackend using the built-in pure-Go
+// reader. Files in formats it can't parse are returned with errNativeUnsupported.
+type NativeBackend struct{}
+
+// ReadTags implements MetadataBackend
+func (NativeBackend) ReadTags(paths []string) (map[string]*FileMetadata, error) {
+	results := make(map[string]*FileMetadata, len(paths))
+	for _, path := range paths {
+		tags, err := readNativeMetadata(path)
+		results[path] = &FileMetadata{Tags: tags, Err: err}
+	}
+	return results, nil
+}
+
+// WriteTags implements MetadataBackend
+func (NativeBackend) WriteTags(path string, tags map[string]string) ([]ExifToolWarning, error) {
+	return nil, errReadOnlyBackend
+}
+
+// Close implements MetadataBackend
+func (NativeBackend) Close() error {
+	return nil
+}
+
+// fastPathBackend reads dates with the built-in reader and only sends files
+// it can't date to the fallback backend, which also handles all writes
+type fastPathBackend struct {
+	native   MetadataBackend
+	fallback MetadataBackend
+}
+
+// newFastPathBackend combines the built-in reader with a full backend such as ExifTool
+func newFastPathBackend(fallback MetadataBackend) MetadataBackend {
+	return &fastPathBackend{native: NativeBackend{}, fallback: fallback}
+}
+
+// ReadTags implements MetadataBackend
+func (b *fastPathBackend) ReadTags(paths []string) (map[string]*FileMetadata, error) {
+	results, err := b.native.ReadTags(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	var remaining []string
+	for _, path := range paths {
+		if !hasDateTag(results[path]) {
+			remaining = append(remaining, path)
+		}
+	}
+	if len(remaining) == 0 {
+		return results, nil
+	}
+
+	fallbackResults, err := b.fallback.ReadTags(remaining)
+	if err != nil {
+		return nil, err
+	}
+	for path, metadata := range fallbackResults {
+		results[path] = metadata
+	}
+	return results, nil
+}
+
+// WriteTags implements MetadataBackend
+func (b *fastPathBackend) WriteTags(path string, tags map[string]string) ([]ExifToolWarning, error) {
+	return b.fallback.WriteTags(path, tags)
+}
+
+// Close implements MetadataBackend. The fallback is owned by its creator.
+func (b *fastPathBackend) Close() error {
+	return nil
+}
+
+// hasDateTag reports whether metadata contains at least one of exifDateTags
+func hasDateTag(metadata *FileMetadata) bool {
+	if metadata == nil || metadata.Err != nil {
+		return false
+	}
+	for _, tag := range exifDateTags {
+		if metadata.Tags[tag] != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// MemoryBackend is an in-memory MetadataBackend. Tags, errors and warnings
+// can be scripted per path, which allows hermetic tests of the whole pipeline
+// and embedding the tool where ExifTool is not available.
+type MemoryBackend struct {
+	mu       sync.Mutex
+	tags     map[string]map[string]string
+	errors   map[string]error
+	warnings map[string][]ExifToolWarning
+}
+
+// NewMemoryBackend creates an empty in-memory backend
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		tags:     make(map[string]map[string]string),
+		errors:   make(map[string]error),
+		warnings: make(map[string][]ExifToolWarning),
+	}
+}
+
+// SetTags sets the tags returned for path
+func (m *MemoryBackend) SetTags(path string, tags map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	copied := make(map[string]string, len(tags))
+	for key, value := range tags {
+		copied[key] = value
+	}
+	m.tags[path] = copied
+}
+
+// Tags returns a copy of the tags currently stored for path
+func (m *MemoryBackend) Tags(path string) map[string]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	copied := make(map[string]string, len(m.tags[path]))
+	for key, value := range m.tags[path] {
+		copied[key] = value
+	}
+	return copied
+}
+
+// SetError makes every read and write of path fail with err
+func (m *MemoryBackend) SetError(path string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errors[path] = err
+}
+
+// SetWarnings sets the warnings reported for every read and write of path
+func (m *MemoryBackend) SetWarnings(path string, warnings []ExifToolWarning) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.warnings[path] = warnings
+}
+
+// ReadTags implements MetadataBackend
+func (m *MemoryBackend) ReadTags(paths []string) (map[string]*FileMetadata, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	results := make(map[string]*FileMetadata, len(paths))
+	for _, path := range paths {
+		if err := m.errors[path]; isExifToolFailure(err) {
+			return nil, err
+		}
+
+		tags := make(map[string]string, len(m.tags[path]))
+		for key, value := range m.tags[path] {
+			tags[key] = value
+		}
+		results[path] = &FileMetadata{Tags: tags, Warnings: m.warnings[path], Err: m.errors[path]}
+	}
+	return results, nil
+}
+
+// WriteTags implements MetadataBackend. AllDates is expanded to the tags it
+// stands for in ExifTool, and list items are removed and added as ExifTool
+// does.
+func (m *MemoryBackend) WriteTags(path string, tags map[string]string) ([]ExifToolWarning, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.errors[path]; err != nil {
+		return m.warnings[path], err
+	}
+
+	if m.tags[path] == nil {
+		m.tags[path] = make(map[string]string)
+	}
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sortTagNames(keys)
+	kept := make(map[string]bool)
+	for _, key := range keys {
+		tag, value := strings.TrimRight(key, "+-"), tags[key]
+		switch {
+		case key == "AllDates":
+			for _, dateTag := range []string{"DateTimeOriginal", "CreateDate", "ModifyDate"} {
+				m.tags[path][dateTag] = value
+			}
+		case kept[tag]:
+		case strings.HasSuffix(key, "-") && value == "":
+			kept[tag] = m.tags[path][tag] != ""
+		case strings.HasSuffix(key, "-"):
+			var items []string
+			for _, item := range strings.Split(m.tags[path][tag], tagListSeparator) {
+				if item != "" && !strings.Contains(tagListSeparator+value+tagListSeparator, tagListSeparator+item+tagListSeparator) {
+					items = append(items, item)
+				}
+			}
+			m.tags[path][tag] = tagList(items...)
+		case strings.HasSuffix(key, "+") && m.tags[path][tag] != "":
+			m.tags[path][tag] += tagListSeparator + value
+		default:
+			m.tags[path][tag] = value
+		}
+	}
+
+	return m.warnings[path], nil
+}
+
+// Close implements MetadataBackend
+func (m *MemoryBackend) Close() error {
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMemoryBackendWriteTags(t *testing.T) {
+	backend := NewMemoryBackend()
+	backend.SetTags("photo.jpg", map[string]string{"Make": "Google"})
+
+	if _, err := backend.WriteTags("photo.jpg", map[string]string{"AllDates": "2019:04:12 15:30:12"}); err != nil {
+		t.Fatalf("Failed to write tags: %v", err)
+	}
+
+	tags := backend.Tags("photo.jpg")
+	for _, tag := range []string{"DateTimeOriginal", "CreateDate", "ModifyDate"} {
+		if tags[tag] != "2019:04:12 15:30:12" {
+			t.Errorf("Expected AllDates to set %s, got %q", tag, tags[tag])
+		}
+	}
+	if tags["Make"] != "Google" {
+		t.Errorf("Expected existing tags to be kept, got Make %q", tags["Make"])
+	}
+
+	// A scripted ExifTool failure fails the whole read, other errors only the file
+	backend.SetError("crash.jpg", ErrExifToolCrashed)
+	if _, err := backend.ReadTags([]string{"photo.jpg", "crash.jpg"}); !errors.Is(err, ErrExifToolCrashed) {
+		t.Errorf("Expected ErrExifToolCrashed for the batch, got %v", err)
+	}
+	backend.SetError("bad.jpg", fmt.Errorf("file format error"))
+	results, err := backend.ReadTags([]string{"photo.jpg", "bad.jpg"})
+	if err != nil {
+		t.Fatalf("Expected per-file error only, got %v", err)
+	}
+	if results["bad.jpg"].Err == nil || results["photo.jpg"].Err != nil {
+		t.Errorf("Expected only bad.jpg to fail, got %v and %v", results["bad.jpg"].Err, results["photo.jpg"].Err)
+	}
+}
+
+func TestFastPathBackendSkipsFallback(t *testing.T) {
+	exif := append([]byte("Exif\x00\x00"), buildTIFFExif("Google", "2019:04:12 15:30:12")...)
+	dated := writeTestFile(t, "dated.jpg", buildJPEG(exif))
+	blank := writeTestFile(t, "blank.jpg", buildJPEG())
+
+	fallback := NewMemoryBackend()
+	fallback.SetTags(blank, map[string]string{"CreateDate": "2020:01:01 00:00:00"})
+	// Reading the dated file from the fallback would fail the whole batch
+	fallback.SetError(dated, ErrExifToolCrashed)
+
+	results, err := newFastPathBackend(fallback).ReadTags([]string{dated, blank})
+	if err != nil {
+		t.Fatalf("Expected dated file to be read natively, got %v", err)
+	}
+	if results[dated].Tags["DateTimeOriginal"] != "2019:04:12 15:30:12" {
+		t.Errorf("Expected native DateTimeOriginal, got %v", results[dated].Tags)
+	}
+	if results[blank].Tags["CreateDate"] != "2020:01:01 00:00:00" {
+		t.Errorf("Expected fallback CreateDate, got %v", results[blank].Tags)
+	}
+}
+
+func TestProcessMediaFileWithMemoryBackend(t *testing.T) {
+	sourceDir := t.TempDir()
+	outputDir := t.TempDir()
+
+	mediaPath := filepath.Join(sourceDir, "IMG_0001.jpg")
+	if err := os.WriteFile(mediaPath, []byte("image data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sidecar := `{"title": "IMG_0001.jpg", "photoTakenTime": {"timestamp": "1555083012"}}`
+	if err := os.WriteFile(mediaPath+".supplemental-metadata.json", []byte(sidecar), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	backend := NewMemoryBackend()
+	config := &Config{SourceDir: sourceDir, OutputDir: outputDir, Move: outputDir, Workers: 1}
+	result := processMediaFile(config, backend, MediaFile{Path: mediaPath, BaseName: "IMG_0001.jpg", Dir: sourceDir})
+
+	if !result.Success {
+		t.Fatalf("Expected processing to succeed, got %v", result.Error)
+	}
+
+	date := time.Unix(1555083012, 0)
+	if got := backend.Tags(mediaPath)["DateTimeOriginal"]; got != date.Format(exifDateFormat) {
+		t.Errorf("Expected sidecar date to be written, got %q", got)
+	}
+	destPath := generateDestinationPath(outputDir, "IMG_0001.jpg", date)
+	if _, err := os.Stat(destPath); err != nil {
+		t.Errorf("Expected file to be moved to %s: %v", destPath, err)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// editedSuffixes are the suffixes Google Photos appends to the names of edited
+// copies, by the language of the account
+var editedSuffixes = []struct {
+	lang   string
+	suffix string
+}{
+	{"en", "-edited"},
+	{"de", "-bearbeitet"},
+	{"fr", "-modifié"},
+	{"es", "-editado"}, // Also Portuguese
+	{"it", "-modificato"},
+	{"nl", "-bewerkt"},
+	{"pl", "-edytowane"},
+	{"sv", "-redigerad"},
+	{"da", "-redigeret"},
+	{"no", "-redigert"},
+	{"fi", "-muokattu"},
+	{"ru", "-изменено"},
+	{"ja", "-編集済み"},
+	{"ko", "-수정됨"},
+	{"zh", "-已修改"},
+}
+
+// Policies for media files that have an edited copy next to them
+const (
+	editedKeepBoth = "keep-both"   // Process both as independent files
+	editedOnly     = "edited-only" // Leave the original in the source
+	editedGroup    = "group"       // Move the edited copy next to its original
+)
+
+// splitEditedSuffix splits a file name without extension into the name of the
+// original, the edited suffix and the duplicate counter that may follow it,
+// as in "IMG_1234" + "-bearbeitet" + "(1)". ok is false for names without an
+// edited suffix.
+func splitEditedSuffix(name string) (original, suffix, counter string, ok bool) {
+	base := name
+	if matches := numberSuffixRegex.FindStringSubmatch(name); len(matches) == 3 {
+		base, counter = matches[1], "("+matches[2]+")"
+	}
+	for _, edited := range editedSuffixes {
+		if len(base) > len(edited.suffix) && strings.HasSuffix(base, edited.suffix) {
+			return strings.TrimSuffix(base, edited.suffix), edited.suffix, counter, true
+		}
+	}
+	return "", "", "", false
+}
+
+// pairEditedFiles maps the path of each edited copy to the original in the
+// same directory, comparing names in NFC. An original with the same extension
+// is preferred, as edits of HEIC photos are often saved as JPEG.
+func pairEditedFiles(mediaFiles []MediaFile) map[string]MediaFile {
+	byName := make(map[string][]MediaFile)
+	for _, file := range mediaFiles {
+		key := filepath.Join(file.Dir, normalizeName(strings.TrimSuffix(file.BaseName, filepath.Ext(file.BaseName))))
+		byName[key] = append(byName[key], file)
+	}
+
+	pairs := make(map[string]MediaFile)
+	for _, file := range mediaFiles {
+		ext := filepath.Ext(file.BaseName)
+		original, _, counter, ok := splitEditedSuffix(normalizeName(strings.TrimSuffix(file.BaseName, ext)))
+		if !ok {
+			continue
+		}
+		candidates := byName[filepath.Join(file.Dir, original+counter)]
+		if len(candidates) == 0 {
+			continue
+		}
+		pairs[file.Path] = candidates[0]
+		for _, candidate := range candidates {
+			if strings.EqualFold(filepath.Ext(candidate.BaseName), ext) {
+				pairs[file.Path] = candidate
+				break
+			}
+		}
+	}
+	return pairs
+}
+
+// processWithEditedPolicy processes mediaFiles, applying config.EditedPolicy to
+// edited copies and their originals. Grouping needs the destination of each
+// original, so edited copies are then processed after everything else.
+func processWithEditedPolicy(config *Config, backends []MetadataBackend, mediaFiles []MediaFile) []Result {
+	switch config.EditedPolicy {
+	case editedOnly:
+		originals := make(map[string]bool)
+		for _, original := range config.Edited {
+			originals[original.Path] = true
+		}
+		var files []MediaFile
+		var skipped []Result
+		for _, file := range mediaFiles {
+			if originals[file.Path] {
+				skipped = append(skipped, Result{File: file, Success: true, Action: "Skipped original, its edited copy is kept"})
+			} else {
+				files = append(files, file)
+			}
+		}
+		return append(processFiles(config, backends, files), skipped...)
+
+	case editedGroup:
+		var files, edited []MediaFile
+		for _, file := range mediaFiles {
+			if _, ok := config.Edited[file.Path]; ok {
+				edited = append(edited, file)
+			} else {
+				files = append(files, file)
+			}
+		}
+		// Both passes are finished together, so -rename numbers their bursts at once
+		results := runWorkers(config, backends, files)
+
+		config.OriginalDests = make(map[string]string)
+		for _, result := range results {
+			if result.DestPath != "" {
+				config.OriginalDests[result.File.Path] = result.DestPath
+			}
+		}
+		if len(edited) > 0 {
+			fmt.Println("\nGrouping edited copies with their originals...")
+		}
+		return finishProcessing(config, append(results, runWorkers(config, backends, edited)...))
+	}
+
+	return processFiles(config, backends, mediaFiles)
+}
+
+// groupedDestination returns the path next to its original that an edited copy
+// is moved to when grouping, named after the original's destination, or "" if
+// the file isn't an edited copy of a moved original
+func groupedDestination(config *Config, file MediaFile, destName string) string {
+	original, ok := config.Edited[file.Path]
+	if !ok {
+		return ""
+	}
+	originalDest, ok := config.OriginalDests[original.Path]
+	if !ok {
+		return ""
+	}
+
+	_, suffix, _, _ := splitEditedSuffix(normalizeName(strings.TrimSuffix(file.BaseName, filepath.Ext(file.BaseName))))
+	originalBase := strings.TrimSuffix(filepath.Base(originalDest), filepath.Ext(originalDest))
+	name := originalBase + suffix + filepath.Ext(destName)
+	return claimDestinationPath(filepath.Join(filepath.Dir(originalDest), name))
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSplitEditedSuffix(t *testing.T) {
+	tests := []struct {
+		name, original, suffix, counter string
+		ok                              bool
+	}{
+		{"IMG_1234-edited", "IMG_1234", "-edited", "", true},
+		{"IMG_1234-bearbeitet(1)", "IMG_1234", "-bearbeitet", "(1)", true},
+		{"IMG_1234-modifié", "IMG_1234", "-modifié", "", true},
+		{"IMG_1234-編集済み", "IMG_1234", "-編集済み", "", true},
+		{"IMG_1234", "", "", "", false},
+		{"-edited", "", "", "", false},
+		{"IMG_1234(1)", "", "", "", false},
+	}
+	for _, tt := range tests {
+		original, suffix, counter, ok := splitEditedSuffix(tt.name)
+		if original != tt.original || suffix != tt.suffix || counter != tt.counter || ok != tt.ok {
+			t.Errorf("splitEditedSuffix(%q) = %q, %q, %q, %t, expected %q, %q, %q, %t",
+				tt.name, original, suffix, counter, ok, tt.original, tt.suffix, tt.counter, tt.ok)
+		}
+	}
+}
+
+func TestLocalizedEditedSidecarMatch(t *testing.T) {
+	dir := t.TempDir()
+	writeSidecar(t, filepath.Join(dir, "IMG_1234.jpg.supplemental-metadata.json"), "IMG_1234.jpg", 1555083012)
+
+	for _, name := range []string{"IMG_1234-bearbeitet.jpg", "IMG_1234-modificato.jpg", "IMG_1234-editado.jpg"} {
+		match := indexSidecarDir(dir).bestMatch(MediaFile{Path: filepath.Join(dir, name), BaseName: name, Dir: dir})
+		if match == nil || !match.Shared {
+			t.Errorf("Expected %s to share the original's sidecar, got %v", name, match)
+		}
+	}
+}
+
+func TestPairEditedFiles(t *testing.T) {
+	dir := "/takeout/Photos from 2019"
+	file := func(name string) MediaFile {
+		return MediaFile{Path: filepath.Join(dir, name), BaseName: name, Dir: dir}
+	}
+	pairs := pairEditedFiles([]MediaFile{
+		file("IMG_1.HEIC"), file("IMG_1.jpg"), file("IMG_1-bearbeitet.jpg"),
+		file("IMG_2.HEIC"), file("IMG_2-edited.jpg"),
+		file("IMG_3-edited.jpg"),
+	})
+
+	expected := map[string]string{
+		"IMG_1-bearbeitet.jpg": "IMG_1.jpg", // Same extension preferred
+		"IMG_2-edited.jpg":     "IMG_2.HEIC",
+	}
+	if len(pairs) != len(expected) {
+		t.Errorf("Expected %d pairs, got %v", len(expected), pairs)
+	}
+	for edited, original := range expected {
+		if got := pairs[filepath.Join(dir, edited)].BaseName; got != original {
+			t.Errorf("Expected %s to pair with %s, got %q", edited, original, got)
+		}
+	}
+}
+
+func TestEditedPolicies(t *testing.T) {
+	saveSupportedExtensions(t)
+	setSupportedExtensions([]string{".jpg"}, extSourceFallback)
+
+	setup := func(t *testing.T, policy string) (*Config, []MediaFile) {
+		sourceDir := t.TempDir()
+		outputDir := t.TempDir()
+		writeMedia(t, filepath.Join(sourceDir, "IMG_1.jpg"))
+		writeMedia(t, filepath.Join(sourceDir, "IMG_1-bearbeitet.jpg"))
+		writeSidecar(t, filepath.Join(sourceDir, "IMG_1.jpg.supplemental-metadata.json"), "IMG_1.jpg", 1555083012)
+
+		mediaFiles, idx, err := scanTakeout(sourceDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config := &Config{SourceDir: sourceDir, OutputDir: outputDir, Move: outputDir, Workers: 1, BatchSize: 1,
+			Sidecars: idx, EditedPolicy: policy, Edited: pairEditedFiles(mediaFiles)}
+		return config, mediaFiles
+	}
+	dayDir := func(config *Config) string {
+		return filepath.Dir(generateDestinationPath(config.OutputDir, "x", time.Unix(1555083012, 0)))
+	}
+
+	t.Run("edited-only", func(t *testing.T) {
+		config, mediaFiles := setup(t, editedOnly)
+		for _, result := range processWithEditedPolicy(config, []MetadataBackend{NewMemoryBackend()}, mediaFiles) {
+			if !result.Success {
+				t.Errorf("%s: %v", result.File.BaseName, result.Error)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(config.SourceDir, "IMG_1.jpg")); err != nil {
+			t.Errorf("Expected the original to stay in the source: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(dayDir(config), "IMG_1-bearbeitet.jpg")); err != nil {
+			t.Errorf("Expected the edited copy to be moved: %v", err)
+		}
+	})
+
+	t.Run("group", func(t *testing.T) {
+		config, mediaFiles := setup(t, editedGroup)
+		// The edited copy has its own, later date but still joins its original
+		backend := NewMemoryBackend()
+		backend.SetTags(filepath.Join(config.SourceDir, "IMG_1-bearbeitet.jpg"), map[string]string{"DateTimeOriginal": "2021:06:01 10:00:00"})
+
+		results := processWithEditedPolicy(config, []MetadataBackend{backend}, mediaFiles)
+		if len(results) != 2 {
+			t.Fatalf("Expected 2 results, got %d", len(results))
+		}
+		for _, result := range results {
+			if !result.Success {
+				t.Errorf("%s: %v", result.File.BaseName, result.Error)
+			}
+		}
+		for _, name := range []string{"IMG_1.jpg", "IMG_1-bearbeitet.jpg"} {
+			if _, err := os.Stat(filepath.Join(dayDir(config), name)); err != nil {
+				t.Errorf("Expected %s in the original's folder: %v", name, err)
+			}
+		}
+	})
+}
+
+func TestGroupedBursts(t *testing.T) {
+	saveSupportedExtensions(t)
+	setSupportedExtensions([]string{".jpg"}, extSourceFallback)
+
+	sourceDir := t.TempDir()
+	outputDir := t.TempDir()
+	dates := map[string]string{
+		"IMG_1.jpg":            "", // No date, so its edited copy is renamed on its own
+		"IMG_1-bearbeitet.jpg": "2019:04:12 15:30:12",
+		"IMG_2.jpg":            "2019:04:12 15:30:12",
+		"IMG_3.jpg":            "2019:04:12 15:30:13",
+		"IMG_3-bearbeitet.jpg": "2021:06:01 10:00:00", // Grouped with IMG_3
+		"IMG_4.jpg":            "2019:04:12 15:30:13",
+	}
+	backend := NewMemoryBackend()
+	for name, date := range dates {
+		writeMedia(t, filepath.Join(sourceDir, name))
+		if date != "" {
+			backend.SetTags(filepath.Join(sourceDir, name), map[string]string{"DateTimeOriginal": date})
+		}
+	}
+
+	config := &Config{SourceDirs: []string{sourceDir}, Move: outputDir, Workers: 1, BatchSize: 1,
+		EditedPolicy: editedGroup, RenameTemplate: "{date:2006-01-02_15-04-05}{ext}"}
+	if err := validateConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	mediaFiles, idx, err := scanTakeout(sourceDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Sidecars, config.Edited = idx, pairEditedFiles(mediaFiles)
+
+	destByName := make(map[string]string)
+	for _, result := range processWithEditedPolicy(config, []MetadataBackend{backend}, mediaFiles) {
+		if result.Success {
+			destByName[result.File.BaseName] = filepath.Base(result.DestPath)
+		}
+	}
+	// Bursts are numbered across both passes, and grouped copies follow
+	expected := map[string]string{
+		"IMG_1-bearbeitet.jpg": "2019-04-12_15-30-12_1.jpg",
+		"IMG_2.jpg":            "2019-04-12_15-30-12_2.jpg",
+		"IMG_3.jpg":            "2019-04-12_15-30-13_1.jpg",
+		"IMG_3-bearbeitet.jpg": "2019-04-12_15-30-13_1-bearbeitet.jpg",
+		"IMG_4.jpg":            "2019-04-12_15-30-13_2.jpg",
+	}
+	for name, destName := range expected {
+		if destByName[name] != destName {
+			t.Errorf("Expected %s to be renamed %s, got %q", name, destName, destByName[name])
+		}
+	}
+
+	dayDir := filepath.Join(outputDir, "ALL_PHOTOS", "2019", "04", "12")
+	entries, err := os.ReadDir(dayDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(expected) {
+		t.Errorf("Expected only the renamed files in %s, got %d entries", dayDir, len(entries))
+	}
+}
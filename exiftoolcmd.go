@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// exifToolEnv is the environment variable used when -exiftool is not given
+const exifToolEnv = "TAKEAWAY_EXIFTOOL"
+
+// minExifToolVersion is the oldest ExifTool release supported at all
+const minExifToolVersion = 10.00
+
+// ErrExifToolTooOld is returned for writes the installed ExifTool can't perform
+var ErrExifToolTooOld = errors.New("exiftool version too old")
+
+var (
+	// exifToolCommand is the command used to run ExifTool, e.g. "exiftool" or
+	// "perl /opt/exiftool/exiftool". Arguments are appended to it.
+	exifToolCommand = []string{"exiftool"}
+
+	// exifToolWriteVersions is the minimum ExifTool version needed to write
+	// dates to each format. Older releases can still read these formats.
+	exifToolWriteVersions = map[string]float64{
+		"HEIC": 11.10,
+		"MP4":  11.00,
+		"MOV":  11.00,
+		"3GP":  11.00,
+	}
+
+	// exifToolReadOnlyExts are scanned extensions of formats ExifTool reads
+	// but can't write any tag to
+	exifToolReadOnlyExts = map[string]bool{
+		".avi": true, ".mkv": true, ".webm": true, ".wmv": true, ".flv": true,
+		".mpg": true, ".mpeg": true, ".mts": true, ".m2ts": true, ".ts": true,
+		".mxf": true, ".bmp": true,
+	}
+)
+
+// parseExifToolCommand splits the -exiftool value into a command and its
+// leading arguments. A path to an existing file is used as-is so that paths
+// containing spaces don't need quoting.
+func parseExifToolCommand(value string) []string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return []string{"exiftool"}
+	}
+	if info, err := os.Stat(value); err == nil && !info.IsDir() {
+		return []string{value}
+	}
+	return strings.Fields(value)
+}
+
+// newExifToolCmd builds an exec.Cmd running ExifTool with args
+func newExifToolCmd(args ...string) *exec.Cmd {
+	argv := append(append([]string{}, exifToolCommand[1:]...), args...)
+	return exec.Command(exifToolCommand[0], argv...)
+}
+
+// probeExifToolVersion runs ExifTool with -ver and parses the version number
+func probeExifToolVersion() (float64, error) {
+	if _, err := exec.LookPath(exifToolCommand[0]); err != nil {
+		return 0, fmt.Errorf("%s not found: %v", exifToolCommand[0], err)
+	}
+
+	output, err := newExifToolCmd("-ver").Output()
+	if err != nil {
+		return 0, fmt.Errorf("failed to run %s -ver: %v", strings.Join(exifToolCommand, " "), err)
+	}
+
+	version, err := strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected ExifTool version %q", strings.TrimSpace(string(output)))
+	}
+	return version, nil
+}
+
+// checkExifToolVersion refuses versions older than minExifToolVersion
+func checkExifToolVersion(version float64) error {
+	if version < minExifToolVersion {
+		return fmt.Errorf("%w: ExifTool %.2f found, %.2f or newer is required", ErrExifToolTooOld, version, minExifToolVersion)
+	}
+	return nil
+}
+
+// unsupportedWriteFormats lists the formats version can't write, with the
+// version each one needs, e.g. "HEIC (11.10)"
+func unsupportedWriteFormats(version float64) []string {
+	var formats []string
+	for name, required := range exifToolWriteVersions {
+		if version < required {
+			formats = append(formats, fmt.Sprintf("%s (%.2f)", name, required))
+		}
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// checkWriteSupport returns ErrExifToolTooOld if version can't write the
+// format of the file at path
+func checkWriteSupport(version float64, path string) error {
+	detected, err := detectFileType(path)
+	if err != nil || detected == nil {
+		return nil
+	}
+	if required, ok := exifToolWriteVersions[detected.Name]; ok && version < required {
+		return fmt.Errorf("%w: ExifTool %.2f can't write %s files, %.2f or newer is required", ErrExifToolTooOld, version, detected.Name, required)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// setExifToolCommand replaces exifToolCommand for the duration of a test
+func setExifToolCommand(t *testing.T, command []string) {
+	t.Helper()
+	previous := exifToolCommand
+	exifToolCommand = command
+	t.Cleanup(func() { exifToolCommand = previous })
+}
+
+func TestParseExifToolCommand(t *testing.T) {
+	spaced := filepath.Join(t.TempDir(), "Image Tools", "exiftool")
+	if err := os.MkdirAll(filepath.Dir(spaced), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(spaced, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		value    string
+		expected []string
+	}{
+		{"", []string{"exiftool"}},
+		{"/usr/local/bin/exiftool", []string{"/usr/local/bin/exiftool"}},
+		{"perl /opt/exiftool/exiftool", []string{"perl", "/opt/exiftool/exiftool"}},
+		{spaced, []string{spaced}},
+	}
+
+	for _, tt := range tests {
+		if got := parseExifToolCommand(tt.value); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("parseExifToolCommand(%q) = %q, expected %q", tt.value, got, tt.expected)
+		}
+	}
+}
+
+func TestExifToolCommandWithInterpreter(t *testing.T) {
+	installFakeExifTool(t, fakeExifToolScript)
+
+	// Run the script through sh, the way a bundled copy is run through perl
+	script := filepath.Join(t.TempDir(), "exiftool.pl")
+	if err := os.WriteFile(script, []byte(fakeExifToolScript), 0644); err != nil {
+		t.Fatal(err)
+	}
+	setExifToolCommand(t, []string{"sh", script})
+	t.Setenv("FAKE_EXIFTOOL_VERSION", "12.76")
+
+	etm, err := NewExifToolManager(1)
+	if err != nil {
+		t.Fatalf("Failed to start ExifTool through interpreter: %v", err)
+	}
+	defer etm.Close()
+
+	if etm.Version() != 12.76 {
+		t.Errorf("Expected version 12.76, got %.2f", etm.Version())
+	}
+	if _, err := etm.GetProcessForWorker(0).GetMetadata("photo.jpg"); err != nil {
+		t.Errorf("Expected read through interpreter to succeed, got %v", err)
+	}
+}
+
+func TestExifToolVersionChecks(t *testing.T) {
+	installFakeExifTool(t, fakeExifToolScript)
+
+	// Too old to be used at all
+	t.Setenv("FAKE_EXIFTOOL_VERSION", "9.70")
+	if _, err := NewExifToolManager(1); !errors.Is(err, ErrExifToolTooOld) {
+		t.Fatalf("Expected ErrExifToolTooOld for ExifTool 9.70, got %v", err)
+	}
+
+	// Usable, but can't write HEIC or QuickTime
+	t.Setenv("FAKE_EXIFTOOL_VERSION", "10.80")
+	etm, err := NewExifToolManager(1)
+	if err != nil {
+		t.Fatalf("Expected ExifTool 10.80 to be accepted, got %v", err)
+	}
+	defer etm.Close()
+
+	if formats := unsupportedWriteFormats(etm.Version()); len(formats) != len(exifToolWriteVersions) {
+		t.Errorf("Expected all write-gated formats to be reported, got %v", formats)
+	}
+
+	heic := writeTestFile(t, "IMG_0001.heic", []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic"))
+	if _, err := etm.GetProcessForWorker(0).WriteTags(heic, map[string]string{"AllDates": "2019:04:12 15:30:12"}); !errors.Is(err, ErrExifToolTooOld) {
+		t.Errorf("Expected HEIC write to fail with ErrExifToolTooOld, got %v", err)
+	}
+
+	jpeg := writeTestFile(t, "IMG_0002.jpg", buildJPEG())
+	if _, err := etm.GetProcessForWorker(0).WriteTags(jpeg, map[string]string{"AllDates": "2019:04:12 15:30:12"}); err != nil {
+		t.Errorf("Expected JPEG write to succeed, got %v", err)
+	}
+}
+
+func TestWriteMultiLineTag(t *testing.T) {
+	installFakeExifTool(t, fakeExifToolScript)
+
+	etm, err := NewExifToolManager(1)
+	if err != nil {
+		t.Fatalf("Failed to create ExifTool manager: %v", err)
+	}
+	defer etm.Close()
+
+	// Each line of the description would otherwise be an argument of its own,
+	// here a file the fake ExifTool fails on
+	description := "Two days in Paris\n/photos/fail.jpg\r\nC:\\Photos"
+	if _, err := etm.GetProcessForWorker(0).WriteTags("/photos/ok.jpg", map[string]string{albumDescriptionTag: description}); err != nil {
+		t.Errorf("Expected the multi-line description to stay one argument, got %v", err)
+	}
+
+	expected := `#[CSTR]-XMP-dc:Description=Two days in Paris\n/photos/fail.jpg\r\nC:\\Photos`
+	if got := argfileLine("-" + albumDescriptionTag + "=" + description); got != expected {
+		t.Errorf("argfileLine() = %q, expected %q", got, expected)
+	}
+	if got := argfileLine("-overwrite_original"); got != "-overwrite_original" {
+		t.Errorf("Expected single-line arguments unchanged, got %q", got)
+	}
+}
+
+func TestWriteListTags(t *testing.T) {
+	installFakeExifTool(t, fakeExifToolScript)
+	log := filepath.Join(t.TempDir(), "args.txt")
+	t.Setenv("FAKE_EXIFTOOL_LOG", log)
+
+	etm, err := NewExifToolManager(1)
+	if err != nil {
+		t.Fatalf("Failed to create ExifTool manager: %v", err)
+	}
+	defer etm.Close()
+
+	if _, err := etm.GetProcessForWorker(0).WriteTags("/photos/ok.jpg", albumXMPTags([]string{"Party", "Trip"})); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "-overwrite_original\n" +
+		"-XMP-dc:Subject-=Party\n-XMP-dc:Subject-=Trip\n-XMP-dc:Subject+=Party\n-XMP-dc:Subject+=Trip\n" +
+		"-XMP-lr:HierarchicalSubject-=Albums|Party\n-XMP-lr:HierarchicalSubject-=Albums|Trip\n" +
+		"-XMP-lr:HierarchicalSubject+=Albums|Party\n-XMP-lr:HierarchicalSubject+=Albums|Trip\n" +
+		"/photos/ok.jpg\n"
+	if !strings.HasPrefix(string(data), expected) {
+		t.Errorf("Expected the arguments to start with\n%s\ngot\n%s", expected, data)
+	}
+}
+
+func TestWriteMissingTag(t *testing.T) {
+	installFakeExifTool(t, fakeExifToolScript)
+	log := filepath.Join(t.TempDir(), "args.txt")
+	t.Setenv("FAKE_EXIFTOOL_LOG", log)
+
+	etm, err := NewExifToolManager(1)
+	if err != nil {
+		t.Fatalf("Failed to create ExifTool manager: %v", err)
+	}
+	defer etm.Close()
+
+	config := &Config{Albums: AlbumOutputs{Description: true}}
+	trip := &Album{Title: "Trip", Folder: "Trip", Metadata: &AlbumMetadata{Description: "Summer"}}
+	if _, err := etm.GetProcessForWorker(0).WriteTags("/photos/ok.jpg", fileXMPTags(config, "", []*Album{trip})); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// -TAG-= before -TAG=X only writes the tag if the file has none
+	expected := "-overwrite_original\n-XMP-dc:Description-=\n-XMP-dc:Description=Summer\n/photos/ok.jpg\n"
+	if !strings.HasPrefix(string(data), expected) {
+		t.Errorf("Expected the arguments to start with\n%s\ngot\n%s", expected, data)
+	}
+}
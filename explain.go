@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runExplain implements the "explain <file>..." subcommand, which shows every
+// sidecar considered for a media file and why it was accepted or rejected
+func runExplain(args []string) error {
+	flags := flag.NewFlagSet("explain", flag.ExitOnError)
+	exifTool := flags.String("exiftool", os.Getenv(exifToolEnv), "ExifTool command")
+	formats := flags.String("formats", "", "JSON file with extensions to include or exclude")
+	flags.BoolVar(&fuzzySidecarMatching, "fuzzy-sidecars", false, "Also match sidecars by arbitrary prefixes and truncated extensions")
+	flags.Usage = func() {
+		fmt.Printf("Usage: %s explain [-exiftool command] [-formats file] [-fuzzy-sidecars] <media file>...\n\n", os.Args[0])
+		fmt.Printf("Lists every JSON file in the media file's directory with the match method,\n")
+		fmt.Printf("confidence and the reason it was or wasn't chosen as the file's sidecar.\n")
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("no media file given")
+	}
+
+	// Other media files of the directory compete for the same sidecars, so
+	// the supported extensions are needed to find them
+	config := &Config{ExifTool: *exifTool, FormatsConfig: *formats}
+	exifToolCommand = parseExifToolCommand(config.ExifTool)
+	version, _ := probeExifToolVersion()
+	if err := loadSupportedExtensions(config, version); err != nil {
+		return err
+	}
+
+	for i, path := range flags.Args() {
+		if i > 0 {
+			fmt.Println()
+		}
+		if err := explainSidecars(os.Stdout, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// explainSidecars writes how the sidecars next to the media file at path
+// were matched and assigned
+func explainSidecars(w io.Writer, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
+
+	dir := filepath.Dir(path)
+	target := MediaFile{Path: path, BaseName: filepath.Base(path), Dir: dir}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var jsonFiles []*Sidecar
+	var mediaFiles []MediaFile
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case entry.IsDir():
+		case strings.HasSuffix(name, ".json"):
+			jsonFiles = append(jsonFiles, newSidecar(filepath.Join(dir, name)))
+		case name == target.BaseName:
+			mediaFiles = append(mediaFiles, target)
+		case supportedExts[strings.ToLower(filepath.Ext(name))]:
+			mediaFiles = append(mediaFiles, MediaFile{Path: filepath.Join(dir, name), BaseName: name, Dir: dir})
+		}
+	}
+	idx := buildSidecarIndex(nil, jsonFiles, mediaFiles)
+
+	fmt.Fprintf(w, "%s\n", path)
+	assigned := idx.Match(target)
+	switch ambiguity := idx.Ambiguity(target); {
+	case assigned != nil:
+		fmt.Fprintf(w, "  Sidecar: %s (%s)\n", assigned.Sidecar.Name, assigned)
+	case ambiguity != nil:
+		fmt.Fprintf(w, "  Sidecar: none, %s\n", ambiguity)
+	default:
+		fmt.Fprintf(w, "  Sidecar: none\n")
+	}
+
+	sidecars := idx.dirs[dir]
+	if sidecars == nil {
+		fmt.Fprintf(w, "  No JSON files in %s\n", dir)
+		return nil
+	}
+
+	// Who each sidecar went to, and the candidates of the explained file
+	owners := make(map[*Sidecar]string)
+	for file, match := range idx.matches {
+		if !match.Shared {
+			owners[match.Sidecar] = file
+		}
+	}
+	ambiguities := make(map[*Sidecar]*SidecarAmbiguity)
+	for _, ambiguity := range idx.Ambiguities() {
+		ambiguities[ambiguity.Sidecar] = ambiguity
+	}
+	candidates := make(map[*Sidecar]*SidecarMatch)
+	targetMatches := sidecars.candidates(target)
+	for i := range targetMatches {
+		candidates[targetMatches[i].Sidecar] = &targetMatches[i]
+	}
+
+	fmt.Fprintf(w, "  Candidates:\n")
+	for _, name := range sidecars.names {
+		sidecar := sidecars.sidecars[name]
+		match := candidates[sidecar]
+
+		switch {
+		case assigned != nil && assigned.Sidecar == sidecar:
+			fmt.Fprintf(w, "    ACCEPTED %s: %s\n", name, assigned)
+		case match == nil:
+			fmt.Fprintf(w, "    REJECTED %s: %s\n", name, nameRejection(target, sidecar))
+		case ambiguities[sidecar] != nil:
+			fmt.Fprintf(w, "    REJECTED %s (%s): %s\n", name, match, ambiguities[sidecar])
+		case owners[sidecar] != "":
+			owner := owners[sidecar]
+			fmt.Fprintf(w, "    REJECTED %s (%s): assigned to %s (%s)\n", name, match, filepath.Base(owner), idx.matches[owner])
+		case assigned != nil:
+			fmt.Fprintf(w, "    REJECTED %s (%s): weaker than the accepted match\n", name, match)
+		default:
+			fmt.Fprintf(w, "    REJECTED %s (%s): not assigned\n", name, match)
+		}
+	}
+	return nil
+}
+
+// nameRejection explains why a sidecar isn't a candidate for file at all
+func nameRejection(file MediaFile, sidecar *Sidecar) string {
+	if !sidecar.Valid {
+		return "not a Google Photos sidecar (no photoTakenTime timestamp)"
+	}
+
+	base := normalizeName(strings.TrimSuffix(file.BaseName, filepath.Ext(file.BaseName)))
+	sidecarName := normalizeName(sidecar.Name)
+	counter := ""
+	if matches := numberSuffixRegex.FindStringSubmatch(base); len(matches) == 3 {
+		base, counter = matches[1], "("+matches[2]+")"
+	}
+	if other := sidecarCounter(sidecarName); other != counter && strings.HasPrefix(sidecarName, base) {
+		if other == "" {
+			return "belongs to a file without a duplicate counter"
+		}
+		return fmt.Sprintf("belongs to a file with duplicate counter %s", other)
+	}
+
+	common := 0
+	for common < len(base) && common < len(sidecarName) && base[common] == sidecarName[common] {
+		common++
+	}
+	if common >= min(10, len(base)) {
+		if !fuzzySidecarMatching {
+			return fmt.Sprintf("shares a %d-char prefix, but doesn't fit Takeout's truncation (fuzzy matching is off)", common)
+		}
+		return fmt.Sprintf("shares a %d-char prefix, but the rest of the name or its length doesn't fit a truncation", common)
+	}
+	return "name doesn't match the file name or any of its truncations"
+}
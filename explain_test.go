@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSidecarMatchMethods(t *testing.T) {
+	dir := t.TempDir()
+	sidecars := []string{
+		"IMG_0001.jpg.supplemental-metadata.json",
+		"IMG_0002.jpg.supplemental-metadata(1).json",
+		"IMG_0003.jpg.json",
+		"IMG_0004.jpg.json",
+		"Screenshot_20190412-15301.json",
+		"VID_0005.mp.json",
+		"Birthday party with grandparents 2018-07-2.jpe.json",
+		"Family reunion at the lake house summer 2019 p.json",
+	}
+	for _, name := range sidecars {
+		writeSidecar(t, filepath.Join(dir, name), name, 1555083012)
+	}
+
+	tests := []struct {
+		file       string
+		method     string
+		confidence float64
+		fuzzy      bool
+	}{
+		{"IMG_0001.jpg", "literal", 1.00, false},
+		{"IMG_0002(1).jpg", "numbered suffix", 0.90, false},
+		{"IMG_0003_.jpg", "underscore strip", 0.95, false},
+		{"IMG_0004-edited.jpg", "-edited strip", 0.90, false},
+		{"Birthday party with grandparents 2018-07-2.jpeg", "takeout truncation", 0.80, false},
+		{"Family reunion at the lake house summer 201.jpg", "takeout truncation", 0.75, false},
+		{"Screenshot_20190412-153012_Photos.jpg", "prefix truncation at 25 chars", 0.55, true},
+		{"VID_0005.mp4", "extension truncation", 0.30, true},
+	}
+
+	sidecarDir := indexSidecarDir(dir)
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			file := MediaFile{Path: filepath.Join(dir, tt.file), BaseName: tt.file, Dir: dir}
+			if tt.fuzzy {
+				// Fuzzy matches are only made when enabled
+				if match := sidecarDir.bestMatch(file); match != nil {
+					t.Errorf("Expected no match without fuzzy matching, got %s (%s)", match.Sidecar.Name, match)
+				}
+				setFuzzySidecarMatching(t, true)
+			}
+			match := sidecarDir.bestMatch(file)
+			if match == nil {
+				t.Fatal("Expected a sidecar match")
+			}
+			if match.Method != tt.method {
+				t.Errorf("Expected method %q, got %q", tt.method, match.Method)
+			}
+			if match.Confidence() < tt.confidence-0.005 || match.Confidence() > tt.confidence+0.005 {
+				t.Errorf("Expected confidence %.2f, got %.2f", tt.confidence, match.Confidence())
+			}
+		})
+	}
+}
+
+func TestExplainSidecars(t *testing.T) {
+	dir := t.TempDir()
+	writeMedia(t, filepath.Join(dir, "IMG_1.jpg"))
+	writeMedia(t, filepath.Join(dir, "IMG_1(1).jpg"))
+	writeSidecar(t, filepath.Join(dir, "IMG_1.jpg.supplemental-metadata.json"), "IMG_1.jpg", 1)
+	writeSidecar(t, filepath.Join(dir, "IMG_1.jpg.supplemental-metadata(1).json"), "IMG_1.jpg", 2)
+	writeSidecar(t, filepath.Join(dir, "Other.jpg.json"), "Other.jpg", 3)
+	writeMedia(t, filepath.Join(dir, "notes.json"))
+
+	saveSupportedExtensions(t)
+	setSupportedExtensions([]string{".jpg"}, extSourceFallback)
+
+	var out bytes.Buffer
+	if err := explainSidecars(&out, filepath.Join(dir, "IMG_1(1).jpg")); err != nil {
+		t.Fatal(err)
+	}
+
+	// With fuzzy matching the other sidecar becomes a candidate, but is taken
+	setFuzzySidecarMatching(t, true)
+	if err := explainSidecars(&out, filepath.Join(dir, "IMG_1(1).jpg")); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"Sidecar: IMG_1.jpg.supplemental-metadata(1).json (numbered suffix, confidence 0.90)",
+		"ACCEPTED IMG_1.jpg.supplemental-metadata(1).json: numbered suffix, confidence 0.90",
+		"REJECTED IMG_1.jpg.supplemental-metadata.json: belongs to a file without a duplicate counter",
+		"REJECTED IMG_1.jpg.supplemental-metadata.json (numbered suffix + extension truncation, confidence 0.30): assigned to IMG_1.jpg (literal, confidence 1.00)",
+		"REJECTED Other.jpg.json: name doesn't match",
+		"REJECTED notes.json: not a Google Photos sidecar",
+	}
+	for _, line := range expected {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("Expected %q in explanation:\n%s", line, out.String())
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileType describes a media format detected from a file's content
+type FileType struct {
+	Name string   // Human readable format name, e.g. "PNG"
+	Ext  string   // Canonical extension including the dot, e.g. ".png"
+	Exts []string // All extensions considered correct for this format
+}
+
+// ExtensionMismatch records a file whose extension does not match its content
+type ExtensionMismatch struct {
+	Detected FileType
+	Ext      string // Extension the file currently has (lowercase)
+}
+
+func (m *ExtensionMismatch) String() string {
+	return fmt.Sprintf("%s content with %s extension (expected %s)", m.Detected.Name, m.Ext, m.Detected.Ext)
+}
+
+var (
+	fileTypeJPEG = FileType{Name: "JPEG", Ext: ".jpg", Exts: []string{".jpg", ".jpeg", ".jpe"}}
+	fileTypePNG  = FileType{Name: "PNG", Ext: ".png", Exts: []string{".png"}}
+	fileTypeGIF  = FileType{Name: "GIF", Ext: ".gif", Exts: []string{".gif"}}
+	fileTypeWEBP = FileType{Name: "WEBP", Ext: ".webp", Exts: []string{".webp"}}
+	fileTypeBMP  = FileType{Name: "BMP", Ext: ".bmp", Exts: []string{".bmp"}}
+	fileTypeHEIC = FileType{Name: "HEIC", Ext: ".heic", Exts: []string{".heic", ".heif", ".hif"}}
+	fileTypeAVIF = FileType{Name: "AVIF", Ext: ".avif", Exts: []string{".avif"}}
+	fileTypeMP4  = FileType{Name: "MP4", Ext: ".mp4", Exts: []string{".mp4", ".m4v"}}
+	fileTypeMOV  = FileType{Name: "MOV", Ext: ".mov", Exts: []string{".mov", ".qt"}}
+	fileType3GP  = FileType{Name: "3GP", Ext: ".3gp", Exts: []string{".3gp", ".3g2"}}
+	fileTypeAVI  = FileType{Name: "AVI", Ext: ".avi", Exts: []string{".avi"}}
+	// Matroska and WebM share the same EBML signature, so either extension is accepted
+	fileTypeMKV = FileType{Name: "MKV", Ext: ".mkv", Exts: []string{".mkv", ".webm"}}
+	// TIFF is also the container for most camera RAW formats
+	fileTypeTIFF = FileType{Name: "TIFF", Ext: ".tif", Exts: []string{".tif", ".tiff", ".dng", ".nef", ".cr2", ".arw", ".orf", ".pef", ".srw", ".rw2", ".raf"}}
+
+	// ISO base media file format brands mapped to their file type
+	ftypBrands = map[string]FileType{
+		"heic": fileTypeHEIC, "heix": fileTypeHEIC, "hevc": fileTypeHEIC, "hevx": fileTypeHEIC,
+		"heim": fileTypeHEIC, "heis": fileTypeHEIC, "hevm": fileTypeHEIC, "hevs": fileTypeHEIC,
+		"mif1": fileTypeHEIC, "msf1": fileTypeHEIC,
+		"avif": fileTypeAVIF, "avis": fileTypeAVIF,
+		"qt  ": fileTypeMOV,
+		"isom": fileTypeMP4, "iso2": fileTypeMP4, "iso4": fileTypeMP4, "iso5": fileTypeMP4,
+		"iso6": fileTypeMP4, "mp41": fileTypeMP4, "mp42": fileTypeMP4, "avc1": fileTypeMP4,
+		"dash": fileTypeMP4, "M4V ": fileTypeMP4, "M4VH": fileTypeMP4, "M4VP": fileTypeMP4,
+		"MSNV": fileTypeMP4, "XAVC": fileTypeMP4,
+		"3gp4": fileType3GP, "3gp5": fileType3GP, "3gp6": fileType3GP, "3g2a": fileType3GP,
+	}
+
+	// ExifTool FileType values mapped to our file types, used when the magic
+	// bytes are not recognized
+	exifToolFileTypes = map[string]FileType{
+		"JPEG": fileTypeJPEG, "PNG": fileTypePNG, "GIF": fileTypeGIF, "WEBP": fileTypeWEBP,
+		"BMP": fileTypeBMP, "HEIC": fileTypeHEIC, "HEIF": fileTypeHEIC, "AVIF": fileTypeAVIF,
+		"MP4": fileTypeMP4, "M4V": fileTypeMP4, "MOV": fileTypeMOV, "3GP": fileType3GP,
+		"3G2": fileType3GP, "AVI": fileTypeAVI, "MKV": fileTypeMKV, "WEBM": fileTypeMKV,
+		"TIFF": fileTypeTIFF,
+	}
+)
+
+// detectFileType identifies the format of a file from its leading magic bytes.
+// It returns nil if the format is not recognized.
+func detectFileType(path string) (*FileType, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header := make([]byte, 32)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+
+	return detectFileTypeFromHeader(header[:n]), nil
+}
+
+func detectFileTypeFromHeader(header []byte) *FileType {
+	var ft FileType
+
+	switch {
+	case bytes.HasPrefix(header, []byte{0xFF, 0xD8, 0xFF}):
+		ft = fileTypeJPEG
+	case bytes.HasPrefix(header, []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1A, '\n'}):
+		ft = fileTypePNG
+	case bytes.HasPrefix(header, []byte("GIF87a")), bytes.HasPrefix(header, []byte("GIF89a")):
+		ft = fileTypeGIF
+	case len(header) >= 12 && bytes.Equal(header[0:4], []byte("RIFF")) && bytes.Equal(header[8:12], []byte("WEBP")):
+		ft = fileTypeWEBP
+	case len(header) >= 12 && bytes.Equal(header[0:4], []byte("RIFF")) && bytes.Equal(header[8:12], []byte("AVI ")):
+		ft = fileTypeAVI
+	case bytes.HasPrefix(header, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		ft = fileTypeMKV
+	case bytes.HasPrefix(header, []byte("II*\x00")), bytes.HasPrefix(header, []byte("MM\x00*")):
+		ft = fileTypeTIFF
+	case len(header) >= 12 && bytes.Equal(header[4:8], []byte("ftyp")):
+		brandType, ok := ftypBrands[string(header[8:12])]
+		if !ok {
+			return nil
+		}
+		ft = brandType
+	case bytes.HasPrefix(header, []byte("BM")) && len(header) >= 14:
+		ft = fileTypeBMP
+	default:
+		return nil
+	}
+
+	return &ft
+}
+
+// checkFileExtension compares a file's extension with its detected content
+// type. Magic bytes are tried first and ExifTool's FileType is used as a
+// fallback. It returns nil when the extension is correct or the content type
+// could not be determined.
+func checkFileExtension(path string, exifData map[string]string) *ExtensionMismatch {
+	detected, err := detectFileType(path)
+	if err != nil || detected == nil {
+		exifType, ok := exifToolFileTypes[strings.ToUpper(exifData["FileType"])]
+		if !ok {
+			return nil
+		}
+		detected = &exifType
+	}
+
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, valid := range detected.Exts {
+		if ext == valid {
+			return nil
+		}
+	}
+
+	return &ExtensionMismatch{Detected: *detected, Ext: ext}
+}
+
+// correctedFileName replaces the extension of fileName with the canonical
+// extension of the detected type, preserving the original case style
+func correctedFileName(fileName string, mismatch *ExtensionMismatch) string {
+	ext := filepath.Ext(fileName)
+	newExt := mismatch.Detected.Ext
+	if ext != "" && ext == strings.ToUpper(ext) {
+		newExt = strings.ToUpper(newExt)
+	}
+	return strings.TrimSuffix(fileName, ext) + newExt
+}
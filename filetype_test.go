@@ -0,0 +1,103 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectFileTypeFromHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   []byte
+		expected string
+	}{
+		{"jpeg", []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x10}, "JPEG"},
+		{"png", []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1A, '\n', 0x00}, "PNG"},
+		{"gif", []byte("GIF89a\x01\x00"), "GIF"},
+		{"webp", []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), "WEBP"},
+		{"avi", []byte("RIFF\x00\x00\x00\x00AVI LIST"), "AVI"},
+		{"heic", []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00"), "HEIC"},
+		{"mov", []byte("\x00\x00\x00\x14ftypqt  \x00\x00\x00\x00"), "MOV"},
+		{"mp4", []byte("\x00\x00\x00\x20ftypisom\x00\x00\x02\x00"), "MP4"},
+		{"tiff", []byte("II*\x00\x08\x00\x00\x00"), "TIFF"},
+		{"unknown ftyp brand", []byte("\x00\x00\x00\x14ftypzzzz\x00\x00\x00\x00"), ""},
+		{"text", []byte("hello world"), ""},
+		{"empty", []byte{}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ft := detectFileTypeFromHeader(tt.header)
+			got := ""
+			if ft != nil {
+				got = ft.Name
+			}
+			if got != tt.expected {
+				t.Errorf("Expected type %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestCheckFileExtension(t *testing.T) {
+	tmpDir := t.TempDir()
+	pngHeader := []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1A, '\n', 0x00, 0x00}
+
+	// PNG content exported with a .jpg extension
+	mismatched := filepath.Join(tmpDir, "IMG_0001.jpg")
+	if err := os.WriteFile(mismatched, pngHeader, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mismatch := checkFileExtension(mismatched, nil)
+	if mismatch == nil {
+		t.Fatal("Expected PNG content with .jpg extension to be reported")
+	}
+	if mismatch.Detected.Name != "PNG" || mismatch.Ext != ".jpg" {
+		t.Errorf("Unexpected mismatch: %s", mismatch)
+	}
+
+	// Correct extension, any case
+	matched := filepath.Join(tmpDir, "IMG_0002.PNG")
+	if err := os.WriteFile(matched, pngHeader, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if mismatch := checkFileExtension(matched, nil); mismatch != nil {
+		t.Errorf("Expected no mismatch for PNG content with .PNG extension, got %s", mismatch)
+	}
+
+	// Unknown magic bytes fall back to ExifTool's FileType
+	unknown := filepath.Join(tmpDir, "clip.mov")
+	if err := os.WriteFile(unknown, []byte("not a real header"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if mismatch := checkFileExtension(unknown, nil); mismatch != nil {
+		t.Errorf("Expected unknown content to be ignored, got %s", mismatch)
+	}
+	mismatch = checkFileExtension(unknown, map[string]string{"FileType": "MP4"})
+	if mismatch == nil || mismatch.Detected.Name != "MP4" {
+		t.Errorf("Expected ExifTool FileType MP4 to be reported as mismatch, got %v", mismatch)
+	}
+}
+
+func TestCorrectedFileName(t *testing.T) {
+	tests := []struct {
+		fileName string
+		detected FileType
+		expected string
+	}{
+		{"IMG_0001.jpg", fileTypePNG, "IMG_0001.png"},
+		{"IMG_0001.JPG", fileTypeHEIC, "IMG_0001.HEIC"},
+		{"VID_0001(1).mov", fileTypeMP4, "VID_0001(1).mp4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fileName, func(t *testing.T) {
+			got := correctedFileName(tt.fileName, &ExtensionMismatch{Detected: tt.detected})
+			if got != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Sources of the supported extensions, shown by -list-formats
+const (
+	extSourceExifTool = "exiftool -listf"
+	extSourceCache    = "cache"
+	extSourceFallback = "built-in list"
+	extSourceConfig   = "config file"
+	extSourceExcluded = "excluded by config file"
+)
+
+// supportedExtSources records where each extension in supportedExts came from.
+// Extensions removed by the config file are kept with extSourceExcluded.
+var supportedExtSources map[string]string
+
+// extensionCache is the on-disk cache of ExifTool's -listf output
+type extensionCache struct {
+	Version    float64  `json:"version"`
+	Extensions []string `json:"extensions"`
+}
+
+// FormatOverrides is the user config file adjusting the extensions scanned
+type FormatOverrides struct {
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+}
+
+// normalizeExt lowercases an extension and adds the leading dot
+func normalizeExt(ext string) string {
+	ext = strings.ToLower(strings.TrimSpace(ext))
+	if ext != "" && !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return ext
+}
+
+// setSupportedExtensions replaces supportedExts, recording source for each
+func setSupportedExtensions(exts []string, source string) {
+	supportedExts = make(map[string]bool, len(exts))
+	supportedExtSources = make(map[string]string, len(exts))
+	for _, ext := range exts {
+		ext = normalizeExt(ext)
+		// Skip JSON files as they are sidecar files, not media files
+		if ext == "" || ext == ".json" {
+			continue
+		}
+		supportedExts[ext] = true
+		supportedExtSources[ext] = source
+	}
+}
+
+// defaultTakeawayDir returns the takeaway directory under a user directory
+// such as os.UserCacheDir, or "" if it can't be determined
+func defaultTakeawayDir(userDir func() (string, error)) string {
+	dir, err := userDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "takeaway")
+}
+
+// extensionCachePath returns the cache file for an ExifTool version
+func extensionCachePath(cacheDir string, version float64) string {
+	return filepath.Join(cacheDir, fmt.Sprintf("extensions-%.2f.json", version))
+}
+
+// loadExtensionCache loads the cached extension list for version. It
+// reports whether a valid cache was found.
+func loadExtensionCache(cacheDir string, version float64) bool {
+	if cacheDir == "" || version <= 0 {
+		return false
+	}
+
+	data, err := os.ReadFile(extensionCachePath(cacheDir, version))
+	if err != nil {
+		return false
+	}
+	var cache extensionCache
+	if err := json.Unmarshal(data, &cache); err != nil || cache.Version != version || len(cache.Extensions) == 0 {
+		return false
+	}
+
+	setSupportedExtensions(cache.Extensions, extSourceCache)
+	return true
+}
+
+// saveExtensionCache writes the extensions found by ExifTool to the cache
+func saveExtensionCache(cacheDir string, version float64) error {
+	cache := extensionCache{Version: version}
+	for ext, source := range supportedExtSources {
+		if source == extSourceExifTool {
+			cache.Extensions = append(cache.Extensions, ext)
+		}
+	}
+	sort.Strings(cache.Extensions)
+
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so concurrent runs never see a partial cache
+	path := extensionCachePath(cacheDir, version)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// applyFormatOverrides adds and removes extensions listed in the config file
+// at path. A missing file is only an error when required is set.
+func applyFormatOverrides(path string, required bool) error {
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && !required {
+			return nil
+		}
+		return fmt.Errorf("failed to read formats config: %v", err)
+	}
+
+	var overrides FormatOverrides
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return fmt.Errorf("failed to parse formats config %s: %v", path, err)
+	}
+
+	for _, ext := range overrides.Include {
+		if ext = normalizeExt(ext); ext != "" && ext != ".json" {
+			supportedExts[ext] = true
+			supportedExtSources[ext] = extSourceConfig
+		}
+	}
+	for _, ext := range overrides.Exclude {
+		if ext = normalizeExt(ext); ext != "" {
+			delete(supportedExts, ext)
+			supportedExtSources[ext] = extSourceExcluded
+		}
+	}
+	return nil
+}
+
+// loadSupportedExtensions resolves the extensions to scan: the cache for the
+// ExifTool version, else ExifTool's -listf (which is then cached), else the
+// built-in list, adjusted by the formats config file
+func loadSupportedExtensions(config *Config, version float64) error {
+	cacheDir := defaultTakeawayDir(os.UserCacheDir)
+
+	if !loadExtensionCache(cacheDir, version) {
+		if err := initSupportedExtensions(); err != nil {
+			fmt.Printf("Warning: Failed to get extensions from ExifTool (%v), using fallback list\n", err)
+		} else if cacheDir != "" && version > 0 {
+			if err := saveExtensionCache(cacheDir, version); err != nil {
+				fmt.Printf("Warning: Failed to cache supported extensions: %v\n", err)
+			}
+		}
+	}
+
+	path, required := config.FormatsConfig, true
+	if path == "" {
+		if dir := defaultTakeawayDir(os.UserConfigDir); dir != "" {
+			path, required = filepath.Join(dir, "formats.json"), false
+		}
+	}
+	return applyFormatOverrides(path, required)
+}
+
+// printSupportedFormats lists every extension with the source it came from,
+// followed by the extensions excluded by the config file
+func printSupportedFormats(w io.Writer) {
+	var scanned, excluded []string
+	for ext := range supportedExtSources {
+		if supportedExts[ext] {
+			scanned = append(scanned, ext)
+		} else {
+			excluded = append(excluded, ext)
+		}
+	}
+	sort.Strings(scanned)
+	sort.Strings(excluded)
+
+	fmt.Fprintf(w, "Extensions scanned (%d):\n", len(scanned))
+	for _, ext := range scanned {
+		fmt.Fprintf(w, "  %-10s %s\n", ext, supportedExtSources[ext])
+	}
+	if len(excluded) > 0 {
+		fmt.Fprintf(w, "\nExtensions excluded (%d):\n", len(excluded))
+		for _, ext := range excluded {
+			fmt.Fprintf(w, "  %-10s %s\n", ext, supportedExtSources[ext])
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeListFormatsScript answers -listf and counts how often it was asked
+const fakeListFormatsScript = `#!/bin/sh
+if [ "$1" = "-listf" ]; then
+	echo x >> "$LISTF_CALLS"
+	echo "Supported file extensions:"
+	echo "  JPG JPEG HEIC MOV JSON"
+	echo "  XYZ"
+fi
+`
+
+// saveSupportedExtensions restores the extension globals after a test
+func saveSupportedExtensions(t *testing.T) {
+	t.Helper()
+	exts, sources := supportedExts, supportedExtSources
+	t.Cleanup(func() { supportedExts, supportedExtSources = exts, sources })
+}
+
+func TestLoadSupportedExtensionsCache(t *testing.T) {
+	saveSupportedExtensions(t)
+	installFakeExifTool(t, fakeListFormatsScript)
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	calls := filepath.Join(t.TempDir(), "calls")
+	t.Setenv("LISTF_CALLS", calls)
+
+	// First run asks ExifTool and writes the cache
+	if err := loadSupportedExtensions(&Config{}, 12.40); err != nil {
+		t.Fatal(err)
+	}
+	if supportedExtSources[".xyz"] != extSourceExifTool {
+		t.Errorf("Expected .xyz from ExifTool, got %q", supportedExtSources[".xyz"])
+	}
+	if supportedExts[".json"] {
+		t.Error("JSON files should not be included in supported extensions")
+	}
+
+	// Second run with the same version is served from the cache
+	if err := loadSupportedExtensions(&Config{}, 12.40); err != nil {
+		t.Fatal(err)
+	}
+	if supportedExtSources[".xyz"] != extSourceCache {
+		t.Errorf("Expected .xyz from cache, got %q", supportedExtSources[".xyz"])
+	}
+
+	// A different version doesn't use the old cache
+	if err := loadSupportedExtensions(&Config{}, 12.50); err != nil {
+		t.Fatal(err)
+	}
+	if supportedExtSources[".xyz"] != extSourceExifTool {
+		t.Errorf("Expected .xyz from ExifTool for a new version, got %q", supportedExtSources[".xyz"])
+	}
+
+	data, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "x"); n != 2 {
+		t.Errorf("Expected ExifTool -listf to run twice, ran %d times", n)
+	}
+}
+
+func TestApplyFormatOverrides(t *testing.T) {
+	saveSupportedExtensions(t)
+	setSupportedExtensions([]string{".jpg", ".gif", ".mov"}, extSourceFallback)
+
+	path := filepath.Join(t.TempDir(), "formats.json")
+	if err := os.WriteFile(path, []byte(`{"include": ["DNG", ".raw"], "exclude": ["gif"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyFormatOverrides(path, true); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ext := range []string{".jpg", ".mov", ".dng", ".raw"} {
+		if !supportedExts[ext] {
+			t.Errorf("Expected %s to be scanned", ext)
+		}
+	}
+	if supportedExts[".gif"] {
+		t.Error("Expected .gif to be excluded")
+	}
+
+	var out bytes.Buffer
+	printSupportedFormats(&out)
+	for _, expected := range []string{".dng       config file", ".jpg       built-in list", ".gif       excluded by config file"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected %q in format list:\n%s", expected, out.String())
+		}
+	}
+
+	// A missing default config is fine, a missing -formats file is not
+	missing := filepath.Join(t.TempDir(), "missing.json")
+	if err := applyFormatOverrides(missing, false); err != nil {
+		t.Errorf("Expected missing optional config to be ignored, got %v", err)
+	}
+	if err := applyFormatOverrides(missing, true); err == nil {
+		t.Error("Expected missing -formats file to be an error")
+	}
+}
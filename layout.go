@@ -0,0 +1,285 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultLayout is the ALL_PHOTOS/YYYY/MM/DD structure used without -layout
+const defaultLayout = "{year}/{month}/{day}/{name}{ext}"
+
+// layoutSeparators are the characters dropped next to a token with no value
+const layoutSeparators = "_- "
+
+// defaultHashLength is the number of hex digits {hash} expands to
+const defaultHashLength = 8
+
+// videoExts are the extensions {type} reports as "video" when ExifTool gave
+// no MIME type
+var videoExts = map[string]bool{
+	".mp4": true, ".m4v": true, ".mov": true, ".qt": true, ".3gp": true, ".3g2": true,
+	".avi": true, ".mkv": true, ".webm": true, ".mts": true, ".m2ts": true, ".wmv": true,
+	".mpg": true, ".mpeg": true,
+}
+
+// Layout is a parsed -layout template: the path of a file under ALL_PHOTOS
+// made of literal text and {token} or {token:argument} placeholders
+type Layout struct {
+	template string
+	parts    []layoutPart
+}
+
+// layoutPart is either literal text or a token with its optional argument
+type layoutPart struct {
+	literal string
+	token   string
+	arg     string
+}
+
+// layoutTokens lists the tokens a layout may use and what they expand to
+var layoutTokens = map[string]string{
+	"year":   "Year of the date, e.g. 2019",
+	"month":  "Month of the date, e.g. 04",
+	"day":    "Day of the date, e.g. 12",
+	"hour":   "Hour of the date, e.g. 15",
+	"minute": "Minute of the date, e.g. 30",
+	"second": "Second of the date, e.g. 12",
+	"date":   "Date in a Go layout, e.g. {date:20060102_150405} (default 2006-01-02)",
+	"name":   "File name without extension",
+	"ext":    "Extension including the dot, e.g. .jpg",
+	"album":  "Folder name under ALBUMS of the first album the file is in, empty outside albums",
+	"make":   "Camera make from EXIF, e.g. Google",
+	"model":  "Camera model from EXIF, e.g. Pixel 4",
+	"type":   "Media type, photo or video",
+	"title":  "Original title from the sidecar without extension, else the file name",
+	"hash":   "First hex digits of the SHA-256 of the content, e.g. {hash:12} (default 8)",
+}
+
+// parseLayout parses a layout template. The last path segment must contain
+// {ext}, so files keep a usable extension.
+func parseLayout(template string) (*Layout, error) {
+	layout := &Layout{template: template}
+	rest := template
+	for rest != "" {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			layout.parts = append(layout.parts, layoutPart{literal: rest})
+			break
+		}
+		if open > 0 {
+			layout.parts = append(layout.parts, layoutPart{literal: rest[:open]})
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed { in layout %q", template)
+		}
+
+		token, arg, _ := strings.Cut(rest[open+1:open+end], ":")
+		if _, ok := layoutTokens[token]; !ok {
+			return nil, fmt.Errorf("unknown layout token {%s}", token)
+		}
+		if token == "hash" && arg != "" {
+			if n, err := strconv.Atoi(arg); err != nil || n < 1 || n > sha256.Size*2 {
+				return nil, fmt.Errorf("invalid hash length in {hash:%s}, expected 1 to %d", arg, sha256.Size*2)
+			}
+		}
+		layout.parts = append(layout.parts, layoutPart{token: token, arg: arg})
+		rest = rest[open+end+1:]
+	}
+
+	if i := strings.LastIndexByte(template, '/'); !strings.Contains(template[i+1:], "{ext}") {
+		return nil, fmt.Errorf("layout %q must end with the file name and its {ext}, e.g. {name}{ext}", template)
+	}
+	return layout, nil
+}
+
+// layoutValues holds what a layout's tokens expand to for one file. The hash
+// is only computed when the layout uses it.
+type layoutValues struct {
+	date      time.Time
+	name      string
+	ext       string
+	album     string
+	make      string
+	model     string
+	mediaType string
+	title     string
+	path      string // File to hash for {hash}
+}
+
+// render expands the layout into a relative path. Token values can't add
+// folders: separators in them are replaced, every segment is sanitized, and
+// segments left empty, such as {album} outside albums, are dropped. A token
+// expanding to nothing also takes one adjacent "_", "-" or space with it, so
+// {date}_{model}{ext} doesn't leave a dangling "_" without a camera model.
+func (l *Layout) render(values layoutValues) (string, error) {
+	path := ""
+	trimNext := false
+	for _, part := range l.parts {
+		if part.token == "" {
+			literal := part.literal
+			if trimNext && literal != "" && strings.ContainsRune(layoutSeparators, rune(literal[0])) {
+				literal = literal[1:]
+			}
+			path += literal
+			trimNext = false
+			continue
+		}
+		value, err := values.expand(part.token, part.arg)
+		if err != nil {
+			return "", err
+		}
+		if value == "" {
+			if n := len(path); n > 0 && strings.ContainsRune(layoutSeparators, rune(path[n-1])) {
+				path = path[:n-1]
+			} else {
+				trimNext = true
+			}
+			continue
+		}
+		path += strings.NewReplacer("/", "_", "\\", "_").Replace(value)
+	}
+
+	var segments []string
+	for _, segment := range strings.Split(path, "/") {
+		if segment = sanitizePathSegment(segment); segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	if len(segments) == 0 {
+		return "", fmt.Errorf("layout %q gives an empty path", l.template)
+	}
+	return filepath.Join(segments...), nil
+}
+
+// expand returns the value of a token
+func (v layoutValues) expand(token, arg string) (string, error) {
+	switch token {
+	case "year":
+		return fmt.Sprintf("%04d", v.date.Year()), nil
+	case "month":
+		return fmt.Sprintf("%02d", v.date.Month()), nil
+	case "day":
+		return fmt.Sprintf("%02d", v.date.Day()), nil
+	case "hour":
+		return fmt.Sprintf("%02d", v.date.Hour()), nil
+	case "minute":
+		return fmt.Sprintf("%02d", v.date.Minute()), nil
+	case "second":
+		return fmt.Sprintf("%02d", v.date.Second()), nil
+	case "date":
+		if arg == "" {
+			arg = "2006-01-02"
+		}
+		return v.date.Format(arg), nil
+	case "name":
+		return v.name, nil
+	case "ext":
+		return v.ext, nil
+	case "album":
+		return v.album, nil
+	case "make":
+		return v.make, nil
+	case "model":
+		return v.model, nil
+	case "type":
+		return v.mediaType, nil
+	case "title":
+		if v.title == "" {
+			return v.name, nil
+		}
+		return v.title, nil
+	case "hash":
+		n := defaultHashLength
+		if arg != "" {
+			n, _ = strconv.Atoi(arg)
+		}
+		sum, err := fileHash(v.path)
+		if err != nil {
+			return "", fmt.Errorf("failed to hash file: %v", err)
+		}
+		return sum[:n], nil
+	}
+	return "", fmt.Errorf("unknown layout token {%s}", token)
+}
+
+// fileHash returns the hex SHA-256 of the content of path
+func fileHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// mediaType returns "video" or "photo" from the MIME type ExifTool reported,
+// falling back to the extension
+func mediaType(tags map[string]string, ext string) string {
+	if mime := tags["MIMEType"]; mime != "" {
+		if strings.HasPrefix(mime, "video/") {
+			return "video"
+		}
+		return "photo"
+	}
+	if videoExts[strings.ToLower(ext)] {
+		return "video"
+	}
+	return "photo"
+}
+
+// destinationPath returns where file is moved under ALL_PHOTOS, named
+// destName: the YYYY/MM/DD folder of date, or the path given by -layout
+func destinationPath(config *Config, file MediaFile, match *SidecarMatch, tags map[string]string, destName string, date time.Time) (string, error) {
+	if config.Layout == nil {
+		return generateDestinationPath(config.OutputDir, destName, date), nil
+	}
+
+	rel, err := config.Layout.render(newLayoutValues(config, config.Layout, file, match, tags, destName, date))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(config.OutputDir, "ALL_PHOTOS", rel), nil
+}
+
+// newLayoutValues gathers the token values of file, named destName so far.
+// The sidecar is only looked up when layout uses {title}.
+func newLayoutValues(config *Config, layout *Layout, file MediaFile, match *SidecarMatch, tags map[string]string, destName string, date time.Time) layoutValues {
+	ext := filepath.Ext(destName)
+	var album string
+	if albums := albumsFor(config, file); len(albums) > 0 {
+		album = albums[0].Folder
+	}
+	values := layoutValues{
+		date:      date,
+		name:      strings.TrimSuffix(destName, ext),
+		ext:       ext,
+		album:     album,
+		make:      strings.TrimSpace(tags["Make"]),
+		model:     strings.TrimSpace(tags["Model"]),
+		mediaType: mediaType(tags, ext),
+		path:      file.Path,
+	}
+	if strings.Contains(layout.template, "{title}") {
+		if match == nil {
+			match, _ = lookupSidecar(config, file)
+		}
+		if match != nil {
+			title := normalizeName(match.Sidecar.Title)
+			values.title = strings.TrimSuffix(title, filepath.Ext(title))
+		}
+	}
+	return values
+}
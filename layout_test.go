@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLayout(t *testing.T) {
+	valid := []string{
+		defaultLayout,
+		"{year}/{year}-{month}/{album}/{date:20060102_150405}_{name}{ext}",
+		"{year}/{make} {model}/{hash:12}{ext}",
+		"{name}{ext}",
+	}
+	for _, template := range valid {
+		if _, err := parseLayout(template); err != nil {
+			t.Errorf("parseLayout(%q): %v", template, err)
+		}
+	}
+
+	invalid := []string{
+		"{year}/{month}",                   // No file name
+		"{year}/{ext}/{name}",              // {ext} not in the file name
+		"{year}/{camera}/{name}{ext}",      // Unknown token
+		"{year}/{name{ext}",                // Unknown token "name{ext"
+		"{year}/{name}{ext",                // Unclosed
+		"{year}/{hash:0}{ext}",             // Hash too short
+		"{year}/{hash:abc}{ext}",           // Hash length not a number
+		"{year}/{hash:65}{name}{ext}",      // Longer than SHA-256
+		"{year}/{month}/{name}{ext}/{day}", // File name not last
+	}
+	for _, template := range invalid {
+		if _, err := parseLayout(template); err == nil {
+			t.Errorf("parseLayout(%q): expected an error", template)
+		}
+	}
+}
+
+func TestLayoutRender(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "IMG_1.jpg")
+	writeMedia(t, path)
+	sum := sha256.Sum256([]byte("media"))
+	hash := hex.EncodeToString(sum[:])
+
+	values := layoutValues{
+		date:      time.Date(2019, 4, 12, 15, 30, 12, 0, time.UTC),
+		name:      "IMG_1",
+		ext:       ".jpg",
+		album:     "Trip",
+		make:      "Google",
+		model:     "Pixel 4",
+		mediaType: "photo",
+		title:     "IMG_1 original",
+		path:      path,
+	}
+	tests := []struct {
+		template string
+		values   func(*layoutValues)
+		expected string
+	}{
+		{defaultLayout, nil, "2019/04/12/IMG_1.jpg"},
+		{"{year}/{year}-{month}/{album}/{date:20060102_150405}_{name}{ext}", nil, "2019/2019-04/Trip/20190412_153012_IMG_1.jpg"},
+		{"{year}/{year}-{month}-{day}/{name}{ext}", nil, "2019/2019-04-12/IMG_1.jpg"},
+		{"{type}/{make} {model}/{hour}{minute}{second}_{title}{ext}", nil, "photo/Google Pixel 4/153012_IMG_1 original.jpg"},
+		{"{date}/{hash}{ext}", nil, "2019-04-12/" + hash[:8] + ".jpg"},
+		{"{hash:12}_{name}{ext}", nil, hash[:12] + "_IMG_1.jpg"},
+		// Empty segments are dropped
+		{"{year}/{album}/{name}{ext}", func(v *layoutValues) { v.album = "" }, "2019/IMG_1.jpg"},
+		// Without a title, {title} is the file name
+		{"{title}{ext}", func(v *layoutValues) { v.title = "" }, "IMG_1.jpg"},
+		// Values can't add folders or leave the output directory
+		{"{album}/{name}{ext}", func(v *layoutValues) { v.album = "../Trip/2019: Summer?" }, ".._Trip_2019_ Summer_/IMG_1.jpg"},
+		{"{album}/{name}{ext}", func(v *layoutValues) { v.album = ".." }, "IMG_1.jpg"},
+		{"{make}/{name}{ext}", func(v *layoutValues) { v.make = "  Canon.. " }, "Canon/IMG_1.jpg"},
+	}
+	for _, test := range tests {
+		layout, err := parseLayout(test.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v := values
+		if test.values != nil {
+			test.values(&v)
+		}
+		got, err := layout.render(v)
+		if err != nil {
+			t.Errorf("%s: %v", test.template, err)
+			continue
+		}
+		if expected := filepath.FromSlash(test.expected); got != expected {
+			t.Errorf("%s: expected %q, got %q", test.template, expected, got)
+		}
+	}
+}
+
+func TestSanitizePathSegment(t *testing.T) {
+	tests := []struct {
+		segment  string
+		expected string
+	}{
+		{"Trip", "Trip"},
+		{"a/b\\c", "a_b_c"},
+		{`<>:"|?*`, "_______"},
+		{"tab\there", "tab_here"},
+		{"  spaced  ", "spaced"},
+		{"trailing dots...", "trailing dots"},
+		{".", ""},
+		{"..", ""},
+		{"", ""},
+		{"CON", "_CON"},
+		{"nul.txt", "_nul.txt"},
+		{"Console", "Console"},
+		{strings.Repeat("ü", 200) + ".jpg", strings.Repeat("ü", 125) + ".jpg"},
+	}
+	for _, test := range tests {
+		if got := sanitizePathSegment(test.segment); got != test.expected {
+			t.Errorf("sanitizePathSegment(%q) = %q, expected %q", test.segment, got, test.expected)
+		}
+	}
+}
+
+func TestMoveWithLayout(t *testing.T) {
+	saveSupportedExtensions(t)
+	setSupportedExtensions([]string{".jpg", ".mp4"}, extSourceFallback)
+
+	sourceDir := t.TempDir()
+	outputDir := t.TempDir()
+	album := filepath.Join(sourceDir, "Trip")
+	writeMedia(t, filepath.Join(album, "IMG_1.jpg"))
+	writeMedia(t, filepath.Join(album, "VID_1.mp4"))
+	if err := os.WriteFile(filepath.Join(album, "metadata.json"), []byte(`{"title": "Trip"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Same name and second as the album photo
+	writeMedia(t, filepath.Join(sourceDir, "Photos from 2019", "IMG_1.jpg"))
+
+	config := &Config{SourceDirs: []string{sourceDir}, Move: outputDir, Workers: 1, BatchSize: 1,
+		LayoutTemplate: "{type}/{year}/{model}/{album}/{date:20060102_150405}_{name}{ext}"}
+	if err := validateConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	mediaFiles, idx, err := scanTakeout(sourceDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Sidecars = idx
+
+	backend := NewMemoryBackend()
+	for _, file := range mediaFiles {
+		backend.SetTags(file.Path, map[string]string{"DateTimeOriginal": "2019:04:12 15:30:12", "Model": "Pixel 4"})
+	}
+	for _, result := range processFiles(config, []MetadataBackend{backend}, mediaFiles) {
+		if !result.Success {
+			t.Errorf("%s: %v", result.File.Path, result.Error)
+		}
+	}
+
+	for _, name := range []string{
+		"photo/2019/Pixel 4/Trip/20190412_153012_IMG_1.jpg",
+		"video/2019/Pixel 4/Trip/20190412_153012_VID_1.mp4",
+		"photo/2019/Pixel 4/20190412_153012_IMG_1.jpg",
+	} {
+		if _, err := os.Stat(filepath.Join(outputDir, "ALL_PHOTOS", filepath.FromSlash(name))); err != nil {
+			t.Errorf("Expected %s: %v", name, err)
+		}
+	}
+	if _, err := os.Lstat(generateAlbumSymlinkPath(outputDir, "Trip", "20190412_153012_IMG_1.jpg")); err != nil {
+		t.Errorf("Expected the album symlink named after the new name: %v", err)
+	}
+
+	// A layout only applies when moving
+	if err := validateConfig(&Config{SourceDirs: []string{sourceDir}, LayoutTemplate: defaultLayout}); err == nil {
+		t.Error("Expected -layout without -move to be refused")
+	}
+}
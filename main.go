@@ -7,13 +7,10 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	"io/fs"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -28,6 +25,54 @@ type Config struct {
 	Move      string
 	DryRun    bool
 	Workers   int
+
+	// SourceDirs lists every source root, SourceDir being the first. Takeouts
+	// split into several archives are extracted to one root per part, or
+	// given as the archives themselves.
+	SourceDirs []string
+
+	// Archives holds the Takeout archives read directly, nil when every
+	// source is an extracted folder
+	Archives *ArchiveSet
+
+	// OutputArchivePath is a .tar, .tgz or .zip to write the organized files
+	// to instead of a folder. Move then points at its staging folder.
+	OutputArchivePath string
+	OutputArchive     *OutputArchive
+
+	FixExtensions   bool
+	LayoutTemplate  string
+	Layout          *Layout // Parsed LayoutTemplate, nil for the default YYYY/MM/DD folders
+	RenameTemplate  string
+	Rename          *Layout // Parsed RenameTemplate, nil to keep file names
+	AlbumModes      string
+	Albums          AlbumOutputs // Parsed AlbumModes
+	RestoreNames    bool
+	FuzzySidecars   bool
+	NFCNames        bool
+	EditedPolicy    string
+	ExifToolTimeout time.Duration
+	BatchSize       int
+	ExifTool        string
+	FormatsConfig   string
+	ListFormats     bool
+
+	// Sidecars is the sidecar index built while scanning. When nil, sidecars
+	// are looked up in each file's directory as it is processed.
+	Sidecars *SidecarIndex
+
+	// AlbumFolders assigns each album of the index its folder under ALBUMS.
+	// When nil, folders are named after the title of each album as found.
+	AlbumFolders *AlbumFolders
+
+	// AlbumCopies holds the albums of each file worked out across the Takeout
+	// when moving. When nil, files are in the album of their own folder only.
+	AlbumCopies *AlbumCopies
+
+	// Edited maps each edited copy to its original when EditedPolicy isn't
+	// editedKeepBoth. OriginalDests holds where grouped originals were moved.
+	Edited        map[string]MediaFile
+	OriginalDests map[string]string
 }
 
 // MediaFile represents a media file to be processed
@@ -35,6 +80,19 @@ type MediaFile struct {
 	Path     string
 	BaseName string
 	Dir      string
+
+	// Archive is set for files read from a Takeout archive. They are
+	// extracted to Path right before their job is sent to a worker.
+	Archive *ArchiveEntry
+}
+
+// SourcePath returns where the file was found: its path, or its location
+// inside the archive it was read from
+func (f MediaFile) SourcePath() string {
+	if f.Archive != nil {
+		return f.Archive.SourcePath()
+	}
+	return f.Path
 }
 
 // SidecarData represents the structure of Google Photos JSON sidecar files
@@ -45,38 +103,89 @@ type SidecarData struct {
 	} `json:"photoTakenTime"`
 }
 
-// AlbumMetadata represents the structure of album metadata.json files
-type AlbumMetadata struct {
-	Title string `json:"title"`
-}
-
 // ExifToolProcess represents a single persistent ExifTool process
 type ExifToolProcess struct {
-	cmd     *exec.Cmd
-	stdin   io.WriteCloser
-	stdout  io.ReadCloser
-	scanner *bufio.Scanner
-	mu      sync.Mutex
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	stdout   io.ReadCloser
+	stderr   io.ReadCloser
+	lines    <-chan string
+	errLines <-chan string
+	done     chan struct{}
+	seq      int
+	timeout  time.Duration
+	version  float64
+	mu       sync.Mutex
+}
+
+// ExifToolWarning is a warning or error message reported by ExifTool
+type ExifToolWarning struct {
+	Level   string // "Warning" or "Error"
+	Message string
+}
+
+func (w ExifToolWarning) String() string {
+	return w.Level + ": " + w.Message
 }
 
 // ExifToolManager manages multiple ExifTool processes (one per worker)
 type ExifToolManager struct {
 	processes []*ExifToolProcess
+	version   float64
 }
 
-// Job represents a work item for the worker pool
+// Job represents a work item for the worker pool. Files are grouped so that
+// their metadata can be read with a single ExifTool command.
 type Job struct {
-	File MediaFile
+	Files []MediaFile
 }
 
 // Result represents the result of processing a file
 type Result struct {
-	File    MediaFile
-	Success bool
-	Action  string
-	Error   error
+	File              MediaFile
+	Success           bool
+	Action            string
+	Error             error
+	ExtensionMismatch *ExtensionMismatch
+	Quarantined       bool
+	SidecarMatch      *SidecarMatch // How the sidecar providing the date was matched
+	DestPath          string        // Where the file was (or would be) moved
+	Albums            []*Album      // Albums the file is in, including those of its album copies
+	AlbumLinks        []string      // Album symlinks, hard links or copies created for the file
+	RenameTarget      string        // Path -rename gave the file, before burst numbering
+	GroupedWith       string        // Source path of the original an edited copy was grouped with
+	Warnings          []ExifToolWarning
 }
 
+var (
+	// ErrNoCreationDate is returned for files with neither an EXIF date nor a sidecar
+	ErrNoCreationDate = errors.New("no creation date found in EXIF or sidecar")
+
+	// ErrInvalidSidecarDate is returned when the sidecar's photoTakenTime can't be parsed
+	ErrInvalidSidecarDate = errors.New("failed to parse sidecar date")
+)
+
+const (
+	// defaultExifToolTimeout is how long a single ExifTool command may run
+	// before the process is considered hung and restarted
+	defaultExifToolTimeout = 30 * time.Second
+
+	// defaultBatchSize is how many files are read per ExifTool command
+	defaultBatchSize = 50
+
+	// exifToolMaxAttempts is how many times a file is tried after ExifTool
+	// crashes or times out on it before the file is quarantined
+	exifToolMaxAttempts = 2
+)
+
+var (
+	// ErrExifToolTimeout is returned when ExifTool does not answer a command in time
+	ErrExifToolTimeout = errors.New("exiftool timed out")
+
+	// ErrExifToolCrashed is returned when the ExifTool process exits unexpectedly
+	ErrExifToolCrashed = errors.New("exiftool process exited unexpectedly")
+)
+
 var (
 	// Supported media file extensions (populated dynamically from ExifTool)
 	// This map is initialized at startup by calling `exiftool -listf` to get the
@@ -95,8 +204,20 @@ var (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "explain" {
+		if err := runExplain(os.Args[2:]); err != nil {
+			log.Fatal("explain: ", err)
+		}
+		return
+	}
+
 	config := parseFlags()
 
+	if config.ListFormats {
+		listFormats(config)
+		return
+	}
+
 	if err := validateConfig(config); err != nil {
 		log.Fatal("Configuration error:", err)
 	}
@@ -104,8 +225,10 @@ func main() {
 	fmt.Printf("Google Photos Takeout Cleanup Tool v%s\n", version)
 	fmt.Printf("===========================================\n\n")
 	fmt.Printf("Configuration:\n")
-	fmt.Printf("  Source: %s\n", config.SourceDir)
-	if config.Move != "" {
+	fmt.Printf("  Source: %s\n", strings.Join(config.SourceDirs, ", "))
+	if config.OutputArchivePath != "" {
+		fmt.Printf("  Output archive: %s\n", config.OutputArchivePath)
+	} else if config.Move != "" {
 		fmt.Printf("  Output: %s\n", config.OutputDir)
 		fmt.Printf("  Move files to: %s\n", config.Move)
 	} else {
@@ -114,67 +237,172 @@ func main() {
 	fmt.Printf("  Dry run: %t\n", config.DryRun)
 	fmt.Printf("  Workers: %d\n\n", config.Workers)
 
-	// Initialize supported file extensions from ExifTool
-	err := initSupportedExtensions()
+	// Initialize ExifTool manager with one process per worker. A dry run can
+	// go ahead without ExifTool using the built-in metadata reader.
+	exifToolCommand = parseExifToolCommand(config.ExifTool)
+	exifTool, err := NewExifToolManager(config.Workers)
 	if err != nil {
-		fmt.Printf("Warning: Failed to get extensions from ExifTool (%v), using fallback list\n", err)
+		if !config.DryRun {
+			log.Fatal("Failed to initialize ExifTool:", err)
+		}
+		fmt.Printf("Warning: ExifTool not available (%v), dry run will use the built-in metadata reader only\n", err)
+	} else {
+		defer exifTool.Close()
+		exifTool.SetTimeout(config.ExifToolTimeout)
+
+		fmt.Printf("Using ExifTool %.2f (%s)\n", exifTool.Version(), strings.Join(exifToolCommand, " "))
+		if formats := unsupportedWriteFormats(exifTool.Version()); len(formats) > 0 {
+			fmt.Printf("Warning: this ExifTool is too old to write dates to %s; upgrade to update these files\n", strings.Join(formats, ", "))
+		}
+		fmt.Println()
 	}
 
-	// Initialize ExifTool manager with one process per worker
-	exifTool, err := NewExifToolManager(config.Workers)
-	if err != nil {
-		log.Fatal("Failed to initialize ExifTool:", err)
+	// Initialize supported file extensions, cached per ExifTool version
+	var exifToolVersion float64
+	if exifTool != nil {
+		exifToolVersion = exifTool.Version()
+	}
+	if err := loadSupportedExtensions(config, exifToolVersion); err != nil {
+		log.Fatal("Failed to load supported formats:", err)
+	}
+
+	var backends []MetadataBackend
+	if exifTool != nil {
+		backends = exifTool.Backends()
+	} else {
+		backends = make([]MetadataBackend, config.Workers)
+		for i := range backends {
+			backends[i] = NativeBackend{}
+		}
+	}
+
+	if config.OutputArchivePath != "" && !config.DryRun {
+		out, err := newOutputArchive(config.OutputArchivePath)
+		if err != nil {
+			log.Fatal("Failed to create output archive:", err)
+		}
+		config.OutputArchive = out
+		defer closeOutputArchive(out)
 	}
-	defer exifTool.Close()
 
 	// Scan for media files
 	fmt.Println("Scanning for media files...")
-	mediaFiles, err := scanMediaFiles(config.SourceDir)
+	fuzzySidecarMatching = config.FuzzySidecars
+	mediaFiles, sidecars, archives, err := scanSources(config)
+	if archives != nil {
+		config.Archives = archives
+		defer closeArchives(archives)
+	}
 	if err != nil {
 		log.Fatal("Failed to scan media files:", err)
 	}
+	config.Sidecars = sidecars
+	config.AlbumFolders = newAlbumFolders(sidecars)
 
-	fmt.Printf("Found %d media files\n\n", len(mediaFiles))
+	if archives != nil {
+		fmt.Printf("Reading %d archives, extracting to %s\n", len(archives.roots), archives.stagingDir)
+	}
+	fmt.Printf("Found %d media files and %d JSON files\n\n", len(mediaFiles), sidecars.Len())
+	if ambiguities := sidecars.Ambiguities(); len(ambiguities) > 0 {
+		fmt.Printf("Warning: %d sidecars match several files equally well and were not assigned:\n", len(ambiguities))
+		for _, ambiguity := range ambiguities {
+			fmt.Printf("  AMBIGUOUS: %s\n", ambiguity)
+		}
+		fmt.Println()
+	}
 
 	if len(mediaFiles) == 0 {
 		fmt.Println("No media files found to process.")
 		return
 	}
 
+	// Album copies of files elsewhere in the Takeout are linked from there
+	var skippedCopies []Result
+	if config.Move != "" {
+		config.AlbumCopies = matchAlbumCopies(config, mediaFiles)
+		mediaFiles, skippedCopies = config.AlbumCopies.split(mediaFiles)
+		if len(skippedCopies) > 0 {
+			fmt.Printf("Found %d album copies of files elsewhere in the Takeout\n\n", len(skippedCopies))
+		}
+	}
+
+	if config.EditedPolicy != editedKeepBoth {
+		config.Edited = pairEditedFiles(mediaFiles)
+		fmt.Printf("Found %d edited copies next to their original\n\n", len(config.Edited))
+	}
+
 	// Process files using worker pool
-	results := processFiles(config, exifTool, mediaFiles)
+	results := append(processWithEditedPolicy(config, backends, mediaFiles), skippedCopies...)
+
+	if err := writeAlbumManifests(config, results); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
 
 	// Print summary
 	printSummary(results)
+	if err := writeReports(config, results); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
 }
 
 func parseFlags() *Config {
 	var showVersion bool
 	config := &Config{}
 
-	flag.StringVar(&config.SourceDir, "source", "", "Path to the Google Photos Takeout root directory")
+	flag.Var((*stringListFlag)(&config.SourceDirs), "source", "Path to the Google Photos Takeout root directory (repeat for each extracted part of a multi-archive Takeout)")
 	flag.StringVar(&config.OutputDir, "output", "", "Path to the output directory for cleaned files")
 	flag.StringVar(&config.Move, "move", "", "Path to move organized files to (optional)")
+	flag.StringVar(&config.OutputArchivePath, "output-archive", "", "Write the organized files to this .tar, .tgz or .zip instead of a folder (alternative to -move)")
 	flag.BoolVar(&config.DryRun, "dry-run", false, "Simulate process without making changes")
 	flag.IntVar(&config.Workers, "workers", 4, "Number of worker goroutines")
+	flag.StringVar(&config.LayoutTemplate, "layout", "", "Path of moved files under ALL_PHOTOS, e.g. {year}/{year}-{month}/{name}{ext} (default "+defaultLayout+")")
+	flag.StringVar(&config.RenameTemplate, "rename", "", "Rename moved files from their date and metadata, e.g. {date:2006-01-02_15-04-05}_{model}{ext}")
+	flag.StringVar(&config.AlbumModes, "albums", albumSymlink, "Album outputs, comma-separated: one of symlink, hardlink or copy, plus any of m3u, json, xmp and description, or none")
+	flag.BoolVar(&config.FixExtensions, "fix-extensions", false, "Rename files whose extension does not match their content (only with -move)")
+	flag.BoolVar(&config.RestoreNames, "restore-names", false, "Give files truncated by Takeout their original name from the sidecar title (only with -move)")
+	flag.BoolVar(&config.FuzzySidecars, "fuzzy-sidecars", false, "Also match sidecars by arbitrary prefixes and truncated extensions when Takeout's naming rule finds none")
+	flag.BoolVar(&config.NFCNames, "nfc-names", false, "Rename moved files to Unicode NFC, e.g. names extracted on macOS (only with -move)")
+	flag.StringVar(&config.EditedPolicy, "edited-policy", editedKeepBoth, "What to do with originals that have an edited copy: keep-both, edited-only or group (only with -move)")
+	flag.IntVar(&config.BatchSize, "batch-size", defaultBatchSize, "Number of files to read per ExifTool command")
+	flag.DurationVar(&config.ExifToolTimeout, "timeout", defaultExifToolTimeout, "Maximum time ExifTool may spend on a single file (0 disables)")
+	flag.StringVar(&config.ExifTool, "exiftool", "", "ExifTool command, e.g. /opt/exiftool/exiftool or \"perl /opt/exiftool/exiftool\" (default $"+exifToolEnv+" or exiftool)")
+	flag.StringVar(&config.FormatsConfig, "formats", "", "JSON file with extensions to include or exclude (default <config dir>/takeaway/formats.json)")
+	flag.BoolVar(&config.ListFormats, "list-formats", false, "List the extensions that will be scanned and where each came from")
 	flag.BoolVar(&showVersion, "version", false, "Show version information")
 
 	flag.Usage = func() {
 		fmt.Printf("Google Photos Takeout Cleanup Tool v%s\n\n", version)
 		fmt.Printf("Usage: %s [OPTIONS]\n\n", os.Args[0])
 		fmt.Printf("Required flags:\n")
-		fmt.Printf("  -source string    Path to the Google Photos Takeout root directory\n\n")
+		fmt.Printf("  -source string         Path to the Google Photos Takeout root directory, repeat for each extracted part of a multi-archive Takeout\n\n")
 		fmt.Printf("Optional flags:\n")
-		fmt.Printf("  -move string      Path to move organized files to (if omitted, updates EXIF in place)\n")
-		fmt.Printf("  -output string    Path to the output directory for cleaned files (optional, only used with -move)\n")
-		fmt.Printf("  -dry-run          Simulate process without making changes\n")
-		fmt.Printf("  -workers int      Number of worker goroutines (default 4)\n")
-		fmt.Printf("  -version          Show version information\n")
-		fmt.Printf("  -help             Show this help message\n\n")
+		fmt.Printf("  -move string           Path to move organized files to (if omitted, updates EXIF in place)\n")
+		fmt.Printf("  -output string         Path to the output directory for cleaned files (optional, only used with -move)\n")
+		fmt.Printf("  -output-archive string Write the organized files to this .tar, .tgz or .zip instead of a folder (alternative to -move)\n")
+		fmt.Printf("  -dry-run               Simulate process without making changes\n")
+		fmt.Printf("  -workers int           Number of worker goroutines (default 4)\n")
+		fmt.Printf("  -layout string         Path of moved files under ALL_PHOTOS, e.g. {year}/{year}-{month}/{name}{ext} (default %s)\n", defaultLayout)
+		fmt.Printf("  -rename string         Rename moved files from their date and metadata, e.g. {date:2006-01-02_15-04-05}_{model}{ext}\n")
+		fmt.Printf("  -albums string         Album outputs, comma-separated: one of symlink, hardlink or copy, plus any of m3u, json, xmp and description, or none (default %s)\n", albumSymlink)
+		fmt.Printf("  -fix-extensions        Rename files whose extension does not match their content (only with -move)\n")
+		fmt.Printf("  -restore-names         Give files truncated by Takeout their original name from the sidecar title (only with -move)\n")
+		fmt.Printf("  -fuzzy-sidecars        Also match sidecars by arbitrary prefixes and truncated extensions when Takeout's naming rule finds none\n")
+		fmt.Printf("  -nfc-names             Rename moved files to Unicode NFC, e.g. names extracted on macOS (only with -move)\n")
+		fmt.Printf("  -edited-policy string  What to do with originals that have an edited copy: keep-both, edited-only or group (default %s, only with -move)\n", editedKeepBoth)
+		fmt.Printf("  -batch-size int        Number of files to read per ExifTool command (default %d)\n", defaultBatchSize)
+		fmt.Printf("  -timeout duration      Maximum time ExifTool may spend on a single file, 0 disables (default 30s)\n")
+		fmt.Printf("  -exiftool string       ExifTool command, e.g. \"perl /opt/exiftool/exiftool\" (default $%s or exiftool)\n", exifToolEnv)
+		fmt.Printf("  -formats string        JSON file with extensions to include or exclude (default <config dir>/takeaway/formats.json)\n")
+		fmt.Printf("  -list-formats          List the extensions that will be scanned and where each came from\n")
+		fmt.Printf("  -version               Show version information\n")
+		fmt.Printf("  -help                  Show this help message\n\n")
+		fmt.Printf("Commands:\n")
+		fmt.Printf("  %s explain <file>...  Show every sidecar considered for a file and why it was chosen or rejected\n\n", os.Args[0])
 		fmt.Printf("Examples:\n")
 		fmt.Printf("  %s -source ./takeout\n", os.Args[0])
 		fmt.Printf("  %s -source ./takeout -move ./organized -dry-run\n", os.Args[0])
-		fmt.Printf("  %s -source ./takeout -workers 8\n\n", os.Args[0])
+		fmt.Printf("  %s -source ./takeout -workers 8\n", os.Args[0])
+		fmt.Printf("  %s -source ./takeout-1 -source ./takeout-2 -move ./organized\n\n", os.Args[0])
 	}
 
 	flag.Parse()
@@ -184,13 +412,100 @@ func parseFlags() *Config {
 		os.Exit(0)
 	}
 
+	if config.ExifTool == "" {
+		config.ExifTool = os.Getenv(exifToolEnv)
+	}
+
 	return config
 }
 
+// listFormats prints the extensions that would be scanned, for -list-formats
+func listFormats(config *Config) {
+	exifToolCommand = parseExifToolCommand(config.ExifTool)
+	version, err := probeExifToolVersion()
+	if err != nil {
+		fmt.Printf("Warning: ExifTool not available (%v)\n", err)
+	} else {
+		fmt.Printf("ExifTool %.2f (%s)\n", version, strings.Join(exifToolCommand, " "))
+	}
+
+	if err := loadSupportedExtensions(config, version); err != nil {
+		log.Fatal("Failed to load supported formats:", err)
+	}
+	printSupportedFormats(os.Stdout)
+}
+
+// stringListFlag collects the values of a flag given several times
+type stringListFlag []string
+
+func (f *stringListFlag) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *stringListFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
 func validateConfig(config *Config) error {
-	if config.SourceDir == "" {
+	if len(config.SourceDirs) == 0 && config.SourceDir != "" {
+		config.SourceDirs = []string{config.SourceDir}
+	}
+	if len(config.SourceDirs) == 0 {
 		return errors.New("source directory is required")
 	}
+	config.SourceDir = config.SourceDirs[0]
+
+	// Writing an archive moves files into its staging folder first; a dry
+	// run shows paths inside the archive
+	if config.OutputArchivePath != "" {
+		if config.Move != "" {
+			return errors.New("-output-archive and -move can't be used together")
+		}
+		if !isOutputArchive(config.OutputArchivePath) {
+			return fmt.Errorf("unsupported output archive %s, expected .tar, .tgz, .tar.gz or .zip", config.OutputArchivePath)
+		}
+		if _, err := os.Stat(config.OutputArchivePath); err == nil {
+			return fmt.Errorf("output archive already exists: %s", config.OutputArchivePath)
+		}
+		config.Move = outputStagingDir(config.OutputArchivePath)
+		if config.DryRun {
+			config.Move = config.OutputArchivePath
+		}
+	}
+
+	if config.LayoutTemplate != "" {
+		if config.Move == "" {
+			return errors.New("-layout requires -move or -output-archive")
+		}
+		layout, err := parseLayout(config.LayoutTemplate)
+		if err != nil {
+			return err
+		}
+		config.Layout = layout
+	}
+	if config.AlbumModes == "" {
+		config.AlbumModes = albumSymlink
+	}
+	albums, err := parseAlbumOutputs(config.AlbumModes)
+	if err != nil {
+		return err
+	}
+	if config.OutputArchivePath != "" && albums.Link != albumSymlink && albums.Link != "" {
+		return fmt.Errorf("-albums %s isn't supported with -output-archive, use symlink or the m3u and json manifests", albums.Link)
+	}
+	config.Albums = albums
+
+	if config.RenameTemplate != "" {
+		if config.Move == "" {
+			return errors.New("-rename requires -move or -output-archive")
+		}
+		rename, err := parseRenameTemplate(config.RenameTemplate)
+		if err != nil {
+			return err
+		}
+		config.Rename = rename
+	}
 
 	// If move is specified, use it as the output directory
 	if config.Move != "" {
@@ -204,9 +519,35 @@ func validateConfig(config *Config) error {
 		config.Workers = 4
 	}
 
-	// Check if source directory exists
-	if _, err := os.Stat(config.SourceDir); os.IsNotExist(err) {
-		return fmt.Errorf("source directory does not exist: %s", config.SourceDir)
+	if config.BatchSize <= 0 {
+		config.BatchSize = 1
+	}
+
+	switch config.EditedPolicy {
+	case "":
+		config.EditedPolicy = editedKeepBoth
+	case editedKeepBoth:
+	case editedOnly, editedGroup:
+		if config.Move == "" {
+			return fmt.Errorf("-edited-policy %s requires -move", config.EditedPolicy)
+		}
+	default:
+		return fmt.Errorf("unknown edited policy %q, expected %s, %s or %s", config.EditedPolicy, editedKeepBoth, editedOnly, editedGroup)
+	}
+
+	// Check if the source directories exist. Archives are extracted into
+	// the output directory, so reading them needs somewhere to move files.
+	for _, sourceDir := range config.SourceDirs {
+		if _, err := os.Stat(sourceDir); os.IsNotExist(err) {
+			return fmt.Errorf("source directory does not exist: %s", sourceDir)
+		}
+		archives, err := archiveSources(sourceDir)
+		if err != nil {
+			return err
+		}
+		if len(archives) > 0 && config.Move == "" {
+			return fmt.Errorf("reading Takeout archives requires -move: %s", sourceDir)
+		}
 	}
 
 	// Create output directory if it doesn't exist (unless dry run) and if moving files
@@ -221,22 +562,22 @@ func validateConfig(config *Config) error {
 
 // initSupportedExtensions populates the supportedExts map from ExifTool's supported formats
 func initSupportedExtensions() error {
-	cmd := exec.Command("exiftool", "-listf")
+	cmd := newExifToolCmd("-listf")
 	output, err := cmd.Output()
 	if err != nil {
 		// Fallback to hardcoded list if ExifTool is not available
-		supportedExts = map[string]bool{
-			".jpg": true, ".jpeg": true, ".png": true, ".tiff": true, ".tif": true,
-			".bmp": true, ".gif": true, ".webp": true, ".heic": true, ".heif": true,
-			".mp4": true, ".mov": true, ".avi": true, ".mkv": true, ".wmv": true,
-			".m4v": true, ".3gp": true, ".webm": true, ".flv": true, ".mts": true,
-			".m2ts": true, ".ts": true, ".mxf": true, ".nef": true, ".rw2": true, ".mpg": true,
-			".mpeg": true,
-		}
+		setSupportedExtensions([]string{
+			".jpg", ".jpeg", ".png", ".tiff", ".tif",
+			".bmp", ".gif", ".webp", ".heic", ".heif",
+			".mp4", ".mov", ".avi", ".mkv", ".wmv",
+			".m4v", ".3gp", ".webm", ".flv", ".mts",
+			".m2ts", ".ts", ".mxf", ".nef", ".rw2", ".mpg",
+			".mpeg",
+		}, extSourceFallback)
 		return err
 	}
 
-	supportedExts = make(map[string]bool)
+	var extensions []string
 	lines := strings.Split(string(output), "\n")
 
 	for _, line := range lines {
@@ -247,68 +588,43 @@ func initSupportedExtensions() error {
 		}
 
 		// Parse extensions from the output
-		extensions := strings.Fields(line)
-		for _, ext := range extensions {
-			if ext != "" {
-				// Convert to lowercase and add dot prefix
-				normalizedExt := "." + strings.ToLower(ext)
-				// Skip JSON files as they are sidecar files, not media files
-				if normalizedExt != ".json" {
-					supportedExts[normalizedExt] = true
-				}
-			}
-		}
+		extensions = append(extensions, strings.Fields(line)...)
 	}
 
+	setSupportedExtensions(extensions, extSourceExifTool)
 	return nil
 }
 
-func scanMediaFiles(sourceDir string) ([]MediaFile, error) {
-	var mediaFiles []MediaFile
-
-	err := filepath.WalkDir(sourceDir, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if d.IsDir() {
-			return nil
-		}
-
-		ext := strings.ToLower(filepath.Ext(path))
-		if supportedExts[ext] {
-			baseName := filepath.Base(path)
-			dir := filepath.Dir(path)
-
-			mediaFiles = append(mediaFiles, MediaFile{
-				Path:     path,
-				BaseName: baseName,
-				Dir:      dir,
-			})
-		}
-
-		return nil
-	})
-
-	return mediaFiles, err
+// processFiles processes media files with a pool of config.Workers workers.
+// Each worker uses the backend at its index.
+func processFiles(config *Config, backends []MetadataBackend, mediaFiles []MediaFile) []Result {
+	return finishProcessing(config, runWorkers(config, backends, mediaFiles))
 }
 
-func processFiles(config *Config, exifTool *ExifToolManager, mediaFiles []MediaFile) []Result {
-	jobs := make(chan Job, len(mediaFiles))
+// runWorkers processes media files with the worker pool and collects their
+// results, leaving burst numbering to finishProcessing
+func runWorkers(config *Config, backends []MetadataBackend, mediaFiles []MediaFile) []Result {
+	// Batches of archive members are extracted as they are sent, so the
+	// jobs queue is kept short to stage only what workers are about to read
+	jobs := make(chan Job, config.Workers)
 	results := make(chan Result, len(mediaFiles))
 
 	// Start workers
 	var wg sync.WaitGroup
 	for i := 0; i < config.Workers; i++ {
 		wg.Add(1)
-		go worker(i, config, exifTool, jobs, results, &wg)
+		go worker(config, backends[i], jobs, results, &wg)
 	}
 
-	// Send jobs
+	// Send jobs in batches
 	go func() {
 		defer close(jobs)
-		for _, file := range mediaFiles {
-			jobs <- Job{File: file}
+		batchSize := max(config.BatchSize, 1)
+		for start := 0; start < len(mediaFiles); start += batchSize {
+			end := min(start+batchSize, len(mediaFiles))
+			if files := extractArchiveFiles(config, mediaFiles[start:end], results); len(files) > 0 {
+				jobs <- Job{Files: files}
+			}
 		}
 	}()
 
@@ -323,7 +639,11 @@ func processFiles(config *Config, exifTool *ExifToolManager, mediaFiles []MediaF
 	processed := 0
 	total := len(mediaFiles)
 
+	// Renamed files are archived once bursts are numbered
 	for result := range results {
+		if config.OutputArchive != nil && config.Rename == nil {
+			result = config.OutputArchive.addResult(result)
+		}
 		allResults = append(allResults, result)
 		processed++
 
@@ -332,35 +652,135 @@ func processFiles(config *Config, exifTool *ExifToolManager, mediaFiles []MediaF
 		}
 	}
 
+	return allResults
+}
+
+// finishProcessing numbers the bursts of -rename once all files are
+// processed, and archives the renamed files
+func finishProcessing(config *Config, allResults []Result) []Result {
+	if config.Rename != nil {
+		numberBursts(config, allResults)
+		if config.OutputArchive != nil {
+			for i := range allResults {
+				allResults[i] = config.OutputArchive.addResult(allResults[i])
+			}
+		}
+	}
+
 	fmt.Printf("\nProcessing complete!\n\n")
 	return allResults
 }
 
-func worker(workerID int, config *Config, exifTool *ExifToolManager, jobs <-chan Job, results chan<- Result, wg *sync.WaitGroup) {
+func worker(config *Config, backend MetadataBackend, jobs <-chan Job, results chan<- Result, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	// Get dedicated ExifTool process for this worker
-	process := exifTool.GetProcessForWorker(workerID)
-
 	for job := range jobs {
-		result := processMediaFile(config, process, job.File)
-		results <- result
+		// Read the whole batch at once. If the backend fails on the batch,
+		// every file falls back to its own read so only the culprit is affected.
+		var batch map[string]*FileMetadata
+		if len(job.Files) > 1 {
+			paths := make([]string, len(job.Files))
+			for i, file := range job.Files {
+				paths[i] = file.Path
+			}
+			batch, _ = backend.ReadTags(paths)
+		}
+
+		for _, file := range job.Files {
+			var result Result
+			if metadata := batch[file.Path]; metadata != nil && metadata.Err == nil {
+				result = processMediaFileWithMetadata(config, backend, file, metadata)
+			} else {
+				result = processMediaFile(config, backend, file)
+			}
+
+			// ExifTool has already been restarted at this point; retry the file
+			// and quarantine it if it keeps crashing or hanging ExifTool
+			for attempt := 1; isExifToolFailure(result.Error) && attempt < exifToolMaxAttempts; attempt++ {
+				result = processMediaFile(config, backend, file)
+			}
+			if isExifToolFailure(result.Error) {
+				result = quarantineFile(config, result)
+			}
+
+			// A dry run leaves nothing behind in the staging directory
+			if file.Archive != nil && config.DryRun {
+				os.Remove(file.Path)
+			}
+
+			results <- result
+		}
 	}
 }
 
-func processMediaFile(config *Config, exifTool *ExifToolProcess, file MediaFile) Result {
-	result := Result{File: file}
+// isExifToolFailure reports whether err was caused by ExifTool crashing or hanging
+func isExifToolFailure(err error) bool {
+	return errors.Is(err, ErrExifToolTimeout) || errors.Is(err, ErrExifToolCrashed)
+}
 
+// quarantineFile marks a file that repeatedly crashed ExifTool as failed and,
+// when moving files, moves it aside into the QUARANTINE directory
+func quarantineFile(config *Config, result Result) Result {
+	result.Success = false
+	result.Quarantined = true
+	result.Error = fmt.Errorf("quarantined after %d ExifTool failures: %w", exifToolMaxAttempts, result.Error)
+
+	if config.Move == "" || config.DryRun {
+		return result
+	}
+
+	destPath := claimDestinationPath(filepath.Join(config.OutputDir, "QUARANTINE", result.File.BaseName))
+	if err := moveFile(result.File.Path, destPath); err != nil {
+		result.Error = fmt.Errorf("%v (failed to move to quarantine: %v)", result.Error, err)
+		return result
+	}
+	result.Action = fmt.Sprintf("Quarantined to: %s", destPath)
+	return result
+}
+
+func processMediaFile(config *Config, backend MetadataBackend, file MediaFile) Result {
 	// Extract existing EXIF metadata
-	exifData, err := exifTool.GetMetadata(file.Path)
+	batch, err := backend.ReadTags([]string{file.Path})
+	if err == nil && batch[file.Path] == nil {
+		err = errors.New("no metadata returned")
+	}
 	if err != nil {
-		result.Error = fmt.Errorf("failed to get EXIF data: %v", err)
-		return result
+		return Result{File: file, Error: fmt.Errorf("failed to get EXIF data: %w", err)}
+	}
+
+	metadata := batch[file.Path]
+	// The built-in reader can't parse every format. As far as it can tell such
+	// files have no embedded date, so they are dated from their sidecar.
+	if errors.Is(metadata.Err, errNativeUnsupported) {
+		metadata = &FileMetadata{Tags: map[string]string{}, Warnings: metadata.Warnings}
+	}
+	if metadata.Err != nil {
+		return Result{
+			File:     file,
+			Warnings: metadata.Warnings,
+			Error:    fmt.Errorf("failed to get EXIF data: %w", metadata.Err),
+		}
 	}
 
+	return processMediaFileWithMetadata(config, backend, file, metadata)
+}
+
+// processMediaFileWithMetadata processes a file whose metadata has already been read
+func processMediaFileWithMetadata(config *Config, backend MetadataBackend, file MediaFile, metadata *FileMetadata) Result {
+	result := Result{File: file}
+	result.Warnings = append(result.Warnings, metadata.Warnings...)
+	exifData := metadata.Tags
+
+	// Compare the extension with the actual content. ExifTool refuses to write
+	// files whose extension doesn't match, so when the extension is being fixed
+	// during a move the EXIF update is deferred until the file has its new name.
+	result.ExtensionMismatch = checkFileExtension(file.Path, exifData)
+	fixExtension := result.ExtensionMismatch != nil && config.FixExtensions && config.Move != ""
+
 	// Try to find a valid date from EXIF
 	var creationDate time.Time
 	var foundExifDate bool
+	var deferredExifUpdate bool
 
 	for _, tag := range exifDateTags {
 		if dateStr, exists := exifData[tag]; exists && dateStr != "" {
@@ -374,45 +794,123 @@ func processMediaFile(config *Config, exifTool *ExifToolProcess, file MediaFile)
 
 	// If no EXIF date found, check for JSON sidecar
 	if !foundExifDate {
-		if sidecarPath := findSidecarFile(file); sidecarPath != "" {
-			if date, err := parseSidecarDate(sidecarPath); err == nil {
-				creationDate = date
-
-				// Update EXIF tags with sidecar date (always do this when sidecar date found)
-				if err := updateExifDate(config, exifTool, file.Path, creationDate); err != nil {
-					result.Error = fmt.Errorf("failed to update EXIF date: %v", err)
-					return result
+		match, err := lookupSidecar(config, file)
+		if err != nil {
+			result.Error = err
+			return result
+		}
+		if match != nil {
+			sidecar := match.Sidecar
+			result.SidecarMatch = match
+			if sidecar.DateErr == nil {
+				creationDate = sidecar.Date
+
+				if fixExtension && !config.DryRun {
+					deferredExifUpdate = true
+				} else {
+					// Update EXIF tags with sidecar date (always do this when sidecar date found)
+					warnings, err := updateExifDate(config, backend, file.Path, creationDate)
+					result.Warnings = append(result.Warnings, warnings...)
+					if err != nil {
+						result.Error = fmt.Errorf("failed to update EXIF date: %w", err)
+						return result
+					}
+					result.Action = fmt.Sprintf("Updated EXIF from sidecar (%s)", match)
 				}
-				result.Action = "Updated EXIF from sidecar"
 			} else {
-				result.Error = fmt.Errorf("failed to parse sidecar date: %v", err)
+				result.Error = fmt.Errorf("%w: %v", ErrInvalidSidecarDate, sidecar.DateErr)
 				return result
 			}
 		} else {
-			result.Error = fmt.Errorf("no creation date found in EXIF or sidecar")
+			result.Error = ErrNoCreationDate
 			return result
 		}
 	}
 
 	// Move file if move path is specified and we have a valid date
 	if config.Move != "" && !creationDate.IsZero() {
-		destPath := generateDestinationPath(config.OutputDir, file.BaseName, creationDate)
+		destName := file.BaseName
+		if config.NFCNames {
+			destName = normalizeName(destName)
+		}
+		if fixExtension {
+			destName = correctedFileName(destName, result.ExtensionMismatch)
+		}
+		var destPath string
+		grouped := false
+		if config.EditedPolicy == editedGroup {
+			// Edited copies go next to their original, named after it
+			if groupedPath := groupedDestination(config, file, destName); groupedPath != "" {
+				destPath, destName, grouped = groupedPath, filepath.Base(groupedPath), true
+				result.GroupedWith = config.Edited[file.Path].Path
+			}
+		}
+		restoredName := ""
+		if config.RestoreNames && !grouped {
+			restoredName = restoreFileName(config, file, result.SidecarMatch, destName)
+		}
+		preservedName := ""
+		if !grouped {
+			name := destName
+			if restoredName != "" {
+				name = restoredName
+			}
+			if config.Rename != nil {
+				renamed, err := renamedFileName(config, file, result.SidecarMatch, exifData, name, creationDate)
+				if err != nil {
+					result.Error = fmt.Errorf("failed to rename: %v", err)
+					return result
+				}
+				preservedName, name = name, renamed
+			}
+			path, err := destinationPath(config, file, result.SidecarMatch, exifData, name, creationDate)
+			if err != nil {
+				result.Error = fmt.Errorf("failed to generate destination: %v", err)
+				return result
+			}
+			if config.Rename != nil {
+				result.RenameTarget = path
+			}
+			// Restored names, custom layouts and renames may collide with other
+			// files in the same folder. Staged files leave as they are archived,
+			// so a file of the same name could otherwise replace one not archived yet.
+			if restoredName != "" || config.Layout != nil || config.Rename != nil || config.OutputArchive != nil {
+				path = claimDestinationPath(path)
+			}
+			destPath, destName = path, filepath.Base(path)
+		}
+		result.DestPath = destPath
+		result.Albums = albumsFor(config, file)
 
 		if config.DryRun {
+			if fixExtension {
+				result.Action += fmt.Sprintf(" | Would fix extension: %s", destName)
+			}
+			if restoredName != "" && preservedName == "" {
+				result.Action += fmt.Sprintf(" | Would restore name: %s", destName)
+			}
+			if preservedName != "" {
+				result.Action += fmt.Sprintf(" | Would rename: %s", destName)
+			}
+			if grouped {
+				result.Action += " | Would group with original"
+			}
 			result.Action += fmt.Sprintf(" | Would move to: %s", destPath)
 
-			// Check if album symlink would be created in dry run
-			if albumName := getAlbumName(file.Dir); albumName != "" {
-				symlinkPath := generateAlbumSymlinkPath(config.OutputDir, albumName, file.BaseName)
-				result.Action += fmt.Sprintf(" | Would create album symlink: %s", symlinkPath)
+			// Check if album entries would be created in dry run
+			if config.Albums.Link != "" {
+				for _, album := range result.Albums {
+					entryPath := claimAlbumEntryPath(generateAlbumSymlinkPath(config.OutputDir, album.Folder, destName), destPath)
+					result.Action += fmt.Sprintf(" | Would create album %s: %s", albumLinkDescription(config.Albums.Link), entryPath)
+				}
 			}
 		} else {
-			// Check if we need to create a symlink before moving the file
-			var symlinkPath string
-			var needsSymlink bool
-			if albumName := getAlbumName(file.Dir); albumName != "" {
-				symlinkPath = generateAlbumSymlinkPath(config.OutputDir, albumName, file.BaseName)
-				needsSymlink = true
+			// Check if we need to create album entries before moving the file
+			var entryPaths []string
+			if config.Albums.Link != "" {
+				for _, album := range result.Albums {
+					entryPaths = append(entryPaths, claimAlbumEntryPath(generateAlbumSymlinkPath(config.OutputDir, album.Folder, destName), destPath))
+				}
 			}
 
 			// Move the file first
@@ -420,21 +918,60 @@ func processMediaFile(config *Config, exifTool *ExifToolProcess, file MediaFile)
 				result.Error = fmt.Errorf("failed to move file: %v", err)
 				return result
 			}
+			if fixExtension {
+				result.Action += fmt.Sprintf(" | Fixed extension: %s", destName)
+			}
+			if restoredName != "" && preservedName == "" {
+				result.Action += fmt.Sprintf(" | Restored name: %s", destName)
+			}
+			if preservedName != "" {
+				result.Action += fmt.Sprintf(" | Renamed: %s", destName)
+			}
+			if grouped {
+				result.Action += " | Grouped with original"
+			}
 			result.Action += fmt.Sprintf(" | Moved to: %s", destPath)
 
-			// If symlink is needed, try to create it
-			if needsSymlink {
-				if err := createAlbumSymlink(destPath, symlinkPath); err != nil {
-					// Symlink creation failed - move file back to original location
+			// Apply the sidecar date now that the extension matches the content
+			if deferredExifUpdate {
+				warnings, err := updateExifDate(config, backend, destPath, creationDate)
+				result.Warnings = append(result.Warnings, warnings...)
+				if err != nil {
 					if rollbackErr := moveFile(destPath, file.Path); rollbackErr != nil {
-						result.Error = fmt.Errorf("failed to create symlink (%v) and failed to rollback file move (%v)", err, rollbackErr)
+						result.Error = fmt.Errorf("failed to update EXIF date (%v) and failed to rollback file move (%v)", err, rollbackErr)
 					} else {
-						result.Error = fmt.Errorf("failed to create symlink, file moved back to original location: %v", err)
+						result.Error = fmt.Errorf("failed to update EXIF date, file moved back to original location: %v", err)
+					}
+					return result
+				}
+				result.Action = fmt.Sprintf("Updated EXIF from sidecar (%s)", result.SidecarMatch) + result.Action
+			}
+
+			// Keep the name the file had before renaming, so it can be recovered,
+			// and add its albums to the keywords and description
+			if xmpTags := fileXMPTags(config, preservedName, result.Albums); len(xmpTags) > 0 {
+				result.Warnings = append(result.Warnings, writeXMPTags(backend, destPath, xmpTags)...)
+			}
+
+			// Create the album entries, if any
+			description := albumLinkDescription(config.Albums.Link)
+			for _, entryPath := range entryPaths {
+				if err := createAlbumEntry(config.Albums.Link, destPath, entryPath); err != nil {
+					// Album entry creation failed - remove the entries created so far
+					// and move file back to original location
+					for _, created := range result.AlbumLinks {
+						os.Remove(created)
+					}
+					result.AlbumLinks = nil
+					if rollbackErr := moveFile(destPath, file.Path); rollbackErr != nil {
+						result.Error = fmt.Errorf("failed to create album %s (%v) and failed to rollback file move (%v)", description, err, rollbackErr)
+					} else {
+						result.Error = fmt.Errorf("failed to create album %s, file moved back to original location: %v", description, err)
 					}
 					return result
-				} else {
-					result.Action += fmt.Sprintf(" | Album symlink created: %s", symlinkPath)
 				}
+				result.Action += fmt.Sprintf(" | Album %s created: %s", description, entryPath)
+				result.AlbumLinks = append(result.AlbumLinks, entryPath)
 			}
 		}
 	}
@@ -453,276 +990,6 @@ func processMediaFile(config *Config, exifTool *ExifToolProcess, file MediaFile)
 	return result
 }
 
-func findSidecarFile(file MediaFile) string {
-	baseName := file.BaseName
-	baseNameNoExt := strings.TrimSuffix(baseName, filepath.Ext(baseName))
-	baseForSidecarNoUnderscore := baseNameNoExt + filepath.Ext(baseName)
-
-	// Track if we had to strip -edited suffix for fallback
-	hadEditedSuffix := false
-	editedRegex := regexp.MustCompile(`^(.+)-edited(\([0-9]+\))?$`)
-	if matches := editedRegex.FindStringSubmatch(baseNameNoExt); len(matches) >= 2 {
-		baseNameNoExt = matches[1]
-		if len(matches) > 2 && matches[2] != "" {
-			// Preserve the parenthetical suffix
-			baseNameNoExt += matches[2]
-		}
-		hadEditedSuffix = true
-	}
-
-	// --- 1. Try literal/whole-base-name matching first (including any parentheses) ---
-	baseForLiteral := baseName
-	baseForLiteralNoUnderscore := baseForSidecarNoUnderscore
-
-	// Handle trailing underscore removal edge case for literal
-	baseNameLiteralNoUnderscoreNoExt := ""
-	if strings.HasSuffix(strings.TrimSuffix(baseForLiteral, filepath.Ext(baseForLiteral)), "_") {
-		ext := filepath.Ext(baseForLiteral)
-		nameWithoutExt := strings.TrimSuffix(baseForLiteral, ext)
-		baseForLiteralNoUnderscore = strings.TrimSuffix(nameWithoutExt, "_") + ext
-		baseNameLiteralNoUnderscoreNoExt = strings.TrimSuffix(nameWithoutExt, "_")
-	}
-
-	entries, err := os.ReadDir(file.Dir)
-	if err != nil {
-		return ""
-	}
-	escapedLiteral := regexp.QuoteMeta(baseForLiteral)
-	escapedLiteralNoUnderscore := regexp.QuoteMeta(baseForLiteralNoUnderscore)
-	escapedLiteralNoUnderscoreNoExt := regexp.QuoteMeta(baseNameLiteralNoUnderscoreNoExt)
-	literalPatterns := []string{
-		fmt.Sprintf(`^%s(?:\..*)?\.json$`, escapedLiteral),
-	}
-	// Also include underscore-removal patterns for literal if applicable
-	if baseForLiteralNoUnderscore != baseForLiteral {
-		literalPatterns = append(literalPatterns, fmt.Sprintf(`^%s(?:\..*)?\.json$`, escapedLiteralNoUnderscore))
-		literalPatterns = append(literalPatterns, fmt.Sprintf(`^%s\.json$`, escapedLiteralNoUnderscoreNoExt))
-	}
-	// Double-dot pattern (rare, but consistent with base name handling)
-	baseLiteralNoExt := strings.TrimSuffix(baseForLiteral, filepath.Ext(baseForLiteral))
-	escapedLiteralNoExt := regexp.QuoteMeta(baseLiteralNoExt)
-	literalPatterns = append(literalPatterns, fmt.Sprintf(`^%s\.\.json$`, escapedLiteralNoExt))
-
-	for _, pattern := range literalPatterns {
-		sidecarRegex, err := regexp.Compile(pattern)
-		if err != nil {
-			continue
-		}
-		for _, entry := range entries {
-			if !entry.IsDir() && sidecarRegex.MatchString(entry.Name()) {
-				sidecarPath := filepath.Join(file.Dir, entry.Name())
-				if isGooglePhotosSidecar(sidecarPath) {
-					return sidecarPath
-				}
-			}
-		}
-	}
-
-	// Handle files with (number) suffix
-	numberSuffixRegex := regexp.MustCompile(`^(.+)\((\d+)\)$`)
-	var baseForSidecar string
-	var numberSuffix string
-
-	if matches := numberSuffixRegex.FindStringSubmatch(baseNameNoExt); len(matches) == 3 {
-		baseForSidecar = matches[1] + filepath.Ext(baseName) // e.g., "IMG_456.jpg" from "IMG_456(1).jpg"
-		numberSuffix = `\(` + matches[2] + `\)`              // e.g., "\(1\)" for regex
-	} else {
-		baseForSidecar = baseNameNoExt + filepath.Ext(baseName)
-		numberSuffix = ""
-	}
-
-	// Handle trailing underscore removal edge case
-	// --- 2. Fall back to existing base filename logic with suffix-number handling ---
-
-	// Handle trailing underscore removal edge case
-	baseForSidecarNoUnderscore = baseNameNoExt + filepath.Ext(baseName)
-	baseNameNoUnderscoreNoExt := ""
-	if strings.HasSuffix(strings.TrimSuffix(baseForSidecar, filepath.Ext(baseForSidecar)), "_") {
-		ext := filepath.Ext(baseForSidecar)
-		nameWithoutExt := strings.TrimSuffix(baseForSidecar, ext)
-		baseForSidecarNoUnderscore = strings.TrimSuffix(nameWithoutExt, "_") + ext
-		baseNameNoUnderscoreNoExt = strings.TrimSuffix(nameWithoutExt, "_")
-	}
-
-	escapedBase := regexp.QuoteMeta(baseForSidecar)
-	escapedBaseNoUnderscore := regexp.QuoteMeta(baseForSidecarNoUnderscore)
-	escapedBaseNoUnderscoreNoExt := regexp.QuoteMeta(baseNameNoUnderscoreNoExt)
-
-	var patterns []string
-	if numberSuffix != "" {
-		patterns = append(patterns, fmt.Sprintf(`^%s(?:\..*)?%s\.json$`, escapedBase, numberSuffix))
-		if baseForSidecarNoUnderscore != baseForSidecar {
-			patterns = append(patterns, fmt.Sprintf(`^%s(?:\..*)?%s\.json$`, escapedBaseNoUnderscore, numberSuffix))
-			patterns = append(patterns, fmt.Sprintf(`^%s%s\.json$`, escapedBaseNoUnderscoreNoExt, numberSuffix))
-		}
-		baseNameNoExt := strings.TrimSuffix(baseForSidecar, filepath.Ext(baseForSidecar))
-		escapedBaseNoExt := regexp.QuoteMeta(baseNameNoExt)
-		patterns = append(patterns, fmt.Sprintf(`^%s\.%s\.json$`, escapedBaseNoExt, numberSuffix))
-	} else {
-		patterns = append(patterns, fmt.Sprintf(`^%s(?:\..*)?\.json$`, escapedBase))
-		if baseForSidecarNoUnderscore != baseForSidecar {
-			patterns = append(patterns, fmt.Sprintf(`^%s(?:\..*)?\.json$`, escapedBaseNoUnderscore))
-			patterns = append(patterns, fmt.Sprintf(`^%s\.json$`, escapedBaseNoUnderscoreNoExt))
-		}
-		baseNameNoExt := strings.TrimSuffix(baseForSidecar, filepath.Ext(baseForSidecar))
-		escapedBaseNoExt := regexp.QuoteMeta(baseNameNoExt)
-		patterns = append(patterns, fmt.Sprintf(`^%s\.\.json$`, escapedBaseNoExt))
-	}
-
-	for _, pattern := range patterns {
-		sidecarRegex, err := regexp.Compile(pattern)
-		if err != nil {
-			continue
-		}
-		for _, entry := range entries {
-			if !entry.IsDir() && sidecarRegex.MatchString(entry.Name()) {
-				sidecarPath := filepath.Join(file.Dir, entry.Name())
-				if isGooglePhotosSidecar(sidecarPath) {
-					return sidecarPath
-				}
-			}
-		}
-	}
-
-	// If no exact patterns match, try progressive prefix matching for arbitrary truncation
-	sidecar := findSidecarWithPrefixMatching(file, entries)
-	if sidecar != "" {
-		return sidecar
-	}
-
-	// --- Additional fallback: try matching where file extension is truncated before '.json' ---
-	ext := filepath.Ext(baseForSidecar)
-	base := strings.TrimSuffix(baseForSidecar, ext)
-	if len(ext) > 1 { // ".j" or longer
-		for i := 2; i <= len(ext); i++ {
-			trunc := ext[:i]
-			truncName := base + trunc
-			for _, entry := range entries {
-				if !entry.IsDir() && strings.HasPrefix(entry.Name(), truncName) && strings.HasSuffix(entry.Name(), ".json") {
-					sidecarPath := filepath.Join(file.Dir, entry.Name())
-					if isGooglePhotosSidecar(sidecarPath) {
-						return sidecarPath
-					}
-				}
-			}
-		}
-	}
-
-	// --- Enhanced fallback for -edited suffix: retry all matching logic with -edited removed ---
-	if hadEditedSuffix {
-		// Create a temporary MediaFile struct with the -edited suffix removed
-		tempBaseName := baseNameNoExt + filepath.Ext(baseName)
-		tempFile := MediaFile{
-			Path:     file.Path,
-			BaseName: tempBaseName,
-			Dir:      file.Dir,
-		}
-
-		// Recursively call findSidecarFile with the modified filename
-		// but prevent infinite recursion by ensuring we don't have -edited suffix
-		if !strings.Contains(tempFile.BaseName, "-edited") {
-			return findSidecarFile(tempFile)
-		}
-	}
-
-	return ""
-}
-
-func findSidecarWithPrefixMatching(file MediaFile, entries []os.DirEntry) string {
-	baseName := file.BaseName
-	baseNameNoExt := strings.TrimSuffix(baseName, filepath.Ext(baseName))
-
-	// Handle numbered files
-	numberSuffixRegex := regexp.MustCompile(`^(.+)\((\d+)\)$`)
-	var baseForMatching string
-	var numberSuffix string
-
-	if matches := numberSuffixRegex.FindStringSubmatch(baseNameNoExt); len(matches) == 3 {
-		baseForMatching = matches[1]
-		numberSuffix = "(" + matches[2] + ")"
-	} else {
-		baseForMatching = baseNameNoExt
-		numberSuffix = ""
-	}
-
-	// Try progressively shorter prefixes (minimum 10 characters to avoid false positives)
-	minPrefixLength := 10
-	if len(baseForMatching) < minPrefixLength {
-		minPrefixLength = len(baseForMatching)
-	}
-
-	for prefixLen := len(baseForMatching); prefixLen >= minPrefixLength; prefixLen-- {
-		prefix := baseForMatching[:prefixLen]
-		escapedPrefix := regexp.QuoteMeta(prefix)
-
-		var candidatePattern string
-		if numberSuffix != "" {
-			escapedNumberSuffix := regexp.QuoteMeta(numberSuffix)
-			candidatePattern = fmt.Sprintf(`^%s.*%s\.json$`, escapedPrefix, escapedNumberSuffix)
-		} else {
-			candidatePattern = fmt.Sprintf(`^%s.*\.json$`, escapedPrefix)
-		}
-
-		regex, err := regexp.Compile(candidatePattern)
-		if err != nil {
-			continue
-		}
-
-		for _, entry := range entries {
-			if !entry.IsDir() && regex.MatchString(entry.Name()) {
-				sidecarPath := filepath.Join(file.Dir, entry.Name())
-				// Additional validation: check content and ensure it's not too different in length
-				if isGooglePhotosSidecar(sidecarPath) {
-					sidecarName := strings.TrimSuffix(entry.Name(), ".json")
-					// Remove number suffix from sidecar name for length comparison
-					if numberSuffix != "" {
-						sidecarName = strings.TrimSuffix(sidecarName, numberSuffix)
-					}
-					// Allow up to 30% length difference to handle arbitrary truncation
-					maxLenDiff := len(baseForMatching) / 3
-					if len(baseForMatching)-len(sidecarName) <= maxLenDiff && len(baseForMatching)-len(sidecarName) >= 0 {
-						return sidecarPath
-					}
-				}
-			}
-		}
-	}
-
-	return ""
-}
-
-func isGooglePhotosSidecar(filePath string) bool {
-	data, err := os.ReadFile(filePath)
-	if err != nil {
-		return false
-	}
-
-	// Quick check for Google Photos sidecar structure
-	content := string(data)
-	return strings.Contains(content, "photoTakenTime") &&
-		strings.Contains(content, "timestamp") &&
-		(strings.Contains(content, "title") || len(content) < 1000) // Basic validation
-}
-
-func parseSidecarDate(sidecarPath string) (time.Time, error) {
-	data, err := os.ReadFile(sidecarPath)
-	if err != nil {
-		return time.Time{}, err
-	}
-
-	var sidecar SidecarData
-	if err := json.Unmarshal(data, &sidecar); err != nil {
-		return time.Time{}, err
-	}
-
-	timestamp, err := strconv.ParseInt(sidecar.PhotoTakenTime.Timestamp, 10, 64)
-	if err != nil {
-		return time.Time{}, err
-	}
-
-	return time.Unix(timestamp, 0), nil
-}
-
 func parseExifDate(dateStr string) (time.Time, error) {
 	// Common EXIF date formats
 	formats := []string{
@@ -741,13 +1008,29 @@ func parseExifDate(dateStr string) (time.Time, error) {
 	return time.Time{}, fmt.Errorf("unable to parse date: %s", dateStr)
 }
 
-func updateExifDate(config *Config, exifTool *ExifToolProcess, filePath string, date time.Time) error {
+func updateExifDate(config *Config, backend MetadataBackend, filePath string, date time.Time) ([]ExifToolWarning, error) {
 	if config.DryRun {
-		return nil
+		return nil, nil
 	}
 
-	dateStr := date.Format("2006:01:02 15:04:05")
-	return exifTool.UpdateAllDates(filePath, dateStr)
+	dateStr := date.Format(exifDateFormat)
+	return backend.WriteTags(filePath, map[string]string{"AllDates": dateStr})
+}
+
+// restoreFileName returns the original name for a file Takeout truncated,
+// keeping the extension of destName, or "" if the name wasn't truncated. Files
+// dated from EXIF have their sidecar looked up only for its title.
+func restoreFileName(config *Config, file MediaFile, match *SidecarMatch, destName string) string {
+	if match == nil {
+		match, _ = lookupSidecar(config, file)
+	}
+	if match == nil || match.Sidecar.Title == "" {
+		return ""
+	}
+	if name := restoredFileName(destName, match.Sidecar.Title); name != destName {
+		return name
+	}
+	return ""
 }
 
 func generateDestinationPath(outputDir, fileName string, date time.Time) string {
@@ -763,19 +1046,10 @@ func generateAlbumSymlinkPath(outputDir, albumName, fileName string) string {
 }
 
 func getAlbumName(dir string) string {
-	metadataPath := filepath.Join(dir, "metadata.json")
-
-	data, err := os.ReadFile(metadataPath)
-	if err != nil {
-		return ""
-	}
-
-	var metadata AlbumMetadata
-	if err := json.Unmarshal(data, &metadata); err != nil {
-		return ""
+	if metadata := readAlbumMetadata(dir); metadata != nil {
+		return metadata.Title
 	}
-
-	return metadata.Title
+	return ""
 }
 
 func createAlbumSymlink(targetPath, symlinkPath string) error {
@@ -791,11 +1065,9 @@ func createAlbumSymlink(targetPath, symlinkPath string) error {
 		return fmt.Errorf("failed to calculate relative path: %v", err)
 	}
 
-	// Remove existing symlink if it exists
-	if _, err := os.Lstat(symlinkPath); err == nil {
-		if err := os.Remove(symlinkPath); err != nil {
-			return fmt.Errorf("failed to remove existing symlink: %v", err)
-		}
+	// Replace an existing symlink to the same file, never one to another file
+	if err := removeAlbumEntryFor(symlinkPath, targetPath); err != nil {
+		return err
 	}
 
 	// Create the symlink
@@ -820,6 +1092,9 @@ func moveFile(srcPath, destPath string) error {
 func printSummary(results []Result) {
 	successful := 0
 	failed := 0
+	mismatches := 0
+	quarantined := 0
+	warned := 0
 	actions := make(map[string]int)
 
 	for _, result := range results {
@@ -830,12 +1105,28 @@ func printSummary(results []Result) {
 			failed++
 			fmt.Printf("ERROR: %s - %v\n", result.File.Path, result.Error)
 		}
+		if result.Quarantined {
+			quarantined++
+		}
+		if len(result.Warnings) > 0 {
+			warned++
+			for _, warning := range result.Warnings {
+				fmt.Printf("WARNING: %s - %s\n", result.File.Path, warning)
+			}
+		}
+		if result.ExtensionMismatch != nil {
+			mismatches++
+			fmt.Printf("MISMATCH: %s - %s\n", result.File.Path, result.ExtensionMismatch)
+		}
 	}
 
 	fmt.Printf("=== SUMMARY ===\n")
 	fmt.Printf("Total files processed: %d\n", len(results))
 	fmt.Printf("Successful: %d\n", successful)
-	fmt.Printf("Failed: %d\n\n", failed)
+	fmt.Printf("Failed: %d\n", failed)
+	fmt.Printf("Quarantined: %d\n", quarantined)
+	fmt.Printf("Files with ExifTool warnings: %d\n", warned)
+	fmt.Printf("Extension mismatches: %d\n\n", mismatches)
 
 	if len(actions) > 0 {
 		fmt.Printf("Actions taken:\n")
@@ -847,9 +1138,13 @@ func printSummary(results []Result) {
 
 // NewExifToolManager creates a new ExifTool manager with one process per worker
 func NewExifToolManager(workerCount int) (*ExifToolManager, error) {
-	// Check if exiftool is available
-	if _, err := exec.LookPath("exiftool"); err != nil {
-		return nil, fmt.Errorf("exiftool not found in PATH: %v", err)
+	// Check that exiftool is available and recent enough
+	version, err := probeExifToolVersion()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkExifToolVersion(version); err != nil {
+		return nil, err
 	}
 
 	processes := make([]*ExifToolProcess, workerCount)
@@ -862,44 +1157,281 @@ func NewExifToolManager(workerCount int) (*ExifToolManager, error) {
 			}
 			return nil, fmt.Errorf("failed to start ExifTool process %d: %v", i, err)
 		}
+		process.version = version
 		processes[i] = process
 	}
 
 	return &ExifToolManager{
 		processes: processes,
+		version:   version,
 	}, nil
 }
 
 // startExifToolProcess starts a single ExifTool process in persistent mode
 func startExifToolProcess() (*ExifToolProcess, error) {
+	etp := &ExifToolProcess{timeout: defaultExifToolTimeout}
+	if err := etp.start(); err != nil {
+		return nil, err
+	}
+	return etp, nil
+}
+
+// start launches the underlying ExifTool command and the goroutine that
+// forwards its output lines. The caller must hold etp.mu or own etp exclusively.
+func (etp *ExifToolProcess) start() error {
 	// Start ExifTool in persistent mode with -stay_open
-	cmd := exec.Command("exiftool", "-stay_open", "True", "-@", "-")
+	cmd := newExifToolCmd("-stay_open", "True", "-@", "-")
 
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create stdin pipe: %v", err)
+		return fmt.Errorf("failed to create stdin pipe: %v", err)
 	}
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		stdin.Close()
-		return nil, fmt.Errorf("failed to create stdout pipe: %v", err)
+		return fmt.Errorf("failed to create stdout pipe: %v", err)
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		stdin.Close()
+		stdout.Close()
+		return fmt.Errorf("failed to create stderr pipe: %v", err)
 	}
 
 	if err := cmd.Start(); err != nil {
 		stdin.Close()
 		stdout.Close()
-		return nil, fmt.Errorf("failed to start exiftool: %v", err)
+		stderr.Close()
+		return fmt.Errorf("failed to start exiftool: %v", err)
+	}
+
+	// Read stdout and stderr in the background so that a hung ExifTool can be
+	// detected with a timeout instead of blocking forever in Scan()
+	done := make(chan struct{})
+	etp.cmd = cmd
+	etp.stdin = stdin
+	etp.stdout = stdout
+	etp.stderr = stderr
+	etp.lines = forwardLines(stdout, done)
+	etp.errLines = forwardLines(stderr, done)
+	etp.done = done
+	return nil
+}
+
+// forwardLines sends each line read from r to the returned channel until r is
+// exhausted or done is closed
+func forwardLines(r io.Reader, done <-chan struct{}) <-chan string {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-done:
+				return
+			}
+		}
+	}()
+	return lines
+}
+
+// stop shuts down the underlying ExifTool command. When graceful is false the
+// process is killed immediately. The caller must hold etp.mu.
+func (etp *ExifToolProcess) stop(graceful bool) error {
+	if etp.cmd == nil {
+		return nil
 	}
 
-	scanner := bufio.NewScanner(stdout)
+	// Send -stay_open False to gracefully terminate ExifTool
+	if graceful {
+		etp.stdin.Write([]byte("-stay_open\nFalse\n"))
+	}
+	etp.stdin.Close()
+	if !graceful {
+		etp.cmd.Process.Kill()
+	}
+	close(etp.done)
+	etp.stdout.Close()
+	etp.stderr.Close()
 
-	return &ExifToolProcess{
-		cmd:     cmd,
-		stdin:   stdin,
-		stdout:  stdout,
-		scanner: scanner,
-	}, nil
+	cmd := etp.cmd
+	etp.cmd = nil
+
+	// Wait for the process to exit gracefully, or kill it after a timeout
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		if !graceful {
+			return nil
+		}
+		return err
+	case <-time.After(5 * time.Second):
+		// Force kill if it doesn't exit gracefully
+		return cmd.Process.Kill()
+	}
+}
+
+// restart kills the current ExifTool command and starts a fresh one so that
+// later jobs for this worker are not affected by a crashed or hung process.
+// The caller must hold etp.mu.
+func (etp *ExifToolProcess) restart() error {
+	etp.stop(false)
+	return etp.start()
+}
+
+// FileMetadata holds the tags ExifTool read for a single file
+type FileMetadata struct {
+	Tags     map[string]string
+	Warnings []ExifToolWarning
+	Err      error
+}
+
+// exifToolResponse holds the output ExifTool produced for a single command
+type exifToolResponse struct {
+	Stdout   []string
+	Warnings []ExifToolWarning
+}
+
+// execute sends a command to the persistent ExifTool process and collects its
+// stdout and stderr. Each command is numbered with -execute<N> and terminated
+// on stderr with -echo4 so that output can't be attributed to the wrong
+// request. If ExifTool exits or does not respond within the configured
+// timeout it is restarted and ErrExifToolCrashed or ErrExifToolTimeout is
+// returned. The caller must hold etp.mu.
+func (etp *ExifToolProcess) execute(args ...string) (*exifToolResponse, error) {
+	return etp.run(etp.timeout, args...)
+}
+
+// cstrEscaper escapes a value for a #[CSTR] argfile line
+var cstrEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`)
+
+// argfileLine returns arg as a line of the -@ argfile ExifTool reads from
+// stdin, where every line is an argument. Arguments with line breaks, such as
+// multi-line album descriptions, are written as C strings so they can't add
+// arguments of their own.
+func argfileLine(arg string) string {
+	if !strings.ContainsAny(arg, "\r\n") {
+		return arg
+	}
+	return "#[CSTR]" + cstrEscaper.Replace(arg)
+}
+
+// run is execute with an explicit timeout
+func (etp *ExifToolProcess) run(timeout time.Duration, args ...string) (*exifToolResponse, error) {
+	// A previous restart may have failed; try again before giving up on this worker
+	if etp.cmd == nil {
+		if err := etp.start(); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrExifToolCrashed, err)
+		}
+	}
+
+	etp.seq++
+	ready := fmt.Sprintf("{ready%d}", etp.seq)
+	argLines := make([]string, len(args))
+	for i, arg := range args {
+		argLines[i] = argfileLine(arg)
+	}
+	command := strings.Join(argLines, "\n") + fmt.Sprintf("\n-echo4\n%s\n-execute%d\n", ready, etp.seq)
+
+	if _, err := etp.stdin.Write([]byte(command)); err != nil {
+		etp.restart()
+		return nil, fmt.Errorf("%w: failed to write to exiftool stdin: %v", ErrExifToolCrashed, err)
+	}
+
+	var timedOut <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timedOut = timer.C
+	}
+
+	// Both streams end with the numbered ready marker; read until both have been seen
+	response := &exifToolResponse{}
+	lines, errLines := etp.lines, etp.errLines
+	for lines != nil || errLines != nil {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				etp.restart()
+				return nil, ErrExifToolCrashed
+			}
+			if line == ready {
+				lines = nil
+				continue
+			}
+			response.Stdout = append(response.Stdout, line)
+		case line, ok := <-errLines:
+			if !ok {
+				etp.restart()
+				return nil, ErrExifToolCrashed
+			}
+			if line == ready {
+				errLines = nil
+				continue
+			}
+			if warning, ok := parseExifToolMessage(line); ok {
+				response.Warnings = append(response.Warnings, warning)
+			}
+		case <-timedOut:
+			etp.restart()
+			return nil, fmt.Errorf("%w after %v", ErrExifToolTimeout, timeout)
+		}
+	}
+
+	return response, nil
+}
+
+// parseExifToolMessage parses a stderr line such as
+// "Warning: [minor] Ignored empty rational value - photo.jpg"
+func parseExifToolMessage(line string) (ExifToolWarning, bool) {
+	line = strings.TrimSpace(line)
+	for _, level := range []string{"Error", "Warning"} {
+		if message, ok := strings.CutPrefix(line, level+":"); ok {
+			return ExifToolWarning{Level: level, Message: strings.TrimSpace(message)}, true
+		}
+	}
+	if line == "" {
+		return ExifToolWarning{}, false
+	}
+	return ExifToolWarning{Level: "Warning", Message: line}, true
+}
+
+// SetTimeout sets the per-command timeout of every process. A zero duration
+// disables the watchdog.
+func (etm *ExifToolManager) SetTimeout(timeout time.Duration) {
+	for _, process := range etm.processes {
+		process.mu.Lock()
+		process.timeout = timeout
+		process.mu.Unlock()
+	}
+}
+
+// Version returns the version of the ExifTool used by the manager
+func (etm *ExifToolManager) Version() float64 {
+	return etm.version
+}
+
+// Backend returns the metadata backend for a worker: the built-in reader
+// backed by the worker's dedicated ExifTool process
+func (etm *ExifToolManager) Backend(workerID int) MetadataBackend {
+	return newFastPathBackend(etm.GetProcessForWorker(workerID))
+}
+
+// Backends returns one metadata backend per worker
+func (etm *ExifToolManager) Backends() []MetadataBackend {
+	backends := make([]MetadataBackend, len(etm.processes))
+	for i := range backends {
+		backends[i] = etm.Backend(i)
+	}
+	return backends
 }
 
 // GetProcessForWorker returns the ExifTool process assigned to a specific worker
@@ -909,83 +1441,203 @@ func (etm *ExifToolManager) GetProcessForWorker(workerID int) *ExifToolProcess {
 
 // GetMetadata extracts metadata from a file using ExifTool
 func (etp *ExifToolProcess) GetMetadata(filePath string) (map[string]string, error) {
+	metadata, _, err := etp.ReadMetadata(filePath)
+	return metadata, err
+}
+
+// ReadMetadata extracts metadata from a file using ExifTool, along with any
+// warnings ExifTool reported while reading it
+func (etp *ExifToolProcess) ReadMetadata(filePath string) (map[string]string, []ExifToolWarning, error) {
 	etp.mu.Lock()
 	defer etp.mu.Unlock()
 
 	// Send command to persistent ExifTool process
-	command := fmt.Sprintf("-json\n-dateFormat\n%%Y:%%m:%%d %%H:%%M:%%S\n%s\n-execute\n", filePath)
+	response, err := etp.execute("-json", "-dateFormat", "%Y:%m:%d %H:%M:%S", filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	warnings := response.Warnings
 
-	if _, err := etp.stdin.Write([]byte(command)); err != nil {
-		return nil, fmt.Errorf("failed to write to exiftool stdin: %v", err)
+	outputStr := strings.TrimSpace(strings.Join(response.Stdout, "\n"))
+	if outputStr == "" {
+		if err := firstExifToolError(warnings); err != nil {
+			return nil, warnings, err
+		}
+		return make(map[string]string), warnings, nil
+	}
+
+	var exifData []map[string]interface{}
+	if err := json.Unmarshal([]byte(outputStr), &exifData); err != nil {
+		return nil, warnings, fmt.Errorf("failed to parse exiftool JSON: %v", err)
+	}
+
+	if len(exifData) == 0 {
+		return make(map[string]string), warnings, nil
 	}
 
-	// Read response until we see {ready} marker
-	var output strings.Builder
-	for etp.scanner.Scan() {
-		line := etp.scanner.Text()
-		if line == "{ready}" {
-			break
+	result := stringifyTags(exifData[0])
+
+	// Problems found while reading are reported as tags in the JSON output
+	for _, level := range []string{"Error", "Warning"} {
+		if message := result[level]; message != "" {
+			warnings = append(warnings, ExifToolWarning{Level: level, Message: message})
 		}
-		output.WriteString(line)
-		output.WriteString("\n")
 	}
 
-	if err := etp.scanner.Err(); err != nil {
-		return nil, fmt.Errorf("failed to read exiftool output: %v", err)
+	return result, warnings, nil
+}
+
+// batchTimeouts is the number of watchdog timeouts a batch read may take.
+// Batch reads skip everything but the tags used for processing, so a healthy
+// batch takes a fraction of one timeout.
+const batchTimeouts = 2
+
+// ReadMetadataBatch reads the tags used for processing from several files with
+// a single ExifTool command. Results are keyed by the paths passed in; a file
+// ExifTool could not read has its Err set.
+func (etp *ExifToolProcess) ReadMetadataBatch(paths []string) (map[string]*FileMetadata, error) {
+	etp.mu.Lock()
+	defer etp.mu.Unlock()
+
+	args := []string{"-json", "-fast2", "-dateFormat", "%Y:%m:%d %H:%M:%S"}
+	for _, tag := range batchReadTags() {
+		args = append(args, "-"+tag)
 	}
+	args = append(args, paths...)
 
-	outputStr := strings.TrimSpace(output.String())
-	if outputStr == "" {
-		return make(map[string]string), nil
+	// A batch gets at most batchTimeouts watchdog timeouts, so a file that
+	// hangs ExifTool is soon found by the per-file fallback instead of blocking
+	// the worker for the timeout of every file in the batch
+	response, err := etp.run(etp.timeout*time.Duration(min(len(paths), batchTimeouts)), args...)
+	if err != nil {
+		return nil, err
 	}
 
 	var exifData []map[string]interface{}
-	if err := json.Unmarshal([]byte(outputStr), &exifData); err != nil {
-		return nil, fmt.Errorf("failed to parse exiftool JSON: %v", err)
+	if output := strings.TrimSpace(strings.Join(response.Stdout, "\n")); output != "" {
+		if err := json.Unmarshal([]byte(output), &exifData); err != nil {
+			return nil, fmt.Errorf("failed to parse exiftool JSON: %v", err)
+		}
 	}
 
-	if len(exifData) == 0 {
-		return make(map[string]string), nil
+	// ExifTool reports SourceFile with forward slashes on every platform
+	byPath := make(map[string]*FileMetadata, len(paths))
+	bySourceFile := make(map[string]*FileMetadata, len(paths))
+	for _, path := range paths {
+		metadata := &FileMetadata{}
+		byPath[path] = metadata
+		bySourceFile[filepath.ToSlash(path)] = metadata
+	}
+
+	for _, entry := range exifData {
+		sourceFile, _ := entry["SourceFile"].(string)
+		metadata := bySourceFile[filepath.ToSlash(sourceFile)]
+		if metadata == nil {
+			continue
+		}
+		metadata.Tags = stringifyTags(entry)
+		for _, level := range []string{"Error", "Warning"} {
+			if message := metadata.Tags[level]; message != "" {
+				metadata.Warnings = append(metadata.Warnings, ExifToolWarning{Level: level, Message: message})
+			}
+		}
+	}
+
+	// Messages on stderr end with " - <file>", which routes them to their file
+	for _, warning := range response.Warnings {
+		for sourceFile, metadata := range bySourceFile {
+			if message, ok := strings.CutSuffix(warning.Message, " - "+sourceFile); ok {
+				metadata.Warnings = append(metadata.Warnings, ExifToolWarning{Level: warning.Level, Message: message})
+				if warning.Level == "Error" && metadata.Err == nil {
+					metadata.Err = fmt.Errorf("exiftool error: %s", message)
+				}
+				break
+			}
+		}
+	}
+
+	for _, metadata := range byPath {
+		if metadata.Tags == nil && metadata.Err == nil {
+			metadata.Err = errors.New("exiftool returned no metadata")
+		}
 	}
 
-	result := make(map[string]string)
-	for key, value := range exifData[0] {
-		if str, ok := value.(string); ok {
-			result[key] = str
+	return byPath, nil
+}
+
+// batchReadTags returns the tags requested by ReadMetadataBatch
+func batchReadTags() []string {
+	tags := append([]string{}, exifDateTags...)
+	return append(tags, "GPSLatitude", "GPSLongitude", "GPSAltitude", "FileType", "MIMEType", "Make", "Model")
+}
+
+// stringifyTags converts ExifTool's JSON values to strings, skipping nested values
+func stringifyTags(entry map[string]interface{}) map[string]string {
+	tags := make(map[string]string, len(entry))
+	for key, value := range entry {
+		switch v := value.(type) {
+		case string:
+			tags[key] = v
+		case float64, bool:
+			tags[key] = fmt.Sprint(v)
 		}
 	}
+	return tags
+}
 
-	return result, nil
+// UpdateAllDates updates all date fields in a file. Warnings reported by
+// ExifTool are returned even when the update succeeded.
+func (etp *ExifToolProcess) UpdateAllDates(filePath, dateStr string) ([]ExifToolWarning, error) {
+	return etp.WriteTags(filePath, map[string]string{"AllDates": dateStr})
 }
 
-// UpdateAllDates updates all date fields in a file
-func (etp *ExifToolProcess) UpdateAllDates(filePath, dateStr string) error {
+// WriteTags implements MetadataBackend by writing each tag with -TAG=VALUE.
+// Formats the ExifTool version can't write fail with ErrExifToolTooOld.
+func (etp *ExifToolProcess) WriteTags(filePath string, tags map[string]string) ([]ExifToolWarning, error) {
 	etp.mu.Lock()
 	defer etp.mu.Unlock()
 
-	// Send update command to persistent ExifTool process
-	command := fmt.Sprintf("-overwrite_original\n-AllDates=%s\n%s\n-execute\n", dateStr, filePath)
+	if etp.version != 0 {
+		if err := checkWriteSupport(etp.version, filePath); err != nil {
+			return nil, err
+		}
+	}
 
-	if _, err := etp.stdin.Write([]byte(command)); err != nil {
-		return fmt.Errorf("failed to write to exiftool stdin: %v", err)
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
 	}
+	sortTagNames(names)
 
-	// Read response until we see {ready} marker
-	for etp.scanner.Scan() {
-		line := etp.scanner.Text()
-		if line == "{ready}" {
-			break
-		}
-		// Check for error messages
-		if strings.Contains(line, "Error:") || strings.Contains(line, "Warning:") {
-			return fmt.Errorf("exiftool error: %s", line)
+	// Send update command to persistent ExifTool process, with an argument
+	// for every item of a list tag
+	args := []string{"-overwrite_original"}
+	for _, name := range names {
+		for _, value := range strings.Split(tags[name], tagListSeparator) {
+			args = append(args, "-"+name+"="+value)
 		}
 	}
+	args = append(args, filePath)
 
-	if err := etp.scanner.Err(); err != nil {
-		return fmt.Errorf("failed to read exiftool output: %v", err)
+	response, err := etp.execute(args...)
+	if err != nil {
+		return nil, err
 	}
 
+	if err := firstExifToolError(response.Warnings); err != nil {
+		return response.Warnings, err
+	}
+
+	return response.Warnings, nil
+}
+
+// firstExifToolError returns the first error-level message as an error
+func firstExifToolError(warnings []ExifToolWarning) error {
+	for _, warning := range warnings {
+		if warning.Level == "Error" {
+			return fmt.Errorf("exiftool error: %s", warning.Message)
+		}
+	}
 	return nil
 }
 
@@ -1006,31 +1658,5 @@ func (etp *ExifToolProcess) Close() error {
 	etp.mu.Lock()
 	defer etp.mu.Unlock()
 
-	// Send -stay_open False to gracefully terminate ExifTool
-	if etp.stdin != nil {
-		etp.stdin.Write([]byte("-stay_open\nFalse\n"))
-		etp.stdin.Close()
-	}
-
-	if etp.stdout != nil {
-		etp.stdout.Close()
-	}
-
-	if etp.cmd != nil && etp.cmd.Process != nil {
-		// Wait for the process to exit gracefully, or kill it after a timeout
-		done := make(chan error, 1)
-		go func() {
-			done <- etp.cmd.Wait()
-		}()
-
-		select {
-		case err := <-done:
-			return err
-		case <-time.After(5 * time.Second):
-			// Force kill if it doesn't exit gracefully
-			return etp.cmd.Process.Kill()
-		}
-	}
-
-	return nil
+	return etp.stop(true)
 }
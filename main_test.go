@@ -2,10 +2,12 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"sync"
 	"testing"
@@ -625,20 +627,23 @@ func TestSupportedFileTypes(t *testing.T) {
 		t.Logf("ExifTool not available, using fallback: %v", err)
 	}
 
+	// The fallback list only holds media formats, so formats ExifTool merely
+	// reads metadata from are only supported with ExifTool's own list
 	testCases := []struct {
-		filename  string
-		supported bool
+		filename     string
+		supported    bool
+		exifToolOnly bool
 	}{
-		{"image.jpg", true},
-		{"image.JPEG", true},
-		{"image.png", true},
-		{"video.mp4", true},
-		{"video.MOV", true},
-		{"document.pdf", true},  // ExifTool supports PDF metadata
-		{"text.txt", true},      // ExifTool supports TXT files
-		{"archive.zip", true},   // ExifTool supports ZIP metadata
-		{"sidecar.json", false}, // JSON files are sidecar files, not media files
-		{"unknown.xyz", false},  // This extension shouldn't exist
+		{"image.jpg", true, false},
+		{"image.JPEG", true, false},
+		{"image.png", true, false},
+		{"video.mp4", true, false},
+		{"video.MOV", true, false},
+		{"document.pdf", true, true},   // ExifTool supports PDF metadata
+		{"text.txt", true, true},       // ExifTool supports TXT files
+		{"archive.zip", true, true},    // ExifTool supports ZIP metadata
+		{"sidecar.json", false, false}, // JSON files are sidecar files, not media files
+		{"unknown.xyz", false, false},  // This extension shouldn't exist
 	}
 
 	for _, tc := range testCases {
@@ -647,10 +652,11 @@ func TestSupportedFileTypes(t *testing.T) {
 			ext = strings.ToLower(ext)
 
 			isSupported := supportedExts[ext]
+			expected := tc.supported && (!tc.exifToolOnly || err == nil)
 
-			if isSupported != tc.supported {
+			if isSupported != expected {
 				t.Errorf("File %s: expected supported=%t, got supported=%t",
-					tc.filename, tc.supported, isSupported)
+					tc.filename, expected, isSupported)
 			}
 		})
 	}
@@ -1166,6 +1172,11 @@ func TestDoubleDotEdgeCase(t *testing.T) {
 }
 
 func TestSymlinkErrorHandling(t *testing.T) {
+	// Read-only directories don't stop root from creating the symlink
+	if os.Geteuid() == 0 {
+		t.Skip("Running as root, directory permissions can't force a symlink failure")
+	}
+
 	tmpDir := t.TempDir()
 	sourceDir := filepath.Join(tmpDir, "source")
 	outputDir := filepath.Join(tmpDir, "output")
@@ -1232,18 +1243,13 @@ func TestSymlinkErrorHandling(t *testing.T) {
 	config := &Config{
 		SourceDir: sourceDir,
 		OutputDir: outputDir,
-		Move:      true,
+		Move:      outputDir,
 		DryRun:    false,
 		Workers:   1,
 	}
 
-	// Create ExifTool manager
-	exifManager, err := NewExifToolManager(1)
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer exifManager.Close()
-	exifTool := exifManager.GetProcessForWorker(0)
+	// Use an in-memory metadata backend so the test doesn't depend on ExifTool
+	backend := NewMemoryBackend()
 
 	// Create MediaFile
 	file := MediaFile{
@@ -1253,7 +1259,7 @@ func TestSymlinkErrorHandling(t *testing.T) {
 	}
 
 	// Process the file - this should fail because symlink creation will fail
-	result := processMediaFile(config, exifTool, file)
+	result := processMediaFile(config, backend, file)
 
 	// Verify that the operation failed
 	if result.Success {
@@ -1278,3 +1284,297 @@ func TestSymlinkErrorHandling(t *testing.T) {
 		t.Error("File should not exist in destination directory when symlink creation fails and rollback occurs")
 	}
 }
+
+// fakeExifToolScript emulates ExifTool's -stay_open protocol, including
+// numbered -execute<N> ready markers, -echo4 output on stderr and several
+// files per command. A command with a file whose name contains "hang" never
+// gets an answer and "crash" kills the process; "warn" files produce a
+// warning and "fail" files an error. -ver prints $FAKE_EXIFTOOL_VERSION.
+const fakeExifToolScript = `#!/bin/sh
+if [ "$1" = "-ver" ]; then
+	echo "${FAKE_EXIFTOOL_VERSION:-12.40}"
+	exit 0
+fi
+files=""
+echo4=""
+skip=""
+while IFS= read -r line; do
+	[ -n "$FAKE_EXIFTOOL_LOG" ] && printf '%s\n' "$line" >> "$FAKE_EXIFTOOL_LOG"
+	if [ -n "$skip" ]; then
+		[ "$skip" = echo4 ] && echo4="$line"
+		skip=""
+		continue
+	fi
+	case "$line" in
+	-echo4) skip=echo4 ;;
+	-dateFormat) skip=arg ;;
+	-execute*)
+		case "$files" in
+		*hang*) sleep 5 ;;
+		*crash*) exit 1 ;;
+		esac
+		printf '%s' "$files" | {
+			sep=""
+			while IFS= read -r file; do
+				case "$file" in
+				*warn*) echo "Warning: [minor] Fake warning - $file" >&2 ;;
+				*fail*) echo "Error: Fake error - $file" >&2; continue ;;
+				esac
+				if [ -z "$sep" ]; then printf '['; sep=","; else printf ','; fi
+				printf '{"SourceFile": "%s", "FileName": "%s"}' "$file" "$(basename "$file")"
+			done
+			[ -n "$sep" ] && echo "]"
+		}
+		echo "$echo4" >&2
+		echo "{ready${line#-execute}}"
+		files="" ;;
+	False) exit 0 ;;
+	\#*) ;;
+	-*) ;;
+	*) files="$files$line
+" ;;
+	esac
+done
+`
+
+// installFakeExifTool puts a fake exiftool executable first in PATH
+func installFakeExifTool(t *testing.T, script string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("Fake ExifTool script requires a POSIX shell")
+	}
+
+	binDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(binDir, "exiftool"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestExifToolWatchdog(t *testing.T) {
+	installFakeExifTool(t, fakeExifToolScript)
+
+	etm, err := NewExifToolManager(1)
+	if err != nil {
+		t.Fatalf("Failed to create ExifTool manager: %v", err)
+	}
+	defer etm.Close()
+	etm.SetTimeout(200 * time.Millisecond)
+	process := etm.GetProcessForWorker(0)
+
+	if _, err := process.GetMetadata("/photos/before.jpg"); err != nil {
+		t.Fatalf("Expected first command to succeed, got %v", err)
+	}
+
+	if _, err := process.GetMetadata("/photos/hang.jpg"); !errors.Is(err, ErrExifToolTimeout) {
+		t.Errorf("Expected timeout error for hanging file, got %v", err)
+	}
+
+	// The process must have been restarted so later files still work
+	metadata, err := process.GetMetadata("/photos/next.jpg")
+	if err != nil {
+		t.Fatalf("Expected command after timeout to succeed, got %v", err)
+	}
+	if metadata["FileName"] != "next.jpg" {
+		t.Errorf("Expected response for next.jpg, got %v", metadata)
+	}
+
+	if _, err := process.GetMetadata("/photos/crash.jpg"); !errors.Is(err, ErrExifToolCrashed) {
+		t.Errorf("Expected crash error for crashing file, got %v", err)
+	}
+
+	if _, err := process.GetMetadata("/photos/last.jpg"); err != nil {
+		t.Errorf("Expected command after crash to succeed, got %v", err)
+	}
+}
+
+func TestExifToolBatchWatchdog(t *testing.T) {
+	installFakeExifTool(t, fakeExifToolScript)
+
+	etm, err := NewExifToolManager(1)
+	if err != nil {
+		t.Fatalf("Failed to create ExifTool manager: %v", err)
+	}
+	defer etm.Close()
+	etm.SetTimeout(200 * time.Millisecond)
+
+	// A hung batch times out after a couple of timeouts, not one per file
+	paths := []string{"/photos/hang.jpg"}
+	for i := 0; i < 19; i++ {
+		paths = append(paths, fmt.Sprintf("/photos/IMG_%d.jpg", i))
+	}
+	start := time.Now()
+	if _, err := etm.GetProcessForWorker(0).ReadMetadataBatch(paths); !errors.Is(err, ErrExifToolTimeout) {
+		t.Errorf("Expected timeout error for hanging batch, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Duration(batchTimeouts+1)*200*time.Millisecond {
+		t.Errorf("Expected the batch to time out after %d timeouts, took %v", batchTimeouts, elapsed)
+	}
+}
+
+func TestQuarantineCrashingFiles(t *testing.T) {
+	installFakeExifTool(t, fakeExifToolScript)
+
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	outputDir := filepath.Join(tmpDir, "output")
+	if err := os.MkdirAll(sourceDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	crashPath := filepath.Join(sourceDir, "crash.jpg")
+	if err := os.WriteFile(crashPath, []byte("bad"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	etm, err := NewExifToolManager(1)
+	if err != nil {
+		t.Fatalf("Failed to create ExifTool manager: %v", err)
+	}
+	defer etm.Close()
+
+	config := &Config{SourceDir: sourceDir, OutputDir: outputDir, Move: outputDir, Workers: 1}
+	etm.SetTimeout(200 * time.Millisecond)
+	results := processFiles(config, etm.Backends(), []MediaFile{{Path: crashPath, BaseName: "crash.jpg", Dir: sourceDir}})
+
+	if len(results) != 1 {
+		t.Fatalf("Expected 1 result, got %d", len(results))
+	}
+	if !results[0].Quarantined || results[0].Success {
+		t.Errorf("Expected crashing file to be quarantined, got %+v", results[0])
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "QUARANTINE", "crash.jpg")); err != nil {
+		t.Errorf("Expected crashing file to be moved to QUARANTINE: %v", err)
+	}
+}
+
+func TestExifToolWarnings(t *testing.T) {
+	installFakeExifTool(t, fakeExifToolScript)
+
+	etm, err := NewExifToolManager(1)
+	if err != nil {
+		t.Fatalf("Failed to create ExifTool manager: %v", err)
+	}
+	defer etm.Close()
+	etm.SetTimeout(5 * time.Second)
+	process := etm.GetProcessForWorker(0)
+
+	metadata, warnings, err := process.ReadMetadata("/photos/warn.jpg")
+	if err != nil {
+		t.Fatalf("Expected read with warning to succeed, got %v", err)
+	}
+	if metadata["FileName"] != "warn.jpg" {
+		t.Errorf("Expected metadata for warn.jpg, got %v", metadata)
+	}
+	if len(warnings) != 1 || warnings[0].Level != "Warning" || !strings.Contains(warnings[0].Message, "Fake warning") {
+		t.Errorf("Expected one parsed warning, got %v", warnings)
+	}
+
+	// Output of the next command must not pick up the previous warning
+	if _, warnings, err := process.ReadMetadata("/photos/clean.jpg"); err != nil || len(warnings) != 0 {
+		t.Errorf("Expected clean read without warnings, got %v (err %v)", warnings, err)
+	}
+
+	warnings, err = process.UpdateAllDates("/photos/fail.jpg", "2023:01:01 00:00:00")
+	if err == nil {
+		t.Error("Expected ExifTool error to fail the update")
+	}
+	if len(warnings) != 1 || warnings[0].Level != "Error" {
+		t.Errorf("Expected the error to be reported as a structured message, got %v", warnings)
+	}
+
+	if _, err := process.UpdateAllDates("/photos/warn.jpg", "2023:01:01 00:00:00"); err != nil {
+		t.Errorf("Expected warnings not to fail the update, got %v", err)
+	}
+}
+
+func TestParseExifToolMessage(t *testing.T) {
+	tests := []struct {
+		line    string
+		level   string
+		message string
+		ok      bool
+	}{
+		{"Warning: [minor] Ignored empty rational value - a.jpg", "Warning", "[minor] Ignored empty rational value - a.jpg", true},
+		{"Error: Not a valid JPG (looks more like a PNG) - a.jpg", "Error", "Not a valid JPG (looks more like a PNG) - a.jpg", true},
+		{"   ", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			warning, ok := parseExifToolMessage(tt.line)
+			if ok != tt.ok || warning.Level != tt.level || warning.Message != tt.message {
+				t.Errorf("Expected (%q, %q, %t), got (%q, %q, %t)", tt.level, tt.message, tt.ok, warning.Level, warning.Message, ok)
+			}
+		})
+	}
+}
+
+func TestReadMetadataBatch(t *testing.T) {
+	installFakeExifTool(t, fakeExifToolScript)
+
+	etm, err := NewExifToolManager(1)
+	if err != nil {
+		t.Fatalf("Failed to create ExifTool manager: %v", err)
+	}
+	defer etm.Close()
+	etm.SetTimeout(5 * time.Second)
+	process := etm.GetProcessForWorker(0)
+
+	paths := []string{"/photos/a.jpg", "/photos/warn.jpg", "/photos/fail.jpg", "/photos/b.jpg"}
+	batch, err := process.ReadMetadataBatch(paths)
+	if err != nil {
+		t.Fatalf("Failed to read batch: %v", err)
+	}
+
+	if len(batch) != len(paths) {
+		t.Fatalf("Expected %d results, got %d", len(paths), len(batch))
+	}
+	for _, path := range []string{"/photos/a.jpg", "/photos/b.jpg"} {
+		if batch[path].Err != nil || batch[path].Tags["FileName"] != filepath.Base(path) {
+			t.Errorf("Expected metadata for %s to be routed back, got %+v", path, batch[path])
+		}
+	}
+	if warnings := batch["/photos/warn.jpg"].Warnings; len(warnings) != 1 || warnings[0].Message != "[minor] Fake warning" {
+		t.Errorf("Expected warning routed to warn.jpg with file name stripped, got %v", warnings)
+	}
+	if len(batch["/photos/a.jpg"].Warnings) != 0 {
+		t.Errorf("Expected no warnings for a.jpg, got %v", batch["/photos/a.jpg"].Warnings)
+	}
+	if batch["/photos/fail.jpg"].Err == nil {
+		t.Error("Expected error for fail.jpg")
+	}
+}
+
+func TestProcessFilesBatchIsolatesCrashes(t *testing.T) {
+	installFakeExifTool(t, fakeExifToolScript)
+
+	tmpDir := t.TempDir()
+	etm, err := NewExifToolManager(1)
+	if err != nil {
+		t.Fatalf("Failed to create ExifTool manager: %v", err)
+	}
+	defer etm.Close()
+	etm.SetTimeout(5 * time.Second)
+
+	var files []MediaFile
+	for _, name := range []string{"one.jpg", "crash.jpg", "two.jpg"} {
+		files = append(files, MediaFile{Path: filepath.Join(tmpDir, name), BaseName: name, Dir: tmpDir})
+	}
+
+	config := &Config{SourceDir: tmpDir, OutputDir: tmpDir, Workers: 1, BatchSize: 3, DryRun: true}
+	results := processFiles(config, etm.Backends(), files)
+
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results, got %d", len(results))
+	}
+	for _, result := range results {
+		crashed := result.File.BaseName == "crash.jpg"
+		if result.Quarantined != crashed {
+			t.Errorf("%s: expected quarantined=%t, got %t (%v)", result.File.BaseName, crashed, result.Quarantined, result.Error)
+		}
+		if !crashed && isExifToolFailure(result.Error) {
+			t.Errorf("%s: should not be affected by another file crashing ExifTool: %v", result.File.BaseName, result.Error)
+		}
+	}
+}
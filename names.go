@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxTakeoutNameLength is the length Takeout truncates media file names to,
+// extension included
+const maxTakeoutNameLength = 47
+
+// normalizeName returns name in Unicode NFC. Takeouts extracted on macOS have
+// NFD names while sidecar titles are NFC, so names are always compared in NFC.
+func normalizeName(name string) string {
+	return norm.NFC.String(name)
+}
+
+// truncateRunes returns the first n characters of s. Takeout counts name
+// lengths in characters, so emoji and CJK names must not be cut mid-character.
+func truncateRunes(s string, n int) string {
+	for i := range s {
+		if n == 0 {
+			return s[:i]
+		}
+		n--
+	}
+	return s
+}
+
+// truncatedTakeoutName returns the name Takeout gives a file originally called
+// name: the part before the extension is cut so the whole name fits
+// maxTakeoutNameLength characters
+func truncatedTakeoutName(name string) string {
+	if utf8.RuneCountInString(name) <= maxTakeoutNameLength {
+		return name
+	}
+	ext := filepath.Ext(name)
+	extLen := utf8.RuneCountInString(ext)
+	if extLen >= maxTakeoutNameLength {
+		return truncateRunes(name, maxTakeoutNameLength)
+	}
+	return truncateRunes(name, maxTakeoutNameLength-extLen) + ext
+}
+
+// sanitizeFileName makes a sidecar title usable as a file name
+func sanitizeFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == 0 {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "." || name == ".." {
+		return ""
+	}
+	return name
+}
+
+// maxPathSegmentBytes is the longest file or folder name most file systems accept
+const maxPathSegmentBytes = 255
+
+// windowsReservedNames can't be used as file or folder names on Windows,
+// with or without an extension
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// sanitizePathSegment makes s usable as a single file or folder name on every
+// platform: path separators, characters Windows reserves and control
+// characters become "_", surrounding spaces and trailing dots are removed,
+// names Windows reserves get a "_" prefix, and names longer than
+// maxPathSegmentBytes are cut, keeping the extension. It returns "" if
+// nothing usable is left.
+func sanitizePathSegment(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`/\<>:"|?*`, r) {
+			return '_'
+		}
+		return r
+	}, s)
+	s = strings.TrimRight(strings.TrimSpace(s), ". ")
+	if s == "" || s == "." || s == ".." {
+		return ""
+	}
+	if stem, _, _ := strings.Cut(s, "."); windowsReservedNames[strings.ToUpper(strings.TrimSpace(stem))] {
+		s = "_" + s
+	}
+
+	if len(s) > maxPathSegmentBytes {
+		ext := filepath.Ext(s)
+		if len(ext) > maxPathSegmentBytes/8 {
+			ext = ""
+		}
+		base := strings.TrimSuffix(s, ext)
+		n := maxPathSegmentBytes - len(ext)
+		for n > 0 && !utf8.RuneStart(base[n]) {
+			n--
+		}
+		s = base[:n] + ext
+	}
+	return s
+}
+
+// restoredFileName returns the original name of a file that Takeout truncated,
+// taken from its sidecar title. The extension of name is kept, as are the
+// edited and duplicate counter suffixes Takeout appends after truncating.
+// Names that weren't truncated are returned unchanged. Names are compared in
+// NFC, and a restored name is in NFC like the title.
+func restoredFileName(name, title string) string {
+	title = sanitizeFileName(normalizeName(title))
+	ext := filepath.Ext(name)
+	base := normalizeName(strings.TrimSuffix(name, ext))
+	titleBase := strings.TrimSuffix(title, filepath.Ext(title))
+
+	suffix := ""
+	if original, edited, counter, ok := splitEditedSuffix(base); ok {
+		base, suffix = original, edited+counter
+	} else if matches := numberSuffixRegex.FindStringSubmatch(base); len(matches) == 3 {
+		base, suffix = matches[1], "("+matches[2]+")"
+	}
+
+	if len(titleBase) <= len(base) || !strings.HasPrefix(titleBase, base) {
+		return name
+	}
+	return titleBase + suffix + ext
+}
+
+// destinationClaims holds the destination paths handed out by
+// claimDestinationPath, so workers never pick the same free path
+var destinationClaims = struct {
+	sync.Mutex
+	paths map[string]bool
+}{paths: make(map[string]bool)}
+
+// claimDestinationPath returns path, or path with a "(n)" suffix added before
+// the extension if a file already exists there or the path was claimed before
+func claimDestinationPath(path string) string {
+	destinationClaims.Lock()
+	defer destinationClaims.Unlock()
+
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	candidate := path
+	for n := 1; ; n++ {
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) && !destinationClaims.paths[candidate] {
+			destinationClaims.paths[candidate] = true
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s(%d)%s", base, n, ext)
+	}
+}
@@ -0,0 +1,612 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// errNativeUnsupported is returned when the built-in reader can't parse a format
+var errNativeUnsupported = errors.New("format not supported by the built-in metadata reader")
+
+const (
+	// exifDateFormat is the layout used for all date tags, matching the
+	// -dateFormat passed to ExifTool
+	exifDateFormat = "2006:01:02 15:04:05"
+
+	// maxNativeSegment bounds how much of a single JPEG segment or ISOBMFF box
+	// is read into memory
+	maxNativeSegment = 16 * 1024 * 1024
+)
+
+var (
+	// quickTimeEpoch is the zero point of QuickTime/MP4 timestamps
+	quickTimeEpoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// XMP properties mapped to the ExifTool tag names used in exifDateTags
+	xmpDateProperties = []struct {
+		regex *regexp.Regexp
+		tag   string
+	}{
+		{regexp.MustCompile(`exif:DateTimeOriginal(?:="|>)([^"<]+)`), "DateTimeOriginal"},
+		{regexp.MustCompile(`xmp:CreateDate(?:="|>)([^"<]+)`), "CreateDate"},
+		{regexp.MustCompile(`photoshop:DateCreated(?:="|>)([^"<]+)`), "DateTimeCreated"},
+	}
+)
+
+// readNativeMetadata reads date tags from JPEG, HEIC and MP4/MOV files without
+// ExifTool. Tags use ExifTool's names and date format so the result can be used
+// in place of ExifTool's output. errNativeUnsupported is returned for other formats.
+func readNativeMetadata(path string) (map[string]string, error) {
+	detected, err := detectFileType(path)
+	if err != nil {
+		return nil, err
+	}
+	if detected == nil {
+		return nil, errNativeUnsupported
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tags := map[string]string{"FileType": detected.Name}
+	switch detected.Name {
+	case "JPEG":
+		err = readJPEGMetadata(f, tags)
+	case "HEIC", "AVIF":
+		err = readHEIFMetadata(f, tags)
+	case "MP4", "MOV", "3GP":
+		err = readQuickTimeMetadata(f, tags)
+	default:
+		return nil, errNativeUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// readJPEGMetadata walks the JPEG segments up to the start of scan and parses
+// the EXIF and XMP APP1 segments
+func readJPEGMetadata(r io.ReadSeeker, tags map[string]string) error {
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil {
+		return err
+	}
+
+	for {
+		var marker [2]byte
+		if _, err := io.ReadFull(r, marker[:]); err != nil {
+			return nil
+		}
+		if marker[0] != 0xFF {
+			return fmt.Errorf("invalid JPEG marker %x", marker)
+		}
+		// Start of scan or end of image: no more metadata segments
+		if marker[1] == 0xDA || marker[1] == 0xD9 {
+			return nil
+		}
+		// Fill bytes and markers without a length
+		if marker[1] == 0xFF || marker[1] == 0x01 || (marker[1] >= 0xD0 && marker[1] <= 0xD7) {
+			continue
+		}
+
+		var length uint16
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return err
+		}
+		if length < 2 {
+			return fmt.Errorf("invalid JPEG segment length %d", length)
+		}
+		size := int64(length) - 2
+
+		if marker[1] != 0xE1 {
+			if _, err := r.Seek(size, io.SeekCurrent); err != nil {
+				return err
+			}
+			continue
+		}
+
+		segment := make([]byte, size)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return err
+		}
+		if payload, ok := bytes.CutPrefix(segment, []byte("Exif\x00\x00")); ok {
+			parseTIFFMetadata(payload, tags)
+		} else if payload, ok := bytes.CutPrefix(segment, []byte("http://ns.adobe.com/xap/1.0/\x00")); ok {
+			parseXMPDates(payload, tags)
+		}
+	}
+}
+
+// parseTIFFMetadata extracts dates, make and model from a TIFF-structured EXIF
+// block. Malformed data is ignored rather than reported, like missing tags.
+func parseTIFFMetadata(data []byte, tags map[string]string) {
+	if len(data) < 8 {
+		return
+	}
+
+	var order binary.ByteOrder
+	switch string(data[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return
+	}
+
+	readIFD := func(offset uint32, visit func(tag uint16, value string, pointer uint32)) {
+		if int(offset)+2 > len(data) {
+			return
+		}
+		count := int(order.Uint16(data[offset:]))
+		for i := 0; i < count; i++ {
+			entry := int(offset) + 2 + i*12
+			if entry+12 > len(data) {
+				return
+			}
+			tag := order.Uint16(data[entry:])
+			typ := order.Uint16(data[entry+2:])
+			n := order.Uint32(data[entry+4:])
+			valueOffset := order.Uint32(data[entry+8:])
+
+			// ASCII values longer than four bytes are stored at an offset
+			var value string
+			if typ == 2 {
+				start, end := uint64(entry+8), uint64(entry+8)+uint64(n)
+				if n > 4 {
+					start, end = uint64(valueOffset), uint64(valueOffset)+uint64(n)
+				}
+				if end <= uint64(len(data)) {
+					value = strings.TrimRight(string(data[start:end]), "\x00 ")
+				}
+			}
+			visit(tag, value, valueOffset)
+		}
+	}
+
+	setDate := func(name, value string) {
+		// Unknown dates are written as "0000:00:00 00:00:00" or blanks
+		if value != "" && !strings.HasPrefix(value, "0000") && tags[name] == "" {
+			tags[name] = value
+		}
+	}
+
+	var exifIFD uint32
+	readIFD(order.Uint32(data[4:]), func(tag uint16, value string, pointer uint32) {
+		switch tag {
+		case 0x010F:
+			tags["Make"] = value
+		case 0x0110:
+			tags["Model"] = value
+		case 0x0132:
+			setDate("ModifyDate", value)
+		case 0x8769:
+			exifIFD = pointer
+		}
+	})
+
+	if exifIFD != 0 {
+		readIFD(exifIFD, func(tag uint16, value string, pointer uint32) {
+			switch tag {
+			case 0x9003:
+				setDate("DateTimeOriginal", value)
+			case 0x9004:
+				setDate("CreateDate", value)
+			}
+		})
+	}
+}
+
+// parseXMPDates extracts date properties from an XMP packet, keeping any
+// value already found in EXIF
+func parseXMPDates(packet []byte, tags map[string]string) {
+	for _, property := range xmpDateProperties {
+		if tags[property.tag] != "" {
+			continue
+		}
+		if matches := property.regex.FindSubmatch(packet); matches != nil {
+			if date, ok := normalizeISODate(string(matches[1])); ok {
+				tags[property.tag] = date
+			}
+		}
+	}
+}
+
+// normalizeISODate converts an ISO 8601 date such as "2019-04-12T15:30:12+02:00"
+// to the EXIF layout, keeping the local time as ExifTool does
+func normalizeISODate(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	if len(value) < 19 {
+		return "", false
+	}
+	date, err := time.Parse("2006-01-02T15:04:05", value[:19])
+	if err != nil {
+		return "", false
+	}
+	return date.Format(exifDateFormat), true
+}
+
+// isoBox is an ISO base media file format box located within a file
+type isoBox struct {
+	Type   string
+	Offset int64 // Offset of the box payload
+	Size   int64 // Size of the box payload
+}
+
+// readISOBoxes lists the boxes stored between start and end
+func readISOBoxes(r io.ReaderAt, start, end int64) ([]isoBox, error) {
+	var boxes []isoBox
+	for offset := start; offset+8 <= end; {
+		var header [16]byte
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return boxes, err
+		}
+		size := int64(binary.BigEndian.Uint32(header[0:4]))
+		typ := string(header[4:8])
+		headerSize := int64(8)
+
+		switch size {
+		case 0:
+			size = end - offset
+		case 1:
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return boxes, err
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if size < headerSize || offset+size > end {
+			return boxes, fmt.Errorf("invalid %q box size %d", typ, size)
+		}
+
+		boxes = append(boxes, isoBox{Type: typ, Offset: offset + headerSize, Size: size - headerSize})
+		offset += size
+	}
+	return boxes, nil
+}
+
+// findISOBox returns the first box of the given type
+func findISOBox(boxes []isoBox, typ string) *isoBox {
+	for i := range boxes {
+		if boxes[i].Type == typ {
+			return &boxes[i]
+		}
+	}
+	return nil
+}
+
+// readISOBoxData reads a box payload into memory
+func readISOBoxData(r io.ReaderAt, box *isoBox) ([]byte, error) {
+	if box.Size > maxNativeSegment {
+		return nil, fmt.Errorf("%q box too large (%d bytes)", box.Type, box.Size)
+	}
+	data := make([]byte, box.Size)
+	_, err := r.ReadAt(data, box.Offset)
+	return data, err
+}
+
+// fileEnd returns the size of a file opened for reading
+func fileEnd(f *os.File) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// readHEIFMetadata locates the Exif and XMP items of a HEIC/HEIF file through
+// the meta box's item info (iinf) and item location (iloc) tables
+func readHEIFMetadata(f *os.File, tags map[string]string) error {
+	end, err := fileEnd(f)
+	if err != nil {
+		return err
+	}
+	boxes, err := readISOBoxes(f, 0, end)
+	meta := findISOBox(boxes, "meta")
+	if meta == nil {
+		return err
+	}
+
+	// meta is a full box: skip version and flags
+	children, _ := readISOBoxes(f, meta.Offset+4, meta.Offset+meta.Size)
+	iinf := findISOBox(children, "iinf")
+	iloc := findISOBox(children, "iloc")
+	if iinf == nil || iloc == nil {
+		return nil
+	}
+
+	iinfData, err := readISOBoxData(f, iinf)
+	if err != nil {
+		return err
+	}
+	ilocData, err := readISOBoxData(f, iloc)
+	if err != nil {
+		return err
+	}
+
+	items := parseHEIFItemTypes(iinfData)
+	locations := parseHEIFItemLocations(ilocData)
+
+	for id, typ := range items {
+		loc, ok := locations[id]
+		if !ok || loc[1] <= 0 || loc[1] > maxNativeSegment {
+			continue
+		}
+		data := make([]byte, loc[1])
+		if _, err := f.ReadAt(data, loc[0]); err != nil {
+			continue
+		}
+
+		switch typ {
+		case "Exif":
+			// The payload starts with the offset of the TIFF header
+			if len(data) < 4 {
+				continue
+			}
+			headerOffset := int(binary.BigEndian.Uint32(data)) + 4
+			if headerOffset < len(data) {
+				parseTIFFMetadata(data[headerOffset:], tags)
+			}
+		case "mime":
+			parseXMPDates(data, tags)
+		}
+	}
+
+	return nil
+}
+
+// parseHEIFItemTypes maps item IDs to their item type from an iinf box payload
+func parseHEIFItemTypes(data []byte) map[uint32]string {
+	items := make(map[uint32]string)
+	if len(data) < 6 {
+		return items
+	}
+
+	// Skip version/flags and the entry count (16 or 32 bits)
+	offset := 6
+	if data[0] != 0 {
+		offset = 8
+	}
+
+	for offset+8 <= len(data) {
+		size := int(binary.BigEndian.Uint32(data[offset:]))
+		if size < 8 || offset+size > len(data) {
+			break
+		}
+		if string(data[offset+4:offset+8]) == "infe" {
+			infe := data[offset+8 : offset+size]
+			// Only infe versions 2 and 3 carry an item type
+			if len(infe) >= 4 && (infe[0] == 2 || infe[0] == 3) {
+				var id uint32
+				pos := 4
+				if infe[0] == 2 && len(infe) >= pos+2 {
+					id = uint32(binary.BigEndian.Uint16(infe[pos:]))
+					pos += 2
+				} else if len(infe) >= pos+4 {
+					id = binary.BigEndian.Uint32(infe[pos:])
+					pos += 4
+				}
+				pos += 2 // item_protection_index
+				if len(infe) >= pos+4 {
+					items[id] = string(infe[pos : pos+4])
+				}
+			}
+		}
+		offset += size
+	}
+
+	return items
+}
+
+// parseHEIFItemLocations maps item IDs to the file offset and length of their
+// first extent from an iloc box payload. Only items stored directly in the
+// file (construction method 0) are returned.
+func parseHEIFItemLocations(data []byte) map[uint32][2]int64 {
+	locations := make(map[uint32][2]int64)
+	if len(data) < 8 {
+		return locations
+	}
+
+	version := data[0]
+	offsetSize := int(data[4] >> 4)
+	lengthSize := int(data[4] & 0x0F)
+	baseOffsetSize := int(data[5] >> 4)
+	indexSize := 0
+	if version == 1 || version == 2 {
+		indexSize = int(data[5] & 0x0F)
+	}
+
+	pos := 6
+	readUint := func(size int) (uint64, bool) {
+		if size == 0 {
+			return 0, true
+		}
+		if pos+size > len(data) {
+			return 0, false
+		}
+		var value uint64
+		for _, b := range data[pos : pos+size] {
+			value = value<<8 | uint64(b)
+		}
+		pos += size
+		return value, true
+	}
+
+	countSize := 2
+	if version == 2 {
+		countSize = 4
+	}
+	itemCount, ok := readUint(countSize)
+	if !ok {
+		return locations
+	}
+
+	for i := uint64(0); i < itemCount; i++ {
+		id, ok := readUint(countSize)
+		if !ok {
+			return locations
+		}
+		constructionMethod := uint64(0)
+		if version == 1 || version == 2 {
+			if constructionMethod, ok = readUint(2); !ok {
+				return locations
+			}
+			constructionMethod &= 0x0F
+		}
+		readUint(2) // data_reference_index
+		baseOffset, _ := readUint(baseOffsetSize)
+		extentCount, ok := readUint(2)
+		if !ok {
+			return locations
+		}
+
+		for e := uint64(0); e < extentCount; e++ {
+			readUint(indexSize)
+			extentOffset, ok1 := readUint(offsetSize)
+			extentLength, ok2 := readUint(lengthSize)
+			if !ok1 || !ok2 {
+				return locations
+			}
+			if e == 0 && constructionMethod == 0 {
+				locations[uint32(id)] = [2]int64{int64(baseOffset + extentOffset), int64(extentLength)}
+			}
+		}
+	}
+
+	return locations
+}
+
+// readQuickTimeMetadata reads the movie and media creation times from the
+// moov box of an MP4/MOV file, and Apple's creationdate key if present
+func readQuickTimeMetadata(f *os.File, tags map[string]string) error {
+	end, err := fileEnd(f)
+	if err != nil {
+		return err
+	}
+	boxes, err := readISOBoxes(f, 0, end)
+	moov := findISOBox(boxes, "moov")
+	if moov == nil {
+		return err
+	}
+
+	children, _ := readISOBoxes(f, moov.Offset, moov.Offset+moov.Size)
+	if mvhd := findISOBox(children, "mvhd"); mvhd != nil {
+		if date, ok := readQuickTimeDate(f, mvhd); ok {
+			tags["CreateDate"] = date
+		}
+	}
+
+	for _, trak := range children {
+		if trak.Type != "trak" {
+			continue
+		}
+		trakChildren, _ := readISOBoxes(f, trak.Offset, trak.Offset+trak.Size)
+		mdia := findISOBox(trakChildren, "mdia")
+		if mdia == nil {
+			continue
+		}
+		mdiaChildren, _ := readISOBoxes(f, mdia.Offset, mdia.Offset+mdia.Size)
+		if mdhd := findISOBox(mdiaChildren, "mdhd"); mdhd != nil {
+			if date, ok := readQuickTimeDate(f, mdhd); ok {
+				tags["MediaCreateDate"] = date
+				break
+			}
+		}
+	}
+
+	if meta := findISOBox(children, "meta"); meta != nil {
+		readQuickTimeKeys(f, meta, tags)
+	}
+
+	return nil
+}
+
+// readQuickTimeDate reads the creation time of an mvhd or mdhd full box
+func readQuickTimeDate(r io.ReaderAt, box *isoBox) (string, bool) {
+	var header [12]byte
+	if box.Size < int64(len(header)) {
+		return "", false
+	}
+	if _, err := r.ReadAt(header[:], box.Offset); err != nil {
+		return "", false
+	}
+
+	var seconds uint64
+	if header[0] == 1 {
+		seconds = binary.BigEndian.Uint64(header[4:12])
+	} else {
+		seconds = uint64(binary.BigEndian.Uint32(header[4:8]))
+	}
+	// Zero means the creation time was never set
+	if seconds == 0 {
+		return "", false
+	}
+
+	return quickTimeEpoch.Add(time.Duration(seconds) * time.Second).Format(exifDateFormat), true
+}
+
+// readQuickTimeKeys reads com.apple.quicktime.creationdate from the mdta
+// keys/ilst metadata written by iPhones, reported by ExifTool as CreationDate
+func readQuickTimeKeys(r io.ReaderAt, meta *isoBox, tags map[string]string) {
+	children, _ := readISOBoxes(r, meta.Offset, meta.Offset+meta.Size)
+	keysBox := findISOBox(children, "keys")
+	ilstBox := findISOBox(children, "ilst")
+	if keysBox == nil || ilstBox == nil {
+		return
+	}
+
+	keysData, err := readISOBoxData(r, keysBox)
+	if err != nil || len(keysData) < 8 {
+		return
+	}
+
+	// keys is a full box: version/flags, entry count, then size+namespace+name entries
+	creationIndex := 0
+	pos := 8
+	for index := 1; pos+8 <= len(keysData); index++ {
+		size := int(binary.BigEndian.Uint32(keysData[pos:]))
+		if size < 8 || pos+size > len(keysData) {
+			return
+		}
+		if string(keysData[pos+8:pos+size]) == "com.apple.quicktime.creationdate" {
+			creationIndex = index
+			break
+		}
+		pos += size
+	}
+	if creationIndex == 0 {
+		return
+	}
+
+	// ilst items are boxes whose type is the 1-based key index
+	items, _ := readISOBoxes(r, ilstBox.Offset, ilstBox.Offset+ilstBox.Size)
+	for _, item := range items {
+		if binary.BigEndian.Uint32([]byte(item.Type)) != uint32(creationIndex) {
+			continue
+		}
+		values, _ := readISOBoxes(r, item.Offset, item.Offset+item.Size)
+		if data := findISOBox(values, "data"); data != nil {
+			// Skip the 4-byte type indicator and 4-byte locale
+			value, err := readISOBoxData(r, data)
+			if err != nil || len(value) <= 8 {
+				return
+			}
+			if date, ok := normalizeISODate(string(value[8:])); ok {
+				tags["CreationDate"] = date
+			}
+		}
+		return
+	}
+}
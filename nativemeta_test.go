@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// buildTIFFExif returns a little-endian TIFF block with Make in IFD0 and
+// DateTimeOriginal in the Exif IFD
+func buildTIFFExif(cameraMake, dateTimeOriginal string) []byte {
+	var buf bytes.Buffer
+	le := binary.LittleEndian
+
+	makeValue := append([]byte(cameraMake), 0)
+	dateValue := append([]byte(dateTimeOriginal), 0)
+
+	// Header (8) + IFD0 with 2 entries (2+24+4) + Exif IFD with 1 entry (2+12+4)
+	ifd0Offset := uint32(8)
+	exifOffset := ifd0Offset + 30
+	makeOffset := exifOffset + 18
+	dateOffset := makeOffset + uint32(len(makeValue))
+
+	buf.WriteString("II")
+	binary.Write(&buf, le, uint16(42))
+	binary.Write(&buf, le, ifd0Offset)
+
+	binary.Write(&buf, le, uint16(2))
+	binary.Write(&buf, le, []uint16{0x010F, 2})
+	binary.Write(&buf, le, []uint32{uint32(len(makeValue)), makeOffset})
+	binary.Write(&buf, le, []uint16{0x8769, 4})
+	binary.Write(&buf, le, []uint32{1, exifOffset})
+	binary.Write(&buf, le, uint32(0))
+
+	binary.Write(&buf, le, uint16(1))
+	binary.Write(&buf, le, []uint16{0x9003, 2})
+	binary.Write(&buf, le, []uint32{uint32(len(dateValue)), dateOffset})
+	binary.Write(&buf, le, uint32(0))
+
+	buf.Write(makeValue)
+	buf.Write(dateValue)
+	return buf.Bytes()
+}
+
+// buildJPEG wraps APP1 payloads into a minimal JPEG stream
+func buildJPEG(app1 ...[]byte) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{0xFF, 0xD8})
+	for _, payload := range app1 {
+		buf.Write([]byte{0xFF, 0xE1})
+		binary.Write(&buf, binary.BigEndian, uint16(len(payload)+2))
+		buf.Write(payload)
+	}
+	buf.Write([]byte{0xFF, 0xDA, 0x00, 0x02, 0xFF, 0xD9})
+	return buf.Bytes()
+}
+
+// isoBoxBytes encodes an ISO base media file format box
+func isoBoxBytes(typ string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	box := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(box, uint32(8+len(body)))
+	copy(box[4:], typ)
+	return append(box, body...)
+}
+
+func writeTestFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadNativeMetadataJPEGExif(t *testing.T) {
+	exif := append([]byte("Exif\x00\x00"), buildTIFFExif("Google", "2019:04:12 15:30:12")...)
+	path := writeTestFile(t, "IMG_0001.jpg", buildJPEG(exif))
+
+	tags, err := readNativeMetadata(path)
+	if err != nil {
+		t.Fatalf("Failed to read JPEG metadata: %v", err)
+	}
+	if tags["DateTimeOriginal"] != "2019:04:12 15:30:12" {
+		t.Errorf("Expected DateTimeOriginal 2019:04:12 15:30:12, got %q", tags["DateTimeOriginal"])
+	}
+	if tags["Make"] != "Google" {
+		t.Errorf("Expected Make Google, got %q", tags["Make"])
+	}
+	if tags["FileType"] != "JPEG" {
+		t.Errorf("Expected FileType JPEG, got %q", tags["FileType"])
+	}
+}
+
+func TestReadNativeMetadataJPEGXMP(t *testing.T) {
+	xmp := []byte(`http://ns.adobe.com/xap/1.0/` + "\x00" +
+		`<x:xmpmeta><rdf:Description exif:DateTimeOriginal="2020-01-02T03:04:05+01:00"/></x:xmpmeta>`)
+	path := writeTestFile(t, "IMG_0002.jpg", buildJPEG(xmp))
+
+	tags, err := readNativeMetadata(path)
+	if err != nil {
+		t.Fatalf("Failed to read JPEG metadata: %v", err)
+	}
+	if tags["DateTimeOriginal"] != "2020:01:02 03:04:05" {
+		t.Errorf("Expected DateTimeOriginal from XMP, got %q", tags["DateTimeOriginal"])
+	}
+}
+
+func TestReadNativeMetadataMP4(t *testing.T) {
+	created := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	seconds := uint32(created.Sub(quickTimeEpoch) / time.Second)
+
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[4:], seconds)
+	mdhd := make([]byte, 24)
+	binary.BigEndian.PutUint32(mdhd[4:], seconds+1)
+
+	data := bytes.Join([][]byte{
+		isoBoxBytes("ftyp", []byte("isom\x00\x00\x02\x00")),
+		isoBoxBytes("mdat", []byte("video data")),
+		isoBoxBytes("moov",
+			isoBoxBytes("mvhd", mvhd),
+			isoBoxBytes("trak", isoBoxBytes("mdia", isoBoxBytes("mdhd", mdhd))),
+		),
+	}, nil)
+	path := writeTestFile(t, "VID_0001.mp4", data)
+
+	tags, err := readNativeMetadata(path)
+	if err != nil {
+		t.Fatalf("Failed to read MP4 metadata: %v", err)
+	}
+	if tags["CreateDate"] != "2021:06:01 12:00:00" {
+		t.Errorf("Expected CreateDate 2021:06:01 12:00:00, got %q", tags["CreateDate"])
+	}
+	if tags["MediaCreateDate"] != "2021:06:01 12:00:01" {
+		t.Errorf("Expected MediaCreateDate 2021:06:01 12:00:01, got %q", tags["MediaCreateDate"])
+	}
+}
+
+func TestReadNativeMetadataHEIC(t *testing.T) {
+	exifItem := append([]byte{0, 0, 0, 6}, "Exif\x00\x00"...)
+	exifItem = append(exifItem, buildTIFFExif("Apple", "2022:08:09 10:11:12")...)
+
+	infe := isoBoxBytes("infe", []byte{2, 0, 0, 0, 0, 1, 0, 0}, []byte("Exif\x00"))
+	iinf := isoBoxBytes("iinf", []byte{0, 0, 0, 0, 0, 1}, infe)
+	buildMeta := func(exifOffset uint32) []byte {
+		iloc := []byte{0, 0, 0, 0, 0x44, 0x00, 0, 1, 0, 1, 0, 0, 0, 1}
+		iloc = binary.BigEndian.AppendUint32(iloc, exifOffset)
+		iloc = binary.BigEndian.AppendUint32(iloc, uint32(len(exifItem)))
+		return isoBoxBytes("meta", []byte{0, 0, 0, 0}, iinf, isoBoxBytes("iloc", iloc))
+	}
+
+	ftyp := isoBoxBytes("ftyp", []byte("heic\x00\x00\x00\x00mif1heic"))
+	exifOffset := uint32(len(ftyp) + len(buildMeta(0)) + 8)
+	data := bytes.Join([][]byte{ftyp, buildMeta(exifOffset), isoBoxBytes("mdat", exifItem)}, nil)
+	path := writeTestFile(t, "IMG_0003.heic", data)
+
+	tags, err := readNativeMetadata(path)
+	if err != nil {
+		t.Fatalf("Failed to read HEIC metadata: %v", err)
+	}
+	if tags["DateTimeOriginal"] != "2022:08:09 10:11:12" {
+		t.Errorf("Expected DateTimeOriginal from HEIC Exif item, got %q", tags["DateTimeOriginal"])
+	}
+	if tags["Make"] != "Apple" {
+		t.Errorf("Expected Make Apple, got %q", tags["Make"])
+	}
+}
+
+func TestReadNativeMetadataUnsupported(t *testing.T) {
+	path := writeTestFile(t, "notes.txt", []byte("just text"))
+	if _, err := readNativeMetadata(path); err != errNativeUnsupported {
+		t.Errorf("Expected errNativeUnsupported, got %v", err)
+	}
+
+	// A JPEG without any date should be handed to ExifTool
+	jpeg := writeTestFile(t, "blank.jpg", buildJPEG())
+	results, _ := NativeBackend{}.ReadTags([]string{jpeg})
+	if hasDateTag(results[jpeg]) {
+		t.Errorf("Expected no native dates for JPEG without metadata, got %v", results[jpeg].Tags)
+	}
+}
+
+func TestProcessMediaFileWithoutExifTool(t *testing.T) {
+	exif := append([]byte("Exif\x00\x00"), buildTIFFExif("Google", "2019:04:12 15:30:12")...)
+	path := writeTestFile(t, "IMG_0004.jpg", buildJPEG(exif))
+	outputDir := t.TempDir()
+
+	config := &Config{SourceDir: filepath.Dir(path), OutputDir: outputDir, Move: outputDir, DryRun: true, Workers: 1}
+	result := processMediaFile(config, NativeBackend{}, MediaFile{Path: path, BaseName: "IMG_0004.jpg", Dir: filepath.Dir(path)})
+
+	if !result.Success {
+		t.Fatalf("Expected dry run to succeed with the built-in reader, got %v", result.Error)
+	}
+	expected := generateDestinationPath(outputDir, "IMG_0004.jpg", time.Date(2019, 4, 12, 15, 30, 12, 0, time.UTC))
+	if !strings.Contains(result.Action, expected) {
+		t.Errorf("Expected action to mention %s, got %q", expected, result.Action)
+	}
+}
+
+func TestProcessUnsupportedFormatWithoutExifTool(t *testing.T) {
+	path := writeTestFile(t, "IMG_0005.png", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR"))
+	writeSidecar(t, path+".json", "IMG_0005.png", 1555083012)
+	outputDir := t.TempDir()
+
+	// The built-in reader can't parse PNG, so the sidecar gives the date
+	config := &Config{SourceDir: filepath.Dir(path), OutputDir: outputDir, Move: outputDir, DryRun: true, Workers: 1}
+	result := processMediaFile(config, NativeBackend{}, MediaFile{Path: path, BaseName: "IMG_0005.png", Dir: filepath.Dir(path)})
+
+	if !result.Success {
+		t.Fatalf("Expected dry run to succeed with the sidecar date, got %v", result.Error)
+	}
+	if result.SidecarMatch == nil {
+		t.Error("Expected the date to come from the sidecar")
+	}
+	expected := generateDestinationPath(outputDir, "IMG_0005.png", time.Unix(1555083012, 0))
+	if !strings.Contains(result.Action, expected) {
+		t.Errorf("Expected action to mention %s, got %q", expected, result.Action)
+	}
+}
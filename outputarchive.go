@@ -0,0 +1,318 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// albumsManifest lists the members of each album in a .zip output, as zip
+// symlinks are only understood by some tools
+const albumsManifest = "albums.json"
+
+// OutputArchive writes the organized ALL_PHOTOS and ALBUMS structure into a
+// .tar, .tgz or .zip instead of a folder. Files are moved into a staging
+// folder next to the archive as in -move mode, and appended to the archive
+// as soon as they are processed, so the staging folder only holds the files
+// in flight. It is only used by the goroutine collecting results.
+type OutputArchive struct {
+	path       string
+	stagingDir string
+
+	file *os.File
+	gz   *gzip.Writer
+	tar  *tar.Writer
+	zip  *zip.Writer
+
+	names  map[string]bool     // Entries written so far
+	albums map[string][]string // Members of each album folder, for .zip manifests
+}
+
+// isOutputArchive reports whether path names an archive format that can be written
+func isOutputArchive(path string) bool {
+	name := strings.ToLower(path)
+	return strings.HasSuffix(name, ".tar") || isArchive(name)
+}
+
+// outputStagingDir returns the staging folder used while writing archive
+func outputStagingDir(archive string) string {
+	return archive + ".staging"
+}
+
+// newOutputArchive creates the archive at path, refusing to overwrite one
+func newOutputArchive(path string) (*OutputArchive, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &OutputArchive{
+		path:       path,
+		stagingDir: outputStagingDir(path),
+		file:       file,
+		names:      make(map[string]bool),
+		albums:     make(map[string][]string),
+	}
+	switch name := strings.ToLower(path); {
+	case strings.HasSuffix(name, ".zip"):
+		out.zip = zip.NewWriter(file)
+	case strings.HasSuffix(name, ".tar"):
+		out.tar = tar.NewWriter(file)
+	default:
+		out.gz = gzip.NewWriter(file)
+		out.tar = tar.NewWriter(out.gz)
+	}
+	return out, nil
+}
+
+// addResult appends the file of a successful result, and its album link, to
+// the archive and removes them from the staging folder
+func (o *OutputArchive) addResult(result Result) Result {
+	if !result.Success || result.DestPath == "" {
+		return result
+	}
+
+	name, err := o.addFile(result.DestPath)
+	if err != nil {
+		result.Success = false
+		result.Error = fmt.Errorf("failed to add to %s: %v", o.path, err)
+		return result
+	}
+	result.Action += fmt.Sprintf(" | Archived as: %s", name)
+
+	for _, albumLink := range result.AlbumLinks {
+		if err := o.addLink(albumLink, name); err != nil {
+			result.Success = false
+			result.Error = fmt.Errorf("failed to add album link to %s: %v", o.path, err)
+			break
+		}
+	}
+	return result
+}
+
+// entryName returns the archive name of a staged path and claims it, adding
+// a "(n)" suffix before the extension if it was already written
+func (o *OutputArchive) entryName(stagedPath string) (string, error) {
+	rel, err := filepath.Rel(o.stagingDir, stagedPath)
+	if err != nil {
+		return "", err
+	}
+	name := filepath.ToSlash(rel)
+
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := name
+	for n := 1; o.names[candidate]; n++ {
+		candidate = fmt.Sprintf("%s(%d)%s", base, n, ext)
+	}
+	o.names[candidate] = true
+	return candidate, nil
+}
+
+// entryPath returns where a staged path ends up, as the archive path followed
+// by the entry name
+func (o *OutputArchive) entryPath(stagedPath string) string {
+	if rel, err := filepath.Rel(o.stagingDir, stagedPath); err == nil {
+		return filepath.Join(o.path, rel)
+	}
+	return stagedPath
+}
+
+// addFile appends a staged regular file and removes it
+func (o *OutputArchive) addFile(stagedPath string) (string, error) {
+	info, err := os.Stat(stagedPath)
+	if err != nil {
+		return "", err
+	}
+	name, err := o.entryName(stagedPath)
+	if err != nil {
+		return "", err
+	}
+
+	in, err := os.Open(stagedPath)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	var w io.Writer
+	if o.zip != nil {
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return "", err
+		}
+		header.Name = name
+		// Photos and videos are already compressed
+		header.Method = zip.Deflate
+		if supportedExts[strings.ToLower(path.Ext(name))] {
+			header.Method = zip.Store
+		}
+		if w, err = o.zip.CreateHeader(header); err != nil {
+			return "", err
+		}
+	} else {
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return "", err
+		}
+		header.Name = name
+		if err := o.tar.WriteHeader(header); err != nil {
+			return "", err
+		}
+		w = o.tar
+	}
+
+	if _, err := io.Copy(w, in); err != nil {
+		return "", err
+	}
+	in.Close()
+	return name, os.Remove(stagedPath)
+}
+
+// addLink appends a staged album symlink pointing at the archive entry target
+// and removes it. A .zip gets a symlink entry and the album manifest entry.
+func (o *OutputArchive) addLink(stagedPath, target string) error {
+	name, err := o.entryName(stagedPath)
+	if err != nil {
+		return err
+	}
+	linkTarget, err := filepath.Rel(path.Dir(name), target)
+	if err != nil {
+		return err
+	}
+	linkTarget = filepath.ToSlash(linkTarget)
+
+	if o.zip != nil {
+		header := &zip.FileHeader{Name: name, Method: zip.Store}
+		header.SetMode(fs.ModeSymlink | 0777)
+		w, err := o.zip.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, linkTarget); err != nil {
+			return err
+		}
+		album := path.Dir(name)
+		o.albums[album] = append(o.albums[album], target)
+	} else {
+		header := &tar.Header{Typeflag: tar.TypeSymlink, Name: name, Linkname: linkTarget, Mode: 0777}
+		if info, err := os.Lstat(stagedPath); err == nil {
+			header.ModTime = info.ModTime()
+		}
+		if err := o.tar.WriteHeader(header); err != nil {
+			return err
+		}
+	}
+	return os.Remove(stagedPath)
+}
+
+// Close appends whatever is left in the staging folder, such as reports and
+// quarantined files, writes the album manifest of a .zip and finishes the
+// archive. Files extracted from input archives are left for their own cleanup.
+func (o *OutputArchive) Close() error {
+	err := o.addRemaining()
+
+	var closeErr error
+	if o.zip != nil {
+		closeErr = o.zip.Close()
+	} else {
+		closeErr = o.tar.Close()
+	}
+	if closeErr == nil && o.gz != nil {
+		closeErr = o.gz.Close()
+	}
+	if fileErr := o.file.Close(); closeErr == nil {
+		closeErr = fileErr
+	}
+	if err == nil {
+		err = closeErr
+	}
+
+	removeEmptyDirs(o.stagingDir)
+	return err
+}
+
+// addRemaining appends the files and links left in the staging folder and
+// the album manifest
+func (o *OutputArchive) addRemaining() error {
+	var staged []string
+	err := filepath.WalkDir(o.stagingDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == stagingDirName {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() {
+			staged = append(staged, path)
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, stagedPath := range staged {
+		info, err := os.Lstat(stagedPath)
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			if _, err := o.addFile(stagedPath); err != nil {
+				return fmt.Errorf("failed to add %s: %v", stagedPath, err)
+			}
+			continue
+		}
+		target, err := os.Readlink(stagedPath)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(o.stagingDir, filepath.Join(filepath.Dir(stagedPath), target))
+		if err != nil {
+			return err
+		}
+		if err := o.addLink(stagedPath, filepath.ToSlash(rel)); err != nil {
+			return fmt.Errorf("failed to add %s: %v", stagedPath, err)
+		}
+	}
+
+	// -albums json already wrote a manifest, added with the remaining files
+	if o.zip != nil && len(o.albums) > 0 && !o.names[albumsManifest] {
+		for _, members := range o.albums {
+			sort.Strings(members)
+		}
+		data, err := json.MarshalIndent(o.albums, "", "  ")
+		if err != nil {
+			return err
+		}
+		w, err := o.zip.Create(albumsManifest)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(append(data, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeOutputArchive finishes the output archive and says where it is
+func closeOutputArchive(out *OutputArchive) {
+	if err := out.Close(); err != nil {
+		fmt.Printf("Error: failed to finish %s: %v\n", out.path, err)
+		return
+	}
+	fmt.Printf("Archive written: %s\n", out.path)
+	if _, err := os.Stat(out.stagingDir); err == nil {
+		fmt.Printf("Files that could not be archived were left in %s\n", out.stagingDir)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readTgzEntries returns the content of each regular file of a .tgz and the
+// target of each symlink
+func readTgzEntries(t *testing.T, path string) (files, links map[string]string) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, links = make(map[string]string), make(map[string]string)
+	r := tar.NewReader(gz)
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			return files, links
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch header.Typeflag {
+		case tar.TypeSymlink:
+			links[header.Name] = header.Linkname
+		case tar.TypeReg:
+			data, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files[header.Name] = string(data)
+		}
+	}
+}
+
+// readZipEntries is readTgzEntries for a .zip, whose symlinks hold their target
+func readZipEntries(t *testing.T, path string) (files, links map[string]string) {
+	t.Helper()
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	files, links = make(map[string]string), make(map[string]string)
+	for _, file := range r.File {
+		rc, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if file.Mode()&fs.ModeSymlink != 0 {
+			links[file.Name] = string(data)
+		} else {
+			files[file.Name] = string(data)
+		}
+	}
+	return files, links
+}
+
+func TestOutputArchive(t *testing.T) {
+	saveSupportedExtensions(t)
+	setSupportedExtensions([]string{".jpg"}, extSourceFallback)
+
+	tests := []struct {
+		name string
+		read func(*testing.T, string) (map[string]string, map[string]string)
+	}{
+		{"photos.tgz", readTgzEntries},
+		{"photos.zip", readZipEntries},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sourceDir := t.TempDir()
+			album := filepath.Join(sourceDir, "Trip")
+			writeMedia(t, filepath.Join(album, "IMG_1.jpg"))
+			writeSidecar(t, filepath.Join(album, "IMG_1.jpg.json"), "IMG_1.jpg", 1555083012)
+			if err := os.WriteFile(filepath.Join(album, "metadata.json"), []byte(`{"title": "Trip"}`), 0644); err != nil {
+				t.Fatal(err)
+			}
+			// Same name and day as the album photo, in another folder
+			writeMedia(t, filepath.Join(sourceDir, "Photos from 2019", "IMG_1.jpg"))
+			writeSidecar(t, filepath.Join(sourceDir, "Photos from 2019", "IMG_1.jpg.json"), "IMG_1.jpg", 1555083013)
+
+			archivePath := filepath.Join(t.TempDir(), test.name)
+			config := &Config{SourceDirs: []string{sourceDir}, OutputArchivePath: archivePath, Workers: 1, BatchSize: 1}
+			if err := validateConfig(config); err != nil {
+				t.Fatal(err)
+			}
+			out, err := newOutputArchive(archivePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			config.OutputArchive = out
+
+			mediaFiles, idx, err := scanTakeout(sourceDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			config.Sidecars = idx
+			results := processFiles(config, []MetadataBackend{NewMemoryBackend()}, mediaFiles)
+			for _, result := range results {
+				if !result.Success {
+					t.Errorf("%s: %v", result.File.Path, result.Error)
+				}
+			}
+			if err := writeReports(config, results); err != nil {
+				t.Fatal(err)
+			}
+			if err := out.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(out.stagingDir); !os.IsNotExist(err) {
+				t.Errorf("Expected the staging folder to be removed, got %v", err)
+			}
+
+			files, links := test.read(t, archivePath)
+			day := filepath.ToSlash(filepath.Dir(generateDestinationPath("", "x", time.Unix(1555083012, 0))))
+			for _, name := range []string{day + "/IMG_1.jpg", day + "/IMG_1(1).jpg", orphanSidecarsReport, unmatchedMediaReport} {
+				if _, ok := files[name]; !ok {
+					t.Errorf("Expected %s in the archive, got %v", name, files)
+				}
+			}
+			if len(links) != 1 {
+				t.Fatalf("Expected one album link, got %v", links)
+			}
+			// Either photo may have been named IMG_1(1).jpg, depending on which came first
+			for name, target := range links {
+				if filepath.Dir(name) != "ALBUMS/Trip" || files[filepath.ToSlash(filepath.Join("ALBUMS/Trip", target))] != "media" {
+					t.Errorf("Expected the album link to point at a photo, got %s -> %s", name, target)
+				}
+			}
+
+			if test.name == "photos.zip" {
+				var albums map[string][]string
+				if err := json.Unmarshal([]byte(files[albumsManifest]), &albums); err != nil {
+					t.Fatal(err)
+				}
+				if members := albums["ALBUMS/Trip"]; len(members) != 1 || files[members[0]] != "media" {
+					t.Errorf("Expected the manifest to list the album photo, got %v", albums)
+				}
+			}
+		})
+	}
+}
+
+func TestOutputArchiveFlags(t *testing.T) {
+	sourceDir := t.TempDir()
+	existing := filepath.Join(t.TempDir(), "photos.zip")
+	if err := os.WriteFile(existing, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"with -move", Config{OutputArchivePath: "photos.zip", Move: t.TempDir()}},
+		{"unknown format", Config{OutputArchivePath: "photos.rar"}},
+		{"existing archive", Config{OutputArchivePath: existing}},
+	}
+	for _, test := range tests {
+		config := test.config
+		config.SourceDirs = []string{sourceDir}
+		if err := validateConfig(&config); err == nil {
+			t.Errorf("%s: expected a configuration error", test.name)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// preservedFileNameTag keeps the name a file had before -rename
+const preservedFileNameTag = "XMP:PreservedFileName"
+
+// burstCounterFormat is appended to the names of files -rename names alike
+const burstCounterFormat = "_%d"
+
+// parseRenameTemplate parses a -rename template: a layout of the file name
+// alone, using the same tokens
+func parseRenameTemplate(template string) (*Layout, error) {
+	if strings.ContainsAny(template, `/\`) {
+		return nil, fmt.Errorf("rename template %q can't contain folders, use -layout for them", template)
+	}
+	return parseLayout(template)
+}
+
+// renamedFileName returns the name -rename gives file, named destName so far
+func renamedFileName(config *Config, file MediaFile, match *SidecarMatch, tags map[string]string, destName string, date time.Time) (string, error) {
+	return config.Rename.render(newLayoutValues(config, config.Rename, file, match, tags, destName, date))
+}
+
+// numberBursts gives the files -rename named alike, such as burst shots taken
+// in the same second, a counter in the order of their original names, so the
+// same Takeout always gives the same names whatever order workers finished
+// in. Files were moved to claimed "(n)" paths while processing and are
+// renamed again here, along with their album entries and the edited copies
+// grouped with them.
+func numberBursts(config *Config, results []Result) {
+	groups := make(map[string][]int)
+	grouped := make(map[string][]int)
+	for i, result := range results {
+		if result.Success && result.RenameTarget != "" && result.DestPath != "" {
+			groups[result.RenameTarget] = append(groups[result.RenameTarget], i)
+		}
+		if result.Success && result.GroupedWith != "" {
+			grouped[result.GroupedWith] = append(grouped[result.GroupedWith], i)
+		}
+	}
+
+	targets := make([]string, 0, len(groups))
+	for target, members := range groups {
+		if len(members) > 1 {
+			targets = append(targets, target)
+		}
+	}
+	sort.Strings(targets)
+
+	for _, target := range targets {
+		members := groups[target]
+		sort.Slice(members, func(a, b int) bool {
+			fileA, fileB := results[members[a]].File, results[members[b]].File
+			if fileA.BaseName != fileB.BaseName {
+				return fileA.BaseName < fileB.BaseName
+			}
+			return fileA.SourcePath() < fileB.SourcePath()
+		})
+
+		ext := filepath.Ext(target)
+		base := strings.TrimSuffix(target, ext)
+		for n, i := range members {
+			results[i] = renameBurstMember(config, results[i], claimDestinationPath(base+fmt.Sprintf(burstCounterFormat, n+1)+ext))
+			if copies := grouped[results[i].File.Path]; len(copies) > 0 && results[i].Success {
+				config.OriginalDests[results[i].File.Path] = results[i].DestPath
+				for _, j := range copies {
+					results[j] = renameBurstMember(config, results[j], groupedDestination(config, results[j].File, filepath.Base(results[j].DestPath)))
+				}
+			}
+		}
+	}
+}
+
+// renameBurstMember moves the file of result to destPath and renames its album
+// entries after it
+func renameBurstMember(config *Config, result Result, destPath string) Result {
+	if config.DryRun {
+		result.DestPath = destPath
+		result.Action += fmt.Sprintf(" | Would number burst: %s", filepath.Base(destPath))
+		return result
+	}
+
+	if err := moveFile(result.DestPath, destPath); err != nil {
+		result.Success = false
+		result.Error = fmt.Errorf("failed to number burst shot: %v", err)
+		return result
+	}
+	result.DestPath = destPath
+	result.Action += fmt.Sprintf(" | Numbered burst: %s", filepath.Base(destPath))
+
+	for i, albumLink := range result.AlbumLinks {
+		entryPath := claimAlbumEntryPath(filepath.Join(filepath.Dir(albumLink), filepath.Base(destPath)), destPath)
+		if err := moveAlbumEntry(config.Albums.Link, albumLink, entryPath, destPath); err != nil {
+			result.Success = false
+			result.Error = fmt.Errorf("failed to update album entry of burst shot: %v", err)
+			return result
+		}
+		result.AlbumLinks[i] = entryPath
+	}
+	return result
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenameWithBursts(t *testing.T) {
+	saveSupportedExtensions(t)
+	setSupportedExtensions([]string{".jpg"}, extSourceFallback)
+
+	sourceDir := t.TempDir()
+	outputDir := t.TempDir()
+	album := filepath.Join(sourceDir, "Trip")
+	dates := map[string]string{
+		"Trip/IMG_0003.jpg":   "2019:04:12 15:30:12", // Burst, last by name
+		"IMG_0001.jpg":        "2019:04:12 15:30:12", // Burst, first by name
+		"IMG_0002.jpg":        "2019:04:12 15:30:12",
+		"IMG_0004.jpg":        "2019:04:12 15:30:13", // Alone in its second
+		"Screenshot_0005.jpg": "2019:04:12 15:30:14", // No camera model
+	}
+	for name := range dates {
+		writeMedia(t, filepath.Join(sourceDir, filepath.FromSlash(name)))
+	}
+	if err := os.WriteFile(filepath.Join(album, "metadata.json"), []byte(`{"title": "Trip"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &Config{SourceDirs: []string{sourceDir}, Move: outputDir, Workers: 3, BatchSize: 1,
+		RenameTemplate: "{date:2006-01-02_15-04-05}_{model}{ext}"}
+	if err := validateConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	mediaFiles, idx, err := scanTakeout(sourceDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Sidecars = idx
+
+	backend := NewMemoryBackend()
+	for name, date := range dates {
+		tags := map[string]string{"DateTimeOriginal": date, "Model": "Pixel4"}
+		if name == "Screenshot_0005.jpg" {
+			delete(tags, "Model")
+		}
+		backend.SetTags(filepath.Join(sourceDir, filepath.FromSlash(name)), tags)
+	}
+	results := processFiles(config, []MetadataBackend{backend, backend, backend}, mediaFiles)
+
+	destByName := make(map[string]string)
+	for _, result := range results {
+		if !result.Success {
+			t.Fatalf("%s: %v", result.File.Path, result.Error)
+		}
+		destByName[result.File.BaseName] = filepath.Base(result.DestPath)
+	}
+	expected := map[string]string{
+		"IMG_0001.jpg":        "2019-04-12_15-30-12_Pixel4_1.jpg",
+		"IMG_0002.jpg":        "2019-04-12_15-30-12_Pixel4_2.jpg",
+		"IMG_0003.jpg":        "2019-04-12_15-30-12_Pixel4_3.jpg",
+		"IMG_0004.jpg":        "2019-04-12_15-30-13_Pixel4.jpg",
+		"Screenshot_0005.jpg": "2019-04-12_15-30-14.jpg",
+	}
+	for name, destName := range expected {
+		if destByName[name] != destName {
+			t.Errorf("Expected %s to be renamed %s, got %s", name, destName, destByName[name])
+		}
+	}
+
+	dayDir := filepath.Join(outputDir, "ALL_PHOTOS", "2019", "04", "12")
+	entries, err := os.ReadDir(dayDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(expected) {
+		t.Errorf("Expected only the renamed files in %s, got %d entries", dayDir, len(entries))
+	}
+
+	// The album symlink follows the burst numbering
+	link := generateAlbumSymlinkPath(outputDir, "Trip", expected["IMG_0003.jpg"])
+	if target, err := filepath.EvalSymlinks(link); err != nil || filepath.Base(target) != expected["IMG_0003.jpg"] {
+		t.Errorf("Expected the album symlink to follow the burst counter, got %s, %v", target, err)
+	}
+	if _, err := os.Lstat(generateAlbumSymlinkPath(outputDir, "Trip", "2019-04-12_15-30-12_Pixel4.jpg")); !os.IsNotExist(err) {
+		t.Errorf("Expected the symlink of the provisional name to be removed, got %v", err)
+	}
+
+	// The original name is kept in XMP
+	renamed := filepath.Join(dayDir, expected["IMG_0004.jpg"])
+	if got := backend.Tags(renamed)[preservedFileNameTag]; got != "IMG_0004.jpg" {
+		t.Errorf("Expected %s to be IMG_0004.jpg, got %q", preservedFileNameTag, got)
+	}
+}
+
+func TestRenameWithoutPreservedName(t *testing.T) {
+	saveSupportedExtensions(t)
+	setSupportedExtensions([]string{".jpg", ".avi"}, extSourceFallback)
+
+	sourceDir := t.TempDir()
+	outputDir := t.TempDir()
+	dates := map[string]string{
+		"VID_0001.avi": "2019:04:12 15:30:12", // ExifTool can't write AVI
+		"IMG_0002.jpg": "2019:04:12 15:30:13", // The write fails
+	}
+	for name := range dates {
+		writeMedia(t, filepath.Join(sourceDir, name))
+	}
+
+	config := &Config{SourceDirs: []string{sourceDir}, Move: outputDir, Workers: 1, BatchSize: 1,
+		RenameTemplate: "{date:2006-01-02_15-04-05}{ext}"}
+	if err := validateConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	mediaFiles, idx, err := scanTakeout(sourceDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Sidecars = idx
+
+	backend := NewMemoryBackend()
+	for name, date := range dates {
+		backend.SetTags(filepath.Join(sourceDir, name), map[string]string{"DateTimeOriginal": date})
+	}
+	dayDir := filepath.Join(outputDir, "ALL_PHOTOS", "2019", "04", "12")
+	backend.SetError(filepath.Join(dayDir, "2019-04-12_15-30-13.jpg"), errors.New("file is read-only"))
+	results := processFiles(config, []MetadataBackend{backend}, mediaFiles)
+
+	// The files are renamed and moved anyway, with a warning
+	for _, result := range results {
+		if !result.Success {
+			t.Errorf("%s: %v", result.File.BaseName, result.Error)
+			continue
+		}
+		if _, err := os.Stat(result.DestPath); err != nil {
+			t.Errorf("Expected %s to be moved: %v", result.File.BaseName, err)
+		}
+		if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0].Message, "XMP tags not written") {
+			t.Errorf("Expected a warning that %s has no preserved name, got %v", result.File.BaseName, result.Warnings)
+		}
+	}
+	if tags := backend.Tags(filepath.Join(dayDir, "2019-04-12_15-30-12.avi")); len(tags) != 0 {
+		t.Errorf("Expected nothing written to the AVI, got %v", tags)
+	}
+}
+
+func TestParseRenameTemplate(t *testing.T) {
+	if _, err := parseRenameTemplate("{date:2006-01-02_15-04-05}_{model}{ext}"); err != nil {
+		t.Error(err)
+	}
+	for _, template := range []string{"{year}/{name}{ext}", "{year}\\{name}{ext}", "{name}"} {
+		if _, err := parseRenameTemplate(template); err == nil {
+			t.Errorf("parseRenameTemplate(%q): expected an error", template)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Report files written to the output directory after a run
+const (
+	orphanSidecarsReport = "orphan-sidecars.json"
+	unmatchedMediaReport = "unmatched-media.json"
+)
+
+// Reasons a sidecar is reported as orphaned
+const (
+	orphanUnclaimed = "unclaimed" // No media file matched it, e.g. it's in another zip part
+	orphanAmbiguous = "ambiguous" // Several media files matched it equally well
+)
+
+// Reasons a media file is reported as unmatched
+const (
+	unmatchedNoSidecar   = "no sidecar"           // Dated from EXIF, but no sidecar matched
+	unmatchedNoDate      = "no date"              // Neither EXIF nor a sidecar gave a date
+	unmatchedAmbiguous   = "ambiguous sidecar"    // Its sidecar was matched by other files too
+	unmatchedInvalidDate = "invalid sidecar date" // The sidecar's photoTakenTime couldn't be parsed
+)
+
+// OrphanSidecar is a Google Photos sidecar that wasn't given to any media file
+type OrphanSidecar struct {
+	Path           string   `json:"path"`
+	Title          string   `json:"title,omitempty"`
+	PhotoTakenTime string   `json:"photo_taken_time,omitempty"`
+	Reason         string   `json:"reason"`
+	Candidates     []string `json:"candidates,omitempty"`
+}
+
+// UnmatchedMedia is a media file without a sidecar or without any date
+type UnmatchedMedia struct {
+	Path     string `json:"path"`
+	Reason   string `json:"reason"`
+	DestPath string `json:"dest_path,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Orphans returns the Google Photos sidecars not assigned to any media file,
+// sorted by path
+func (idx *SidecarIndex) Orphans() []OrphanSidecar {
+	assigned := make(map[*Sidecar]bool)
+	for _, match := range idx.matches {
+		if match != nil {
+			assigned[match.Sidecar] = true
+		}
+	}
+	ambiguous := make(map[*Sidecar]bool)
+	for _, ambiguity := range idx.ambiguities {
+		ambiguous[ambiguity.Sidecar] = true
+	}
+
+	var orphans []OrphanSidecar
+	for _, dir := range idx.dirs {
+		for _, name := range dir.names {
+			sidecar := dir.sidecars[name]
+			if !sidecar.Valid || assigned[sidecar] {
+				continue
+			}
+
+			orphan := OrphanSidecar{Path: sidecar.Path, Title: sidecar.Title, Reason: orphanUnclaimed}
+			if sidecar.DateErr == nil {
+				orphan.PhotoTakenTime = sidecar.Date.UTC().Format(time.RFC3339)
+			}
+			if ambiguous[sidecar] {
+				orphan.Reason = orphanAmbiguous
+				orphan.Candidates = sidecar.Candidates
+			}
+			orphans = append(orphans, orphan)
+		}
+	}
+	sort.Slice(orphans, func(i, j int) bool { return orphans[i].Path < orphans[j].Path })
+	return orphans
+}
+
+// unmatchedMedia returns the processed files that had no sidecar or no date,
+// sorted by path
+func unmatchedMedia(config *Config, results []Result) []UnmatchedMedia {
+	var unmatched []UnmatchedMedia
+	for _, result := range results {
+		entry := UnmatchedMedia{Path: result.File.SourcePath(), DestPath: result.DestPath}
+		if config.OutputArchive != nil && entry.DestPath != "" {
+			entry.DestPath = config.OutputArchive.entryPath(entry.DestPath)
+		}
+		switch {
+		case errors.Is(result.Error, ErrNoCreationDate):
+			entry.Reason = unmatchedNoDate
+		case errors.Is(result.Error, ErrAmbiguousSidecar):
+			entry.Reason = unmatchedAmbiguous
+		case errors.Is(result.Error, ErrInvalidSidecarDate):
+			entry.Reason = unmatchedInvalidDate
+		case result.Success && result.SidecarMatch == nil && config.Sidecars != nil && config.Sidecars.Match(result.File) == nil:
+			entry.Reason = unmatchedNoSidecar
+		default:
+			continue
+		}
+		if result.Error != nil {
+			entry.Error = result.Error.Error()
+		}
+		unmatched = append(unmatched, entry)
+	}
+	sort.Slice(unmatched, func(i, j int) bool { return unmatched[i].Path < unmatched[j].Path })
+	return unmatched
+}
+
+// writeReports writes the orphan sidecar and unmatched media reports to the
+// output directory and prints where they went
+func writeReports(config *Config, results []Result) error {
+	orphans := config.Sidecars.Orphans()
+	unmatched := unmatchedMedia(config, results)
+
+	fmt.Printf("Orphan sidecars: %d\n", len(orphans))
+	fmt.Printf("Media without sidecar or date: %d\n", len(unmatched))
+	if config.DryRun {
+		return nil
+	}
+
+	reports := []struct {
+		name string
+		data any
+	}{
+		{orphanSidecarsReport, orphans},
+		{unmatchedMediaReport, unmatched},
+	}
+	for _, report := range reports {
+		path := filepath.Join(config.OutputDir, report.name)
+		if err := writeJSONReport(path, report.data); err != nil {
+			return fmt.Errorf("failed to write %s: %v", path, err)
+		}
+		fmt.Printf("Report written: %s\n", path)
+	}
+	return nil
+}
+
+// writeJSONReport writes data as indented JSON, an empty list for no entries
+func writeJSONReport(path string, data any) error {
+	encoded, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	if string(encoded) == "null" {
+		encoded = []byte("[]")
+	}
+	return os.WriteFile(path, append(encoded, '\n'), 0644)
+}
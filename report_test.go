@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteReports(t *testing.T) {
+	sourceDir := t.TempDir()
+	outputDir := t.TempDir()
+
+	writeMedia(t, filepath.Join(sourceDir, "dated.jpg"))
+	writeSidecar(t, filepath.Join(sourceDir, "dated.jpg.json"), "dated.jpg", 1555083012)
+	writeMedia(t, filepath.Join(sourceDir, "exif.jpg"))
+	writeMedia(t, filepath.Join(sourceDir, "undated.jpg"))
+	// The media of this sidecar ended up in another zip part
+	writeSidecar(t, filepath.Join(sourceDir, "elsewhere.jpg.supplemental-metadata.json"), "elsewhere.jpg", 1555083013)
+	if err := os.WriteFile(filepath.Join(sourceDir, "metadata.json"), []byte(`{"title": "Album"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	saveSupportedExtensions(t)
+	setSupportedExtensions([]string{".jpg"}, extSourceFallback)
+	mediaFiles, idx, err := scanTakeout(sourceDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend := NewMemoryBackend()
+	backend.SetTags(filepath.Join(sourceDir, "exif.jpg"), map[string]string{"DateTimeOriginal": "2019:04:12 15:30:12"})
+	config := &Config{SourceDir: sourceDir, OutputDir: outputDir, Workers: 1, BatchSize: 1, Sidecars: idx}
+	results := processFiles(config, []MetadataBackend{backend}, mediaFiles)
+
+	if err := writeReports(config, results); err != nil {
+		t.Fatal(err)
+	}
+
+	var orphans []OrphanSidecar
+	readJSONReport(t, filepath.Join(outputDir, orphanSidecarsReport), &orphans)
+	if len(orphans) != 1 || filepath.Base(orphans[0].Path) != "elsewhere.jpg.supplemental-metadata.json" ||
+		orphans[0].Reason != orphanUnclaimed || orphans[0].Title != "elsewhere.jpg" || orphans[0].PhotoTakenTime != "2019-04-12T15:30:13Z" {
+		t.Errorf("Expected only the unclaimed sidecar, got %+v", orphans)
+	}
+
+	var unmatched []UnmatchedMedia
+	readJSONReport(t, filepath.Join(outputDir, unmatchedMediaReport), &unmatched)
+	expected := map[string]string{
+		"exif.jpg":    unmatchedNoSidecar,
+		"undated.jpg": unmatchedNoDate,
+	}
+	if len(unmatched) != len(expected) {
+		t.Errorf("Expected %d unmatched files, got %+v", len(expected), unmatched)
+	}
+	for _, entry := range unmatched {
+		if reason := expected[filepath.Base(entry.Path)]; entry.Reason != reason {
+			t.Errorf("Expected %s to be reported as %q, got %q", entry.Path, reason, entry.Reason)
+		}
+	}
+
+	// A dry run only counts
+	dryRunOutput := t.TempDir()
+	config.OutputDir, config.DryRun = dryRunOutput, true
+	if err := writeReports(config, results); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dryRunOutput, orphanSidecarsReport)); !os.IsNotExist(err) {
+		t.Errorf("Expected no report written in a dry run, got %v", err)
+	}
+}
+
+// readJSONReport decodes the report at path into v
+func readJSONReport(t *testing.T, path string, v any) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("Report %s isn't valid JSON: %v", path, err)
+	}
+}
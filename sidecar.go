@@ -0,0 +1,840 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// numberSuffixRegex matches names ending in a duplicate counter such as "(1)"
+var numberSuffixRegex = regexp.MustCompile(`^(.+)\((\d+)\)$`)
+
+// fuzzySidecarMatching enables matching sidecars by arbitrary prefixes and
+// truncated extensions of the media file name. These fallbacks find sidecars
+// Takeout named unusually but can pick the wrong one among similar names.
+var fuzzySidecarMatching bool
+
+// Sidecar is a JSON file found next to media files, parsed once when indexed
+type Sidecar struct {
+	Path string
+	Name string
+
+	// Valid is set for files that look like Google Photos sidecars
+	Valid   bool
+	Title   string
+	Date    time.Time
+	DateErr error // Set when the photoTakenTime couldn't be parsed
+
+	// Album holds the whole metadata.json of an album folder
+	Album *AlbumMetadata
+
+	// Candidates lists the paths of the media files this sidecar can belong to
+	Candidates []string
+}
+
+// ErrAmbiguousSidecar is returned for files whose sidecar was matched equally
+// well by another file of the same directory
+var ErrAmbiguousSidecar = errors.New("ambiguous sidecar match")
+
+// SidecarAmbiguity records a sidecar matched equally well by several media
+// files. It isn't given to any of them.
+type SidecarAmbiguity struct {
+	Sidecar  *Sidecar
+	Files    []string
+	Strength int
+}
+
+func (a *SidecarAmbiguity) String() string {
+	names := make([]string, len(a.Files))
+	for i, file := range a.Files {
+		names[i] = filepath.Base(file)
+	}
+	return fmt.Sprintf("%s is matched equally well by %s", a.Sidecar.Path, strings.Join(names, ", "))
+}
+
+// sidecarDir holds the sidecars of one directory, sorted by name so that
+// all names sharing a prefix can be found with a binary search. Names are
+// kept in NFC, whatever the normalization of the names on disk.
+type sidecarDir struct {
+	names    []string
+	sidecars map[string]*Sidecar
+
+	// titles maps the name Takeout gives a file with a sidecar's title, after
+	// truncation, to the names of the sidecars with that title
+	titles map[string][]string
+}
+
+// SidecarIndex maps each directory of a Takeout to its sidecars and each media
+// file to the sidecar matched to it. It is built once before processing and
+// only read afterwards, so workers can share it.
+//
+// Directories are keyed by their path relative to the source root containing
+// them, so the same album folder extracted from several Takeout parts is
+// matched as one directory.
+type SidecarIndex struct {
+	roots       []string
+	dirs        map[string]*sidecarDir
+	matches     map[string]*SidecarMatch
+	scanned     map[string]bool
+	ambiguous   map[string]*SidecarAmbiguity
+	ambiguities []*SidecarAmbiguity
+}
+
+// sidecarPattern is a sidecar name pattern with the literal prefix every
+// matching name starts with
+type sidecarPattern struct {
+	prefix   string
+	strength int
+	method   string
+	match    func(name string) bool
+}
+
+// takeoutScan collects the media files and JSON files of the source roots
+// before they are indexed together
+type takeoutScan struct {
+	roots      []string
+	mediaFiles []MediaFile
+	sidecars   []*Sidecar
+}
+
+// scanTakeout walks each source root once, collecting the supported media
+// files and indexing every JSON file found along the way
+func scanTakeout(sourceDirs ...string) ([]MediaFile, *SidecarIndex, error) {
+	scan := &takeoutScan{}
+	for _, sourceDir := range sourceDirs {
+		if err := scan.walkDir(sourceDir); err != nil {
+			return nil, nil, err
+		}
+	}
+	return scan.mediaFiles, scan.index(), nil
+}
+
+// walkDir adds the media files and JSON files found under root
+func (s *takeoutScan) walkDir(root string) error {
+	s.roots = append(s.roots, root)
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		if strings.HasSuffix(d.Name(), ".json") {
+			s.sidecars = append(s.sidecars, newSidecar(path))
+			return nil
+		}
+
+		ext := strings.ToLower(filepath.Ext(path))
+		if supportedExts[ext] {
+			s.mediaFiles = append(s.mediaFiles, MediaFile{
+				Path:     path,
+				BaseName: filepath.Base(path),
+				Dir:      filepath.Dir(path),
+			})
+		}
+
+		return nil
+	})
+}
+
+// index builds the sidecar index of everything scanned
+func (s *takeoutScan) index() *SidecarIndex {
+	return buildSidecarIndex(s.roots, s.sidecars, s.mediaFiles)
+}
+
+// buildSidecarIndex assigns the sidecars of each directory to its media files.
+// Sidecars and media files under different roots at the same relative path
+// are matched together.
+func buildSidecarIndex(roots []string, sidecars []*Sidecar, mediaFiles []MediaFile) *SidecarIndex {
+	idx := &SidecarIndex{
+		roots:     roots,
+		dirs:      make(map[string]*sidecarDir),
+		matches:   make(map[string]*SidecarMatch),
+		scanned:   make(map[string]bool),
+		ambiguous: make(map[string]*SidecarAmbiguity),
+	}
+
+	for _, sidecar := range sidecars {
+		dir := idx.dirKey(filepath.Dir(sidecar.Path))
+		if idx.dirs[dir] == nil {
+			idx.dirs[dir] = &sidecarDir{sidecars: make(map[string]*Sidecar)}
+		}
+		// A JSON file present in several parts is only indexed from the first
+		if idx.dirs[dir].sidecars[normalizeName(sidecar.Name)] == nil {
+			idx.dirs[dir].add(sidecar)
+		}
+	}
+	for _, dir := range idx.dirs {
+		dir.finish()
+	}
+
+	// Group media files by directory, keeping the scan order
+	var dirOrder []string
+	filesByDir := make(map[string][]MediaFile)
+	for _, file := range mediaFiles {
+		idx.scanned[file.Path] = true
+		dir := idx.dirKey(file.Dir)
+		if idx.dirs[dir] == nil {
+			continue
+		}
+		if filesByDir[dir] == nil {
+			dirOrder = append(dirOrder, dir)
+		}
+		filesByDir[dir] = append(filesByDir[dir], file)
+	}
+	for _, dir := range dirOrder {
+		idx.assign(idx.dirs[dir], filesByDir[dir])
+	}
+
+	return idx
+}
+
+// sidecarClaim is a media file claiming a sidecar during assignment
+type sidecarClaim struct {
+	path  string
+	match *SidecarMatch
+}
+
+// assign gives each sidecar of a directory to at most one media file. Matches
+// are handed out from the strongest down; a sidecar claimed by several files
+// at the same strength is reported as ambiguous and given to none of them.
+// Edited copies then share the sidecar of their original.
+func (idx *SidecarIndex) assign(dir *sidecarDir, files []MediaFile) {
+	candidates := make([][]SidecarMatch, len(files))
+	levelSet := make(map[int]bool)
+	for i, file := range files {
+		candidates[i] = dir.candidates(file)
+		for _, match := range candidates[i] {
+			match.Sidecar.Candidates = append(match.Sidecar.Candidates, file.Path)
+			if !match.Shared {
+				levelSet[match.Strength] = true
+			}
+		}
+	}
+
+	levels := make([]int, 0, len(levelSet))
+	for level := range levelSet {
+		levels = append(levels, level)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(levels)))
+
+	taken := make(map[*Sidecar]bool)
+	for _, level := range levels {
+		// Each unassigned file claims its first free sidecar at this strength
+		var claimed []*Sidecar
+		claims := make(map[*Sidecar][]sidecarClaim)
+		for i, file := range files {
+			if idx.matches[file.Path] != nil {
+				continue
+			}
+			for j, match := range candidates[i] {
+				if !match.Shared && match.Strength == level && !taken[match.Sidecar] {
+					if claims[match.Sidecar] == nil {
+						claimed = append(claimed, match.Sidecar)
+					}
+					claims[match.Sidecar] = append(claims[match.Sidecar], sidecarClaim{path: file.Path, match: &candidates[i][j]})
+					break
+				}
+			}
+		}
+
+		for _, sidecar := range claimed {
+			taken[sidecar] = true
+			claimants := claims[sidecar]
+			if len(claimants) == 1 {
+				idx.matches[claimants[0].path] = claimants[0].match
+				continue
+			}
+
+			ambiguity := &SidecarAmbiguity{Sidecar: sidecar, Strength: level}
+			for _, claim := range claimants {
+				ambiguity.Files = append(ambiguity.Files, claim.path)
+				idx.ambiguous[claim.path] = ambiguity
+			}
+			idx.ambiguities = append(idx.ambiguities, ambiguity)
+		}
+	}
+
+	for i, file := range files {
+		if idx.matches[file.Path] != nil {
+			continue
+		}
+		for j, match := range candidates[i] {
+			if match.Shared {
+				idx.matches[file.Path] = &candidates[i][j]
+				break
+			}
+		}
+	}
+}
+
+// Lookup returns the sidecar assigned to file, or nil if it has none
+func (idx *SidecarIndex) Lookup(file MediaFile) *Sidecar {
+	if match := idx.Match(file); match != nil {
+		return match.Sidecar
+	}
+	return nil
+}
+
+// Match returns how the sidecar assigned to file was matched, or nil if it has none
+func (idx *SidecarIndex) Match(file MediaFile) *SidecarMatch {
+	if match, ok := idx.matches[file.Path]; ok || idx.scanned[file.Path] {
+		return match
+	}
+	// Files that weren't part of the scan are matched on demand
+	if dir := idx.dirs[idx.dirKey(file.Dir)]; dir != nil {
+		return dir.bestMatch(file)
+	}
+	return nil
+}
+
+// Ambiguity returns the ambiguous match that left file without a sidecar, if any
+func (idx *SidecarIndex) Ambiguity(file MediaFile) *SidecarAmbiguity {
+	if idx.matches[file.Path] != nil {
+		return nil
+	}
+	return idx.ambiguous[file.Path]
+}
+
+// Ambiguities returns every sidecar that was matched equally well by several files
+func (idx *SidecarIndex) Ambiguities() []*SidecarAmbiguity {
+	return idx.ambiguities
+}
+
+// Sidecar returns the indexed sidecar at path, or nil if it wasn't indexed
+func (idx *SidecarIndex) Sidecar(path string) *Sidecar {
+	if sidecar := idx.SidecarIn(filepath.Dir(path), filepath.Base(path)); sidecar != nil && sidecar.Path == path {
+		return sidecar
+	}
+	return nil
+}
+
+// SidecarIn returns the JSON file called name indexed for dir, which may lie
+// in another source root than dir itself, or nil if there is none
+func (idx *SidecarIndex) SidecarIn(dir, name string) *Sidecar {
+	if d := idx.dirs[idx.dirKey(dir)]; d != nil {
+		return d.sidecars[normalizeName(name)]
+	}
+	return nil
+}
+
+// dirKey returns the key of dir in the index: its path relative to the first
+// source root containing it, or dir itself if no root contains it
+func (idx *SidecarIndex) dirKey(dir string) string {
+	for _, root := range idx.roots {
+		rel, err := filepath.Rel(root, dir)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return rel
+		}
+	}
+	return dir
+}
+
+// Len returns the number of indexed JSON files
+func (idx *SidecarIndex) Len() int {
+	n := 0
+	for _, dir := range idx.dirs {
+		n += len(dir.names)
+	}
+	return n
+}
+
+// lookupSidecar returns the sidecar for file from the index, falling back to
+// indexing the file's directory when no index was built. A file left without
+// a sidecar because of an ambiguous match gets an ErrAmbiguousSidecar error.
+func lookupSidecar(config *Config, file MediaFile) (*SidecarMatch, error) {
+	if config.Sidecars == nil {
+		return indexSidecarDir(file.Dir).bestMatch(file), nil
+	}
+	if ambiguity := config.Sidecars.Ambiguity(file); ambiguity != nil {
+		return nil, fmt.Errorf("%w: %s", ErrAmbiguousSidecar, ambiguity)
+	}
+	return config.Sidecars.Match(file), nil
+}
+
+// indexSidecarDir reads and parses the JSON files of a single directory
+func indexSidecarDir(path string) *sidecarDir {
+	dir := &sidecarDir{sidecars: make(map[string]*Sidecar)}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return dir
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			dir.add(newSidecar(filepath.Join(path, entry.Name())))
+		}
+	}
+	dir.finish()
+	return dir
+}
+
+// newSidecar reads and parses the JSON file at path
+func newSidecar(path string) *Sidecar {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return &Sidecar{Path: path, Name: filepath.Base(path), DateErr: err}
+	}
+	return parseSidecar(path, data)
+}
+
+// parseSidecar parses the content of the JSON file at path. The title is
+// kept for any JSON file, so album metadata.json files carry the album title.
+func parseSidecar(path string, data []byte) *Sidecar {
+	sidecar := &Sidecar{Path: path, Name: filepath.Base(path)}
+
+	sidecar.Valid = isGooglePhotosSidecarContent(data)
+	if sidecar.Valid {
+		var parsed SidecarData
+		parsed, sidecar.Date, sidecar.DateErr = parseSidecarData(data)
+		sidecar.Title = parsed.Title
+	} else if metadata := parseAlbumMetadata(data); metadata != nil {
+		sidecar.Title = metadata.Title
+		if normalizeName(sidecar.Name) == "metadata.json" {
+			sidecar.Album = metadata
+		}
+	}
+	return sidecar
+}
+
+func (d *sidecarDir) add(sidecar *Sidecar) {
+	name := normalizeName(sidecar.Name)
+	d.names = append(d.names, name)
+	d.sidecars[name] = sidecar
+}
+
+// finish sorts the names and indexes the sidecars by the truncated name of
+// their title once all sidecars of the directory were added
+func (d *sidecarDir) finish() {
+	sort.Strings(d.names)
+	d.titles = make(map[string][]string)
+	for _, name := range d.names {
+		if sidecar := d.sidecars[name]; sidecar.Valid && sidecar.Title != "" {
+			key := truncatedTakeoutName(normalizeName(sidecar.Title))
+			d.titles[key] = append(d.titles[key], name)
+		}
+	}
+}
+
+// withPrefix returns the sidecar names starting with prefix, in sorted order
+func (d *sidecarDir) withPrefix(prefix string) []string {
+	start := sort.SearchStrings(d.names, prefix)
+	end := start
+	for end < len(d.names) && strings.HasPrefix(d.names[end], prefix) {
+		end++
+	}
+	return d.names[start:end]
+}
+
+// Strengths of the sidecar name patterns. When several media files claim the
+// same sidecar, the strongest match wins.
+const (
+	strengthLiteral            = 100
+	strengthLiteralUnderscore  = 95
+	strengthLiteralDoubleDot   = 92
+	strengthBase               = 90
+	strengthTitle              = 88
+	strengthTakeoutTruncation  = 80
+	strengthTruncatedMedia     = 75
+	strengthBaseUnderscore     = 85
+	strengthBaseDoubleDot      = 82
+	strengthPrefixMax          = 60 // Prefix truncation, scaled down to strengthPrefixMin
+	strengthPrefixMin          = 40
+	strengthExtensionTruncated = 30
+)
+
+// SidecarMatch is a candidate sidecar for a media file. Method describes how
+// the sidecar name was derived from the media file name. Shared matches come
+// from edited copies, which reuse the sidecar of their original instead of
+// competing for it.
+type SidecarMatch struct {
+	Sidecar  *Sidecar
+	Strength int
+	Method   string
+	Shared   bool
+}
+
+// Confidence returns the strength of the match between 0 and 1
+func (m *SidecarMatch) Confidence() float64 {
+	return float64(m.Strength) / strengthLiteral
+}
+
+func (m *SidecarMatch) String() string {
+	return fmt.Sprintf("%s, confidence %.2f", m.Method, m.Confidence())
+}
+
+// matchMethod names how a sidecar name was derived from the media file name,
+// e.g. "numbered suffix + prefix truncation at 12 chars". edited is the edited
+// suffix stripped from the name, if any.
+func matchMethod(edited string, numbered bool, detail string) string {
+	var parts []string
+	if edited != "" {
+		parts = append(parts, edited+" strip")
+	}
+	if numbered {
+		parts = append(parts, "numbered suffix")
+	}
+	if detail != "" {
+		parts = append(parts, detail)
+	}
+	if len(parts) == 0 {
+		return "literal"
+	}
+	return strings.Join(parts, " + ")
+}
+
+// matchCollector gathers the candidates of one media file in the order the
+// patterns are tried, keeping only the first match of each sidecar
+type matchCollector struct {
+	dir     *sidecarDir
+	matches []SidecarMatch
+	seen    map[*Sidecar]bool
+}
+
+func (c *matchCollector) add(sidecar *Sidecar, strength int, method string, shared bool) {
+	if c.seen[sidecar] {
+		return
+	}
+	c.seen[sidecar] = true
+	c.matches = append(c.matches, SidecarMatch{Sidecar: sidecar, Strength: strength, Method: method, Shared: shared})
+}
+
+// collect adds every valid sidecar matching the patterns, tried in order
+func (c *matchCollector) collect(patterns []sidecarPattern, shared bool) {
+	for _, pattern := range patterns {
+		for _, name := range c.dir.withPrefix(pattern.prefix) {
+			if sidecar := c.dir.sidecars[name]; pattern.match(name) && sidecar.Valid {
+				c.add(sidecar, pattern.strength, pattern.method, shared)
+			}
+		}
+	}
+}
+
+// dottedPattern matches "<base>.json", "<base><suffix>.json" and
+// "<base>.<anything><suffix>.json". Without a suffix, names ending in a
+// duplicate counter are left to the file with that counter.
+func dottedPattern(base, suffix string, strength int, method string) sidecarPattern {
+	tail := suffix + ".json"
+	return sidecarPattern{prefix: base, strength: strength, method: method, match: func(name string) bool {
+		rest := name[len(base):]
+		if suffix == "" && numberSuffixRegex.MatchString(strings.TrimSuffix(rest, ".json")) {
+			return false
+		}
+		return rest == tail || (len(rest) > len(tail) && rest[0] == '.' && strings.HasSuffix(rest, tail))
+	}}
+}
+
+// exactPattern matches a single sidecar name
+func exactPattern(full string, strength int, method string) sidecarPattern {
+	return sidecarPattern{prefix: full, strength: strength, method: method, match: func(name string) bool {
+		return name == full
+	}}
+}
+
+// loosePattern matches "<prefix><anything><suffix>.json"
+func loosePattern(prefix, suffix string, strength int, method string) sidecarPattern {
+	tail := suffix + ".json"
+	return sidecarPattern{prefix: prefix, strength: strength, method: method, match: func(name string) bool {
+		return len(name)-len(prefix) >= len(tail) && strings.HasSuffix(name, tail)
+	}}
+}
+
+// find returns the best sidecar for file considered on its own, ignoring
+// the other media files of the directory
+func (d *sidecarDir) find(file MediaFile) *Sidecar {
+	if match := d.bestMatch(file); match != nil {
+		return match.Sidecar
+	}
+	return nil
+}
+
+// bestMatch returns the strongest candidate for file, or nil if there is none
+func (d *sidecarDir) bestMatch(file MediaFile) *SidecarMatch {
+	if matches := d.candidates(file); len(matches) > 0 {
+		return &matches[0]
+	}
+	return nil
+}
+
+// candidates returns every sidecar that can belong to file, strongest first
+func (d *sidecarDir) candidates(file MediaFile) []SidecarMatch {
+	c := &matchCollector{dir: d, seen: make(map[*Sidecar]bool)}
+	file.BaseName = normalizeName(file.BaseName)
+	d.collectCandidates(file, c, "")
+	return c.matches
+}
+
+// collectCandidates tries the literal name first, then the name without its
+// duplicate counter, arbitrary truncation, a truncated extension and finally
+// the name without an edited suffix. edited is set to the edited suffix when
+// matching the original of an edited copy.
+func (d *sidecarDir) collectCandidates(file MediaFile, c *matchCollector, edited string) {
+	baseName := file.BaseName
+	ext := filepath.Ext(baseName)
+	baseNameNoExt := strings.TrimSuffix(baseName, ext)
+	shared := edited != ""
+
+	// Track if we had to strip an edited suffix for fallback
+	editedSuffix := ""
+	if original, suffix, counter, ok := splitEditedSuffix(baseNameNoExt); ok {
+		// Preserve the parenthetical suffix
+		baseNameNoExt = original + counter
+		editedSuffix = suffix
+	}
+
+	// --- 1. Try literal/whole-base-name matching first (including any parentheses) ---
+	literalNoExt := strings.TrimSuffix(baseName, ext)
+	literalPatterns := []sidecarPattern{dottedPattern(baseName, "", strengthLiteral, matchMethod(edited, false, ""))}
+	// Also include underscore-removal patterns for literal if applicable
+	if strings.HasSuffix(literalNoExt, "_") {
+		trimmed := strings.TrimSuffix(literalNoExt, "_")
+		method := matchMethod(edited, false, "underscore strip")
+		literalPatterns = append(literalPatterns,
+			dottedPattern(trimmed+ext, "", strengthLiteralUnderscore, method),
+			exactPattern(trimmed+".json", strengthLiteralUnderscore, method))
+	}
+	// Double-dot pattern (rare, but consistent with base name handling)
+	literalPatterns = append(literalPatterns, exactPattern(literalNoExt+"..json", strengthLiteralDoubleDot, matchMethod(edited, false, "double dot")))
+	c.collect(literalPatterns, shared)
+
+	// Everything below matches the original of an edited copy
+	if edited == "" {
+		edited = editedSuffix
+	}
+	shared = edited != ""
+
+	// --- 2. Fall back to the base filename with suffix-number handling ---
+	var baseForSidecar string
+	var numberSuffix string
+	if matches := numberSuffixRegex.FindStringSubmatch(baseNameNoExt); len(matches) == 3 {
+		baseForSidecar = matches[1] + ext // e.g., "IMG_456.jpg" from "IMG_456(1).jpg"
+		numberSuffix = "(" + matches[2] + ")"
+	} else {
+		baseForSidecar = baseNameNoExt + ext
+	}
+	sidecarExt := filepath.Ext(baseForSidecar)
+	sidecarNoExt := strings.TrimSuffix(baseForSidecar, sidecarExt)
+
+	// Handle trailing underscore removal edge case
+	baseForSidecarNoUnderscore := baseNameNoExt + ext
+	baseNameNoUnderscoreNoExt := ""
+	if strings.HasSuffix(sidecarNoExt, "_") {
+		baseForSidecarNoUnderscore = strings.TrimSuffix(sidecarNoExt, "_") + sidecarExt
+		baseNameNoUnderscoreNoExt = strings.TrimSuffix(sidecarNoExt, "_")
+	}
+
+	numbered := numberSuffix != ""
+	patterns := []sidecarPattern{dottedPattern(baseForSidecar, numberSuffix, strengthBase, matchMethod(edited, numbered, ""))}
+	if baseForSidecarNoUnderscore != baseForSidecar {
+		method := matchMethod(edited, numbered, "underscore strip")
+		patterns = append(patterns,
+			dottedPattern(baseForSidecarNoUnderscore, numberSuffix, strengthBaseUnderscore, method),
+			exactPattern(baseNameNoUnderscoreNoExt+numberSuffix+".json", strengthBaseUnderscore, method))
+	}
+	if numbered {
+		patterns = append(patterns, exactPattern(sidecarNoExt+"."+numberSuffix+".json", strengthBaseDoubleDot, matchMethod(edited, numbered, "double dot")))
+	} else {
+		patterns = append(patterns, exactPattern(sidecarNoExt+"..json", strengthBaseDoubleDot, matchMethod(edited, numbered, "double dot")))
+	}
+	c.collect(patterns, shared)
+
+	// Sidecars whose title, truncated the way Takeout truncates file names,
+	// gives the media file name
+	d.collectByTitle(baseForSidecar, numberSuffix, c, edited)
+
+	// Names derived with Takeout's own truncation rule
+	c.collect(takeoutTruncationPatterns(baseForSidecar, numberSuffix, matchMethod(edited, numbered, "takeout truncation")), shared)
+
+	// Try progressive prefix matching for arbitrary truncation
+	if fuzzySidecarMatching {
+		d.collectByPrefix(file, c, edited)
+	}
+
+	// --- Additional fallback: try matching where file extension is truncated before '.json' ---
+	if fuzzySidecarMatching && len(sidecarExt) > 1 { // ".j" or longer
+		for i := 2; i <= len(sidecarExt); i++ {
+			c.collect([]sidecarPattern{{
+				prefix:   sidecarNoExt + sidecarExt[:i],
+				strength: strengthExtensionTruncated,
+				method:   matchMethod(edited, numbered, "extension truncation"),
+				match: func(name string) bool {
+					return strings.HasSuffix(name, ".json")
+				},
+			}}, shared)
+		}
+	}
+
+	// --- Enhanced fallback for edited suffixes: retry all matching logic with the suffix removed ---
+	if editedSuffix != "" {
+		tempBaseName := baseNameNoExt + ext
+
+		// Prevent infinite recursion by ensuring we don't have another edited suffix
+		if _, _, _, ok := splitEditedSuffix(baseNameNoExt); !ok {
+			d.collectCandidates(MediaFile{Path: file.Path, BaseName: tempBaseName, Dir: file.Dir}, c, edited)
+		}
+	}
+}
+
+// Takeout names the sidecar of a file "<name>.supplemental-metadata.json",
+// or "<name>.json" in older exports, cutting the part before ".json" to
+// maxSidecarStemLength characters. A duplicate counter goes after the cut,
+// as in "<name>.supplemental-me(1).json".
+const (
+	supplementalMetadataSuffix = ".supplemental-metadata"
+	maxSidecarStemLength       = 46
+)
+
+// takeoutSidecarName returns the sidecar name Takeout derives from the
+// original media file name, a name suffix such as supplementalMetadataSuffix
+// and a duplicate counter
+func takeoutSidecarName(name, suffix, counter string) string {
+	return truncateRunes(name+suffix, maxSidecarStemLength) + counter + ".json"
+}
+
+// takeoutTruncationPatterns returns the sidecar names Takeout gives a media
+// file named name with a duplicate counter. A name at maxTakeoutNameLength was
+// itself truncated from a longer original, so only the part before its
+// extension is known and the sidecar stem must be exactly
+// maxSidecarStemLength long.
+func takeoutTruncationPatterns(name, counter, method string) []sidecarPattern {
+	var patterns []sidecarPattern
+	for _, suffix := range []string{"", supplementalMetadataSuffix} {
+		patterns = append(patterns, exactPattern(takeoutSidecarName(name, suffix, counter), strengthTakeoutTruncation, method))
+	}
+
+	if utf8.RuneCountInString(name) == maxTakeoutNameLength {
+		prefix := strings.TrimSuffix(name, filepath.Ext(name))
+		tail := counter + ".json"
+		patterns = append(patterns, sidecarPattern{prefix: prefix, strength: strengthTruncatedMedia, method: method, match: func(sidecarName string) bool {
+			stem, ok := strings.CutSuffix(sidecarName, tail)
+			return ok && utf8.RuneCountInString(stem) == maxSidecarStemLength
+		}})
+	}
+	return patterns
+}
+
+// collectByTitle matches sidecars by their title, which holds the original
+// name of the media file before Takeout truncated it. The duplicate counter
+// isn't part of the title, so it must match the counter in the sidecar name.
+func (d *sidecarDir) collectByTitle(baseName, numberSuffix string, c *matchCollector, edited string) {
+	method := matchMethod(edited, numberSuffix != "", "title")
+	shared := edited != ""
+	for _, name := range d.titles[baseName] {
+		if sidecarCounter(name) == numberSuffix {
+			c.add(d.sidecars[name], strengthTitle, method, shared)
+		}
+	}
+}
+
+// sidecarCounter returns the duplicate counter such as "(1)" at the end of a
+// sidecar name, or "" if it has none
+func sidecarCounter(name string) string {
+	if matches := numberSuffixRegex.FindStringSubmatch(strings.TrimSuffix(name, ".json")); len(matches) == 3 {
+		return "(" + matches[2] + ")"
+	}
+	return ""
+}
+
+// collectByPrefix matches sidecars whose names were truncated at an arbitrary
+// length, trying progressively shorter prefixes of the media file name
+func (d *sidecarDir) collectByPrefix(file MediaFile, c *matchCollector, edited string) {
+	shared := edited != ""
+	baseNameNoExt := strings.TrimSuffix(file.BaseName, filepath.Ext(file.BaseName))
+
+	// Handle numbered files
+	baseForMatching := baseNameNoExt
+	numberSuffix := ""
+	if matches := numberSuffixRegex.FindStringSubmatch(baseNameNoExt); len(matches) == 3 {
+		baseForMatching = matches[1]
+		numberSuffix = "(" + matches[2] + ")"
+	}
+
+	// Try progressively shorter prefixes (minimum 10 characters to avoid false positives)
+	minPrefixLength := min(10, len(baseForMatching))
+
+	for prefixLen := len(baseForMatching); prefixLen >= minPrefixLength; prefixLen-- {
+		// Never cut a multi-byte character in two
+		if prefixLen < len(baseForMatching) && !utf8.RuneStart(baseForMatching[prefixLen]) {
+			continue
+		}
+		// Longer prefixes are stronger matches
+		strength := strengthPrefixMin + (strengthPrefixMax-strengthPrefixMin)*prefixLen/max(len(baseForMatching), 1)
+		method := matchMethod(edited, numberSuffix != "", fmt.Sprintf("prefix truncation at %d chars", prefixLen))
+		pattern := loosePattern(baseForMatching[:prefixLen], numberSuffix, strength, method)
+
+		for _, name := range d.withPrefix(pattern.prefix) {
+			sidecar := d.sidecars[name]
+			if !pattern.match(name) || !sidecar.Valid {
+				continue
+			}
+
+			// Ensure the sidecar name is not too different in length
+			sidecarName := strings.TrimSuffix(name, ".json")
+			sidecarName = strings.TrimSuffix(sidecarName, numberSuffix)
+			// Allow up to 30% length difference to handle arbitrary truncation
+			maxLenDiff := len(baseForMatching) / 3
+			if lenDiff := len(baseForMatching) - len(sidecarName); lenDiff <= maxLenDiff && lenDiff >= 0 {
+				c.add(sidecar, strength, method, shared)
+			}
+		}
+	}
+}
+
+// findSidecarFile returns the path of the sidecar for file, or "" if none matches
+func findSidecarFile(file MediaFile) string {
+	if sidecar := indexSidecarDir(file.Dir).find(file); sidecar != nil {
+		return sidecar.Path
+	}
+	return ""
+}
+
+func isGooglePhotosSidecar(filePath string) bool {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return false
+	}
+	return isGooglePhotosSidecarContent(data)
+}
+
+// isGooglePhotosSidecarContent checks for the Google Photos sidecar structure
+func isGooglePhotosSidecarContent(data []byte) bool {
+	content := string(data)
+	return strings.Contains(content, "photoTakenTime") &&
+		strings.Contains(content, "timestamp") &&
+		(strings.Contains(content, "title") || len(content) < 1000) // Basic validation
+}
+
+func parseSidecarDate(sidecarPath string) (time.Time, error) {
+	data, err := os.ReadFile(sidecarPath)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	_, date, err := parseSidecarData(data)
+	return date, err
+}
+
+// parseSidecarData decodes a sidecar and its photoTakenTime
+func parseSidecarData(data []byte) (SidecarData, time.Time, error) {
+	var sidecar SidecarData
+	if err := json.Unmarshal(data, &sidecar); err != nil {
+		return sidecar, time.Time{}, err
+	}
+
+	timestamp, err := strconv.ParseInt(sidecar.PhotoTakenTime.Timestamp, 10, 64)
+	if err != nil {
+		return sidecar, time.Time{}, err
+	}
+
+	return sidecar, time.Unix(timestamp, 0), nil
+}
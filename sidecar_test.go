@@ -0,0 +1,396 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// writeSidecar writes a Google Photos sidecar with a title and timestamp
+func writeSidecar(t *testing.T, path, title string, timestamp int64) {
+	t.Helper()
+	content := `{"title": "` + title + `", "photoTakenTime": {"timestamp": "` + strconv.FormatInt(timestamp, 10) + `"}}`
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeMedia writes a placeholder media file, creating its directory
+func writeMedia(t *testing.T, path string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("media"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// setFuzzySidecarMatching enables or disables fuzzy matching for a test
+func setFuzzySidecarMatching(t *testing.T, enabled bool) {
+	t.Helper()
+	saved := fuzzySidecarMatching
+	fuzzySidecarMatching = enabled
+	t.Cleanup(func() { fuzzySidecarMatching = saved })
+}
+
+func TestScanTakeoutBuildsSidecarIndex(t *testing.T) {
+	root := t.TempDir()
+	photos := filepath.Join(root, "Takeout", "Google Photos", "Photos from 2019")
+	album := filepath.Join(root, "Takeout", "Google Photos", "Trip")
+
+	writeMedia(t, filepath.Join(photos, "IMG_0001.jpg"))
+	writeSidecar(t, filepath.Join(photos, "IMG_0001.jpg.supplemental-metadata.json"), "IMG_0001.jpg", 1555083012)
+	writeMedia(t, filepath.Join(photos, "IMG_0002(1).jpg"))
+	writeSidecar(t, filepath.Join(photos, "IMG_0002.jpg.supplemental-metadata(1).json"), "IMG_0002.jpg", 1555083013)
+	writeMedia(t, filepath.Join(photos, "IMG_0003.jpg"))
+	writeMedia(t, filepath.Join(album, "IMG_0001.jpg"))
+	writeSidecar(t, filepath.Join(album, "IMG_0001.jpg.json"), "IMG_0001.jpg", 1555083014)
+	if err := os.WriteFile(filepath.Join(album, "metadata.json"), []byte(`{"title": "Trip"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	saveSupportedExtensions(t)
+	setSupportedExtensions([]string{".jpg"}, extSourceFallback)
+
+	mediaFiles, idx, err := scanTakeout(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mediaFiles) != 4 {
+		t.Fatalf("Expected 4 media files, got %d", len(mediaFiles))
+	}
+	if idx.Len() != 4 {
+		t.Errorf("Expected 4 indexed JSON files, got %d", idx.Len())
+	}
+
+	// Every lookup must agree with matching the directory on its own
+	for _, file := range mediaFiles {
+		expected := findSidecarFile(file)
+		got := ""
+		if sidecar := idx.Lookup(file); sidecar != nil {
+			got = sidecar.Path
+		}
+		if got != expected {
+			t.Errorf("%s: index matched %q, directory matching found %q", file.Path, got, expected)
+		}
+	}
+
+	numbered := idx.Lookup(MediaFile{Path: filepath.Join(photos, "IMG_0002(1).jpg"), BaseName: "IMG_0002(1).jpg", Dir: photos})
+	if numbered == nil || numbered.Title != "IMG_0002.jpg" || numbered.Date.Unix() != 1555083013 {
+		t.Errorf("Expected parsed numbered sidecar, got %+v", numbered)
+	}
+	if len(numbered.Candidates) != 1 || numbered.Candidates[0] != filepath.Join(photos, "IMG_0002(1).jpg") {
+		t.Errorf("Expected the numbered file as only candidate, got %v", numbered.Candidates)
+	}
+
+	if metadata := idx.Sidecar(filepath.Join(album, "metadata.json")); metadata == nil || metadata.Valid {
+		t.Errorf("Expected album metadata to be indexed but not valid as a sidecar, got %+v", metadata)
+	}
+}
+
+func TestProcessMediaFileUsesSidecarIndex(t *testing.T) {
+	sourceDir := t.TempDir()
+	mediaPath := filepath.Join(sourceDir, "IMG_0001.jpg")
+	sidecarPath := mediaPath + ".supplemental-metadata.json"
+	writeMedia(t, mediaPath)
+	writeSidecar(t, sidecarPath, "IMG_0001.jpg", 1555083012)
+
+	saveSupportedExtensions(t)
+	setSupportedExtensions([]string{".jpg"}, extSourceFallback)
+
+	mediaFiles, idx, err := scanTakeout(sourceDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The sidecar is parsed while indexing and not read again
+	if err := os.Remove(sidecarPath); err != nil {
+		t.Fatal(err)
+	}
+
+	backend := NewMemoryBackend()
+	config := &Config{SourceDir: sourceDir, OutputDir: sourceDir, Workers: 1, Sidecars: idx}
+	result := processMediaFile(config, backend, mediaFiles[0])
+	if !result.Success {
+		t.Fatalf("Expected processing to succeed from the index, got %v", result.Error)
+	}
+	if got := backend.Tags(mediaPath)["DateTimeOriginal"]; got != time.Unix(1555083012, 0).Format(exifDateFormat) {
+		t.Errorf("Expected indexed sidecar date to be written, got %q", got)
+	}
+}
+
+func TestSidecarAssignmentIsExclusive(t *testing.T) {
+	root := t.TempDir()
+	files := []string{
+		"IMG_1.jpg",
+		"IMG_1(1).jpg",
+		"IMG_2.jpg",
+		"IMG_2-edited.jpg",
+		"Screenshot_20190412-153012_Photos_Alpha.jpg",
+		"Screenshot_20190412-153012_Photos_Bravo.jpg",
+	}
+	for _, name := range files {
+		writeMedia(t, filepath.Join(root, name))
+	}
+	writeSidecar(t, filepath.Join(root, "IMG_1.jpg.supplemental-metadata.json"), "IMG_1.jpg", 1)
+	writeSidecar(t, filepath.Join(root, "IMG_2.jpg.supplemental-metadata.json"), "IMG_2.jpg", 2)
+	writeSidecar(t, filepath.Join(root, "Screenshot_20190412-153012_Photos_.json"), "Screenshot", 3)
+
+	// The screenshot sidecar is only matched by a prefix
+	setFuzzySidecarMatching(t, true)
+	saveSupportedExtensions(t)
+	setSupportedExtensions([]string{".jpg"}, extSourceFallback)
+	mediaFiles, idx, err := scanTakeout(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assigned := make(map[string]string)
+	for _, file := range mediaFiles {
+		if sidecar := idx.Lookup(file); sidecar != nil {
+			assigned[file.BaseName] = sidecar.Name
+		}
+	}
+
+	expected := map[string]string{
+		"IMG_1.jpg":        "IMG_1.jpg.supplemental-metadata.json",
+		"IMG_2.jpg":        "IMG_2.jpg.supplemental-metadata.json",
+		"IMG_2-edited.jpg": "IMG_2.jpg.supplemental-metadata.json", // Edited copies share the original's sidecar
+	}
+	for name, sidecar := range expected {
+		if assigned[name] != sidecar {
+			t.Errorf("Expected %s to get %s, got %q", name, sidecar, assigned[name])
+		}
+	}
+	for _, name := range []string{"IMG_1(1).jpg", "Screenshot_20190412-153012_Photos_Alpha.jpg", "Screenshot_20190412-153012_Photos_Bravo.jpg"} {
+		if sidecar, ok := assigned[name]; ok {
+			t.Errorf("Expected %s to get no sidecar, got %s", name, sidecar)
+		}
+	}
+
+	// The shared screenshot prefix is reported, the weaker IMG_1(1).jpg claim is not
+	ambiguities := idx.Ambiguities()
+	if len(ambiguities) != 1 || ambiguities[0].Sidecar.Name != "Screenshot_20190412-153012_Photos_.json" || len(ambiguities[0].Files) != 2 {
+		t.Fatalf("Expected one ambiguity for the screenshot sidecar, got %v", ambiguities)
+	}
+
+	config := &Config{SourceDir: root, OutputDir: root, DryRun: true, Workers: 1, Sidecars: idx}
+	screenshot := MediaFile{Path: filepath.Join(root, files[4]), BaseName: files[4], Dir: root}
+	result := processMediaFile(config, NewMemoryBackend(), screenshot)
+	if !errors.Is(result.Error, ErrAmbiguousSidecar) {
+		t.Errorf("Expected ErrAmbiguousSidecar, got %v", result.Error)
+	}
+}
+
+func TestSidecarTitleMatching(t *testing.T) {
+	root := t.TempDir()
+	title := "Family reunion at the lake house summer 2019 panorama.jpg"
+	truncated := truncatedTakeoutName(title)
+	if truncated != "Family reunion at the lake house summer 201.jpg" {
+		t.Fatalf("Unexpected truncation %q", truncated)
+	}
+
+	// The sidecar name was cut too short for any name-based pattern
+	writeMedia(t, filepath.Join(root, truncated))
+	writeSidecar(t, filepath.Join(root, "Family reunion at the lake.json"), title, 1555083012)
+
+	saveSupportedExtensions(t)
+	setSupportedExtensions([]string{".jpg"}, extSourceFallback)
+	mediaFiles, idx, err := scanTakeout(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	match := idx.Match(mediaFiles[0])
+	if match == nil || match.Method != "title" || match.Strength != strengthTitle {
+		t.Fatalf("Expected a title match, got %v", match)
+	}
+}
+
+func TestRestoreNames(t *testing.T) {
+	tests := []struct {
+		name, title, expected string
+	}{
+		{"Family reunion at the lake house summer 2019 p.jpg", "Family reunion at the lake house summer 2019 panorama.jpg", "Family reunion at the lake house summer 2019 panorama.jpg"},
+		{"Family reunion at the lake house summer 2019 p(1).jpg", "Family reunion at the lake house summer 2019 panorama.jpg", "Family reunion at the lake house summer 2019 panorama(1).jpg"},
+		{"Family reunion at the lake house summe-edited.jpg", "Family reunion at the lake house summer 2019 panorama.jpg", "Family reunion at the lake house summer 2019 panorama-edited.jpg"},
+		{"Family reunion at the lake house summer 2019 p.png", "Family reunion at the lake house summer 2019 panorama.jpg", "Family reunion at the lake house summer 2019 panorama.png"},
+		{"IMG_0001.jpg", "IMG_0001.jpg", "IMG_0001.jpg"},
+		{"IMG_0001.jpg", "Something else entirely.jpg", "IMG_0001.jpg"},
+		{"Trip to a b.jpg", "Trip to a b/c and further along the road.jpg", "Trip to a b_c and further along the road.jpg"},
+	}
+	for _, test := range tests {
+		if got := restoredFileName(test.name, test.title); got != test.expected {
+			t.Errorf("restoredFileName(%q, %q) = %q, expected %q", test.name, test.title, got, test.expected)
+		}
+	}
+
+	sourceDir := t.TempDir()
+	outputDir := t.TempDir()
+	title := "Family reunion at the lake house summer 2019 panorama.jpg"
+	mediaPath := filepath.Join(sourceDir, truncatedTakeoutName(title))
+	writeMedia(t, mediaPath)
+	writeSidecar(t, mediaPath+".json", title, 1555083012)
+
+	// A file already using the original name in the destination folder
+	date := time.Unix(1555083012, 0)
+	existing := generateDestinationPath(outputDir, title, date)
+	writeMedia(t, existing)
+
+	saveSupportedExtensions(t)
+	setSupportedExtensions([]string{".jpg"}, extSourceFallback)
+	mediaFiles, idx, err := scanTakeout(sourceDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &Config{SourceDir: sourceDir, OutputDir: outputDir, Move: outputDir, RestoreNames: true, Workers: 1, Sidecars: idx}
+	result := processMediaFile(config, NewMemoryBackend(), mediaFiles[0])
+	if !result.Success {
+		t.Fatalf("Expected success, got %v", result.Error)
+	}
+	expected := generateDestinationPath(outputDir, "Family reunion at the lake house summer 2019 panorama(1).jpg", date)
+	if _, err := os.Stat(expected); err != nil {
+		t.Errorf("Expected file moved to %s: %v (action: %s)", expected, err, result.Action)
+	}
+}
+
+func TestUnicodeNormalizedMatching(t *testing.T) {
+	nfd := "Cafe\u0301"
+	nfc := "Caf\u00e9"
+	if normalizeName(nfd) != nfc {
+		t.Fatalf("Expected %q to normalize to %q", nfd, nfc)
+	}
+
+	// Media extracted on macOS in NFD, sidecars named in NFC
+	root := t.TempDir()
+	writeMedia(t, filepath.Join(root, nfd+".jpg"))
+	writeSidecar(t, filepath.Join(root, nfc+".jpg.supplemental-metadata.json"), nfc+".jpg", 1)
+	writeMedia(t, filepath.Join(root, nfd+"-modifie\u0301.jpg"))
+
+	// A CJK title longer than Takeout's limit, truncated by characters
+	cjkTitle := strings.Repeat("東京の夜景", 10) + ".jpg"
+	cjkName := truncatedTakeoutName(cjkTitle)
+	if !utf8.ValidString(cjkName) || utf8.RuneCountInString(cjkName) != maxTakeoutNameLength {
+		t.Fatalf("Expected a valid %d-character name, got %q", maxTakeoutNameLength, cjkName)
+	}
+	writeMedia(t, filepath.Join(root, cjkName))
+	writeSidecar(t, filepath.Join(root, takeoutSidecarName(cjkTitle, supplementalMetadataSuffix, "")), "other", 2)
+
+	// An emoji name whose sidecar stem is cut by Takeout's 46-character rule
+	emojiName := "Sunset 🌅🌅🌅 at the beach with friends 🏖️.jpg"
+	writeMedia(t, filepath.Join(root, emojiName))
+	writeSidecar(t, filepath.Join(root, takeoutSidecarName(emojiName, supplementalMetadataSuffix, "")), "other", 3)
+
+	saveSupportedExtensions(t)
+	setSupportedExtensions([]string{".jpg"}, extSourceFallback)
+	mediaFiles, idx, err := scanTakeout(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dates := make(map[string]int64)
+	for _, file := range mediaFiles {
+		if sidecar := idx.Lookup(file); sidecar != nil {
+			dates[normalizeName(file.BaseName)] = sidecar.Date.Unix()
+		}
+	}
+	expected := map[string]int64{
+		nfc + ".jpg":              1,
+		nfc + "-modifi\u00e9.jpg": 1,
+		cjkName:                   2,
+		emojiName:                 3,
+	}
+	for name, date := range expected {
+		if dates[name] != date {
+			t.Errorf("Expected %s to get the sidecar dated %d, got %d", name, date, dates[name])
+		}
+	}
+
+	if pairs := pairEditedFiles(mediaFiles); len(pairs) != 1 {
+		t.Errorf("Expected the NFD edited copy to pair with its original, got %v", pairs)
+	}
+}
+
+func TestNFCNames(t *testing.T) {
+	sourceDir := t.TempDir()
+	outputDir := t.TempDir()
+	nfd := "Cafe\u0301.jpg"
+	writeMedia(t, filepath.Join(sourceDir, nfd))
+	writeSidecar(t, filepath.Join(sourceDir, "Caf\u00e9.jpg.json"), "Caf\u00e9.jpg", 1555083012)
+
+	saveSupportedExtensions(t)
+	setSupportedExtensions([]string{".jpg"}, extSourceFallback)
+	mediaFiles, idx, err := scanTakeout(sourceDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &Config{SourceDir: sourceDir, OutputDir: outputDir, Move: outputDir, NFCNames: true, Workers: 1, Sidecars: idx}
+	result := processMediaFile(config, NewMemoryBackend(), mediaFiles[0])
+	if !result.Success {
+		t.Fatalf("Expected success, got %v", result.Error)
+	}
+	expected := generateDestinationPath(outputDir, "Caf\u00e9.jpg", time.Unix(1555083012, 0))
+	if result.DestPath != expected {
+		t.Errorf("Expected NFC destination %q, got %q", expected, result.DestPath)
+	}
+	if _, err := os.Stat(expected); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestScanTakeoutAcrossParts(t *testing.T) {
+	part1 := t.TempDir()
+	part2 := t.TempDir()
+	album := filepath.Join("Takeout", "Google Photos", "Trip")
+
+	// The media landed in the first part, its sidecar and the album metadata in the second
+	writeMedia(t, filepath.Join(part1, album, "IMG_0001.jpg"))
+	writeMedia(t, filepath.Join(part1, album, "IMG_0002.jpg"))
+	writeSidecar(t, filepath.Join(part2, album, "IMG_0001.jpg.supplemental-metadata.json"), "IMG_0001.jpg", 1555083012)
+	writeSidecar(t, filepath.Join(part1, album, "IMG_0002.jpg.supplemental-metadata.json"), "IMG_0002.jpg", 1555083013)
+	if err := os.WriteFile(filepath.Join(part2, album, "metadata.json"), []byte(`{"title": "Trip"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The same album path in another part doesn't mix with an unrelated folder
+	writeSidecar(t, filepath.Join(part2, "Takeout", "Google Photos", "Other", "IMG_0003.jpg.json"), "IMG_0003.jpg", 1)
+
+	saveSupportedExtensions(t)
+	setSupportedExtensions([]string{".jpg"}, extSourceFallback)
+	mediaFiles, idx, err := scanTakeout(part1, part2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mediaFiles) != 2 || idx.Len() != 4 {
+		t.Fatalf("Expected 2 media files and 4 JSON files, got %d and %d", len(mediaFiles), idx.Len())
+	}
+
+	for _, file := range mediaFiles {
+		sidecar := idx.Lookup(file)
+		if sidecar == nil || sidecar.Title != file.BaseName {
+			t.Errorf("Expected %s to find its sidecar across parts, got %+v", file.Path, sidecar)
+		}
+	}
+
+	config := &Config{SourceDirs: []string{part1, part2}, Sidecars: idx}
+	if got := albumFor(config, filepath.Join(part1, album)); got == nil || got.Title != "Trip" {
+		t.Errorf("Expected album metadata from the other part, got %+v", got)
+	}
+
+	// Reports name the file in the part it was found in
+	if sidecar := idx.Sidecar(filepath.Join(part2, album, "IMG_0001.jpg.supplemental-metadata.json")); sidecar == nil {
+		t.Error("Expected the sidecar to be found by its own path")
+	}
+	if orphans := idx.Orphans(); len(orphans) != 1 || orphans[0].Title != "IMG_0003.jpg" {
+		t.Errorf("Expected only the unrelated sidecar as orphan, got %+v", orphans)
+	}
+}